@@ -1,71 +1,379 @@
 package conf
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
-func CheckFlags() {
-	dbUser := flag.String("dbUser", "", "Required")
-	dbPassword := flag.String("dbPassword", "", "Required")
-	dbName := flag.String("dbName", "", "Required")
-	dbIp := flag.String("dbIp", "", "Required")
-	dbPort := flag.String("dbPort", "3306", "Required")
-	outputPath := flag.String("outputPath", "", "Required: path where .go files will be created.")
-	queriesPath := flag.String("queriesPath", "", "Optional: path to directory containing .sql query files.")
-	flag.Parse()
+// UsageError reports a wrong invocation, as opposed to a failure while running a command.
+type UsageError struct {
+	Msg string
+}
 
+func (e *UsageError) Error() string {
+	return e.Msg
+}
+
+type command struct {
+	name    string
+	usage   string
+	summary string
+	flags   func(fs *flag.FlagSet, a *Arguments)
+	check   func(fs *flag.FlagSet, a *Arguments) []string // returns the missing required flags
+	connect func(a *Arguments) bool                       // reports whether the command connects to a server
+}
+
+var commands = []command{
+	{
+		name:    CommandGenerate,
+		usage:   "margo [generate] [flags]",
+		summary: "Generate Go code for every table of a database, DDL dump or snapshot.",
+		flags: func(fs *flag.FlagSet, a *Arguments) {
+			connectionFlags(fs, a)
+			sourceFlags(fs, a)
+			fs.StringVar(&a.SnapshotPath, "snapshotPath", "", "Optional: JSON schema snapshot to generate from; the file written by the snapshot command.")
+			outputFlags(fs, a)
+			fs.BoolVar(&a.DryRun, "dryRun", false, "Optional: print the generated files with a unified diff against the existing output without writing anything.")
+			fs.BoolVar(&a.Check, "check", false, "Optional: exit with a non-zero code when the generated output is stale, without writing anything.")
+		},
+		check: func(fs *flag.FlagSet, a *Arguments) []string {
+			// a snapshot records its own schema name
+			if a.SnapshotPath != "" {
+				return missingFlags(fs, "outputPath")
+			}
+			if a.SchemaPath != "" {
+				return missingFlags(fs, "dbName", "outputPath")
+			}
+			return append(missingConnectionFlags(fs, a), missingFlags(fs, "dbName", "outputPath")...)
+		},
+		connect: func(a *Arguments) bool { return a.SnapshotPath == "" && a.SchemaPath == "" },
+	},
+	{
+		name:    CommandSnapshot,
+		usage:   "margo snapshot [flags]",
+		summary: "Write the schema and the named queries to a versioned JSON file.",
+		flags: func(fs *flag.FlagSet, a *Arguments) {
+			connectionFlags(fs, a)
+			sourceFlags(fs, a)
+			fs.StringVar(&a.QueriesPath, "queriesPath", "", "Optional: path to directory containing .sql query files.")
+			fs.StringVar(&a.SnapshotPath, "snapshotPath", "", "Required: file the snapshot is written to.")
+		},
+		check: func(fs *flag.FlagSet, a *Arguments) []string {
+			if a.SchemaPath != "" {
+				return missingFlags(fs, "dbName", "snapshotPath")
+			}
+			return append(missingConnectionFlags(fs, a), missingFlags(fs, "dbName", "snapshotPath")...)
+		},
+		connect: func(a *Arguments) bool { return a.SchemaPath == "" },
+	},
+	{
+		name:    CommandDiff,
+		usage:   "margo diff -from=<schema> -to=<schema> [flags]",
+		summary: "Compare two schemas, each a .json snapshot, a .sql dump, db:<name> or dsn:<DSN>, and optionally write a migration.",
+		flags: func(fs *flag.FlagSet, a *Arguments) {
+			connectionFlags(fs, a)
+			fs.StringVar(&a.DiffFrom, "from", "", "Required: old schema, a .json snapshot, a .sql dump, db:<schema> or dsn:<DSN> of its own server.")
+			fs.StringVar(&a.DiffTo, "to", "", "Required: new schema, a .json snapshot, a .sql dump, db:<schema> or dsn:<DSN> of its own server.")
+			fs.StringVar(&a.DiffFormat, "format", "text", "Optional: output format, text, json or sql.")
+			fs.StringVar(&a.MigrationsPath, "migrationsPath", "", "Optional: directory where the numbered up and down migration files are written.")
+			fs.StringVar(&a.MigrationName, "migrationName", "schema_change", "Optional: name of the written migration.")
+			fs.StringVar(&a.MigrationsTable, "migrationsTable", "margo_migrations", "Optional: migration bookkeeping table, left out of the comparison.")
+		},
+		check: func(fs *flag.FlagSet, a *Arguments) []string {
+			missing := missingFlags(fs, "from", "to")
+			if strings.HasPrefix(a.DiffFrom, "db:") || strings.HasPrefix(a.DiffTo, "db:") {
+				missing = append(missing, missingConnectionFlags(fs, a)...)
+			}
+			return missing
+		},
+		connect: func(a *Arguments) bool {
+			return strings.HasPrefix(a.DiffFrom, "db:") || strings.HasPrefix(a.DiffTo, "db:")
+		},
+	},
+	{
+		name:    CommandMigrate,
+		usage:   "margo migrate up|down|status [flags]",
+		summary: "Apply, revert or list the numbered migrations of a directory.",
+		flags: func(fs *flag.FlagSet, a *Arguments) {
+			connectionFlags(fs, a)
+			fs.StringVar(&a.MigrationsPath, "migrationsPath", "", "Required: directory with the numbered up and down migration files.")
+			fs.StringVar(&a.MigrationsTable, "migrationsTable", "margo_migrations", "Optional: table where applied migrations are recorded, it is never generated.")
+			fs.IntVar(&a.MigrateSteps, "steps", 0, "Optional: number of migrations to apply or revert, 0 means all pending for up and one for down.")
+			fs.BoolVar(&a.Regenerate, "regenerate", false, "Optional: regenerate the code into -outputPath after a successful up or down.")
+			outputFlags(fs, a)
+		},
+		check: func(fs *flag.FlagSet, a *Arguments) []string {
+			required := []string{"dbName", "migrationsPath"}
+			if a.Regenerate {
+				required = append(required, "outputPath")
+			}
+			return append(missingConnectionFlags(fs, a), missingFlags(fs, required...)...)
+		},
+		connect: func(a *Arguments) bool { return true },
+	},
+	{
+		name:    CommandVersion,
+		usage:   "margo version",
+		summary: "Print the margo version and build information.",
+		flags:   func(fs *flag.FlagSet, a *Arguments) {},
+		check:   func(fs *flag.FlagSet, a *Arguments) []string { return nil },
+		connect: func(a *Arguments) bool { return false },
+	},
+}
+
+func sourceFlags(fs *flag.FlagSet, a *Arguments) {
+	fs.StringVar(&a.SchemaPath, "schemaPath", "", "Optional: .sql file or directory with CREATE TABLE statements, used instead of a database connection.")
+	fs.StringVar(&a.MigrationsTable, "migrationsTable", "margo_migrations", "Optional: migration bookkeeping table, it is never generated or snapshotted.")
+}
+
+// outputFlags are the flags of code generation, shared with migrate -regenerate.
+func outputFlags(fs *flag.FlagSet, a *Arguments) {
+	fs.StringVar(&a.OutputPath, "outputPath", "", "Required: path where .go files will be created.")
+	if fs.Lookup("queriesPath") == nil {
+		fs.StringVar(&a.QueriesPath, "queriesPath", "", "Optional: path to directory containing .sql query files.")
+	}
+	fs.StringVar(&a.Naming, "naming", "legacy", "Optional: naming strategy for Go identifiers, legacy (UserId) or go (UserID).")
+	fs.Func("initialisms", "Optional: comma separated list of extra initialisms for -naming=go.", func(v string) error {
+		a.Initialisms = SplitList(v)
+		return nil
+	})
+	fs.StringVar(&a.NamingOverridesPath, "namingOverrides", "", "Optional: path to a JSON file with package, entity and field name overrides.")
+	fs.StringVar(&a.OnInvalidName, "onInvalidName", "fail", "Optional: fail or escape when a table or column maps to an invalid or clashing Go identifier.")
+	fs.BoolVar(&a.SingularEntity, "singularEntity", false, "Optional: name each struct after its table in singular form instead of Entity.")
+	fs.BoolVar(&a.Prune, "prune", true, "Optional: remove generated files of tables that no longer exist.")
+	fs.BoolVar(&a.OmitSchema, "omitSchema", false, "Optional: leave the schema out of FQTN, so the generated code runs against the schema the connection selects.")
+	fs.Func("versionColumn", "Optional: comma separated list of integer or timestamp columns DBUpdate uses for optimistic locking, as column for every table that has it or table.column.", func(v string) error {
+		a.VersionColumns = SplitList(v)
+		return nil
+	})
+	fs.Func("softDeleteColumn", "Optional: comma separated list of nullable timestamp columns DBDelete sets instead of deleting the row, as column for every table that has it or table.column.", func(v string) error {
+		a.SoftDeleteColumns = SplitList(v)
+		return nil
+	})
+	fs.Func("uuidColumn", "Optional: comma separated list of uuid, char or varchar columns DBInsert fills with margort.NewUUID when empty, as column or table.column.", func(v string) error {
+		a.UUIDColumns = SplitList(v)
+		return nil
+	})
+	fs.Func("createdColumn", "Optional: comma separated list of timestamp columns DBInsert fills with margort.Now when empty, as column or table.column.", func(v string) error {
+		a.CreatedColumns = SplitList(v)
+		return nil
+	})
+	fs.Func("updatedColumn", "Optional: comma separated list of timestamp columns DBInsert fills with margort.Now when empty and DBUpdate always sets, as column or table.column.", func(v string) error {
+		a.UpdatedColumns = SplitList(v)
+		return nil
+	})
+	fs.BoolVar(&a.InsertReturning, "insertReturning", false, "Optional: DBInsert reads the inserted row back into the entity with INSERT ... RETURNING (MariaDB 10.5+).")
+	fs.StringVar(&a.API, "api", APIVariants, "Optional: variants generates every operation as Plain, Ctx, Tx and CtxTx functions, context generates one taking a context that may carry the transaction.")
+}
+
+func missingFlags(fs *flag.FlagSet, names ...string) []string {
 	var missing []string
+	for _, name := range names {
+		if f := fs.Lookup(name); f != nil && f.Value.String() == "" {
+			missing = append(missing, "-"+name)
+		}
+	}
+	return missing
+}
 
-	if *dbUser == "" {
-		missing = append(missing, "-dbUser")
+// Parse reads the command and its flags from args, without the program name, into Args. It returns flag.ErrHelp
+// when help was requested and a *UsageError when the invocation is wrong; Args is only set on success.
+func Parse(args []string, output io.Writer) error {
+	if len(args) == 0 {
+		printUsage(output)
+		return &UsageError{Msg: "missing command or flags"}
 	}
-	if *dbPassword == "" {
-		missing = append(missing, "-dbPassword")
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		if len(args) > 1 {
+			// margo help <command>
+			args = []string{args[1], "-h"}
+		} else {
+			printUsage(output)
+			return flag.ErrHelp
+		}
 	}
-	if *dbName == "" {
-		missing = append(missing, "-dbName")
+
+	// generating code is the default command, so plain flags keep working
+	name := CommandGenerate
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name = args[0]
+		args = args[1:]
 	}
-	if *dbIp == "" {
-		missing = append(missing, "-dbIp")
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
 	}
-	if *dbPort == "" {
-		missing = append(missing, "-dbPort")
+	if cmd == nil {
+		printUsage(output)
+		return &UsageError{Msg: "unknown command '" + name + "'"}
 	}
-	if *outputPath == "" {
-		missing = append(missing, "-outputPath")
+
+	a := Arguments{Command: name}
+	if name == CommandMigrate && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		a.MigrateAction = args[0]
+		args = args[1:]
 	}
 
-	if len(missing) > 0 {
-		fmt.Fprintln(os.Stderr, "Missing required arguments:")
-		for _, arg := range missing {
-			fmt.Fprintln(os.Stderr, " ", arg)
+	fs := flag.NewFlagSet("margo "+name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	cmd.flags(fs, &a)
+	fs.Usage = func() {
+		fmt.Fprintln(output, "Usage: "+cmd.usage)
+		fmt.Fprintln(output)
+		fmt.Fprintln(output, cmd.summary)
+		fmt.Fprintln(output)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
 		}
-		flag.Usage()
-		return
+		return &UsageError{Msg: err.Error()}
+	}
+	if name == CommandMigrate && a.MigrateAction == "" && fs.NArg() > 0 {
+		a.MigrateAction = fs.Arg(0)
+	}
+	if err := applyConnectionDefaults(fs, &a, cmd.connect(&a), output); err != nil {
+		return err
+	}
+
+	if missing := cmd.check(fs, &a); len(missing) > 0 {
+		fs.Usage()
+		return &UsageError{Msg: "missing required arguments: " + strings.Join(missing, ", ")}
+	}
+	if err := validate(&a); err != nil {
+		return err
 	}
 
+	Args = a
+	return nil
+}
+
+func validate(a *Arguments) error {
 	// Validate queriesPath is a directory if specified
-	if *queriesPath != "" {
-		info, err := os.Stat(*queriesPath)
+	if a.QueriesPath != "" {
+		info, err := os.Stat(a.QueriesPath)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: queriesPath '%s' does not exist or is not accessible: %v\n", *queriesPath, err)
-			return
+			return &UsageError{Msg: fmt.Sprintf("queriesPath '%s' does not exist or is not accessible: %v", a.QueriesPath, err)}
 		}
 		if !info.IsDir() {
-			fmt.Fprintf(os.Stderr, "Error: queriesPath '%s' must be a directory, not a file\n", *queriesPath)
-			return
+			return &UsageError{Msg: fmt.Sprintf("queriesPath '%s' must be a directory, not a file", a.QueriesPath)}
 		}
 	}
 
-	Args.DBUser = *dbUser
-	Args.DBPassword = *dbPassword
-	Args.DBName = *dbName
-	Args.DBIp = *dbIp
-	Args.DBPort = *dbPort
-	Args.OutputPath = *outputPath
-	Args.QueriesPath = *queriesPath // can be empty
+	switch a.TLS {
+	case "", "true", "false", "skip-verify", "preferred":
+	default:
+		return &UsageError{Msg: fmt.Sprintf("tls '%s' must be true, false, skip-verify or preferred", a.TLS)}
+	}
+	if (a.TLSCert == "") != (a.TLSKey == "") {
+		return &UsageError{Msg: "tlsCert and tlsKey must be given together"}
+	}
+
+	if err := validateSchemas(a); err != nil {
+		return err
+	}
+
+	if a.Command == CommandMigrate && a.MigrateAction != MigrateUp && a.MigrateAction != MigrateDown && a.MigrateAction != MigrateStatus {
+		return &UsageError{Msg: fmt.Sprintf("migrate needs one of %s, %s or %s", MigrateUp, MigrateDown, MigrateStatus)}
+	}
+
+	if a.API != "" && a.API != APIVariants && a.API != APIContext {
+		return &UsageError{Msg: fmt.Sprintf("api '%s' must be %s or %s", a.API, APIVariants, APIContext)}
+	}
+
+	if a.Command == CommandDiff && a.DiffFormat != "text" && a.DiffFormat != "json" && a.DiffFormat != "sql" {
+		return &UsageError{Msg: fmt.Sprintf("format '%s' must be text, json or sql", a.DiffFormat)}
+	}
+
+	return nil
+}
+
+// validateSchemas splits -dbName into Schemas. Only generate takes several schemas, each paired by position with
+// a -schemaPath or -snapshotPath file when those are lists.
+func validateSchemas(a *Arguments) error {
+	a.Schemas = SplitList(a.DBName)
+	if len(a.Schemas) > 0 {
+		a.DBName = a.Schemas[0]
+	}
+	seen := map[string]bool{}
+	for _, s := range a.Schemas {
+		if seen[s] {
+			return &UsageError{Msg: fmt.Sprintf("dbName lists '%s' twice", s)}
+		}
+		seen[s] = true
+	}
+
+	if a.Command != CommandGenerate {
+		if len(a.Schemas) > 1 {
+			return &UsageError{Msg: a.Command + " works on a single dbName"}
+		}
+		return nil
+	}
+
+	schemaPaths := SplitList(a.SchemaPath)
+	if len(a.Schemas) > 1 && len(schemaPaths) > 0 && len(schemaPaths) != len(a.Schemas) {
+		return &UsageError{Msg: fmt.Sprintf("schemaPath needs one file per dbName, got %d for %d", len(schemaPaths), len(a.Schemas))}
+	}
+	if len(schemaPaths) > 1 && len(schemaPaths) != len(a.Schemas) {
+		return &UsageError{Msg: fmt.Sprintf("schemaPath needs one dbName per file, got %d for %d", len(a.Schemas), len(schemaPaths))}
+	}
+	// snapshots record their own schema, -dbName only renames them
+	snapshotPaths := SplitList(a.SnapshotPath)
+	if len(snapshotPaths) > 0 && len(a.Schemas) > 0 && len(snapshotPaths) != len(a.Schemas) {
+		return &UsageError{Msg: fmt.Sprintf("snapshotPath needs one file per dbName, got %d for %d", len(snapshotPaths), len(a.Schemas))}
+	}
+
+	return nil
+}
+
+func printUsage(output io.Writer) {
+	fmt.Fprintln(output, "Usage: margo <command> [flags]")
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(output, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, "Run 'margo <command> -h' for the flags of a command. Without a command margo generates code.")
+}
+
+// CheckFlags parses the generate flags from the process command line into Args. Tests use it to pick up the
+// connection flags passed to go test.
+func CheckFlags() error {
+	a := Arguments{Command: CommandGenerate}
+	commands[0].flags(flag.CommandLine, &a)
+	flag.Parse()
+	if err := applyConnectionDefaults(flag.CommandLine, &a, commands[0].connect(&a), os.Stderr); err != nil {
+		return err
+	}
+
+	if missing := commands[0].check(flag.CommandLine, &a); len(missing) > 0 {
+		return &UsageError{Msg: "missing required arguments: " + strings.Join(missing, ", ")}
+	}
+	if err := validate(&a); err != nil {
+		return err
+	}
+
+	Args = a
+	return nil
+}
+
+// SplitList splits a comma separated flag value, dropping empty items.
+func SplitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
 }
@@ -0,0 +1,165 @@
+package conf
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	defer func() { Args = Arguments{} }()
+
+	err := Parse([]string{"-dbName=app", "-schemaPath=schema.sql", "-outputPath=out", "-initialisms=sku, ean", "-versionColumn=version, alpha.last_update", "-uuidColumn=alpha.uuid", "-updatedColumn=updated_at", "-insertReturning"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Args.Command != CommandGenerate || Args.DBName != "app" || Args.Naming != "legacy" || !Args.Prune || Args.API != APIVariants || !Args.InsertReturning || Args.MigrationsTable != "margo_migrations" {
+		t.Errorf("unexpected arguments: %+v", Args)
+	}
+	if len(Args.Initialisms) != 2 || Args.Initialisms[1] != "ean" {
+		t.Errorf("unexpected initialisms: %v", Args.Initialisms)
+	}
+	if len(Args.VersionColumns) != 2 || Args.VersionColumns[1] != "alpha.last_update" {
+		t.Errorf("unexpected version columns: %v", Args.VersionColumns)
+	}
+	if len(Args.SoftDeleteColumns) != 0 {
+		t.Errorf("unexpected soft delete columns: %v", Args.SoftDeleteColumns)
+	}
+	if len(Args.UUIDColumns) != 1 || len(Args.CreatedColumns) != 0 || len(Args.UpdatedColumns) != 1 {
+		t.Errorf("unexpected autofill columns: %v %v %v", Args.UUIDColumns, Args.CreatedColumns, Args.UpdatedColumns)
+	}
+
+	err = Parse([]string{"migrate", "up", "-dbUser=u", "-dbPassword=p", "-dbName=app", "-dbIp=127.0.0.1", "-migrationsPath=m", "-steps=2"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Args.Command != CommandMigrate || Args.MigrateAction != MigrateUp || Args.MigrateSteps != 2 || Args.MigrationsTable != "margo_migrations" {
+		t.Errorf("unexpected arguments: %+v", Args)
+	}
+
+	err = Parse([]string{"-dbName=app, billing", "-schemaPath=app.sql,billing.sql", "-outputPath=out", "-omitSchema", "-softDeleteColumn=deleted_at, users.removed_at"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(Args.SoftDeleteColumns) != 2 || Args.SoftDeleteColumns[1] != "users.removed_at" {
+		t.Errorf("unexpected soft delete columns: %v", Args.SoftDeleteColumns)
+	}
+	if Args.DBName != "app" || len(Args.Schemas) != 2 || Args.Schemas[1] != "billing" || !Args.OmitSchema {
+		t.Errorf("unexpected arguments: %+v", Args)
+	}
+
+	if err = Parse([]string{"version"}, io.Discard); err != nil || Args.Command != CommandVersion {
+		t.Errorf("unexpected result for version: %v, %+v", err, Args)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	defer func() { Args = Arguments{} }()
+
+	tests := [][]string{
+		{},
+		{"bogus"},
+		{"-dbName=app", "-outputPath=out"},
+		{"snapshot", "-dbName=app", "-schemaPath=schema.sql"},
+		{"diff", "-from=a.json", "-to=db:app"},
+		{"diff", "-from=a.json", "-to=b.json", "-format=xml"},
+		{"migrate", "sideways", "-dbUser=u", "-dbPassword=p", "-dbName=app", "-dbIp=127.0.0.1", "-migrationsPath=m"},
+		{"generate", "-unknownFlag"},
+		{"-dbName=app,billing", "-schemaPath=app.sql", "-outputPath=out"},
+		{"-dbName=app,app", "-schemaPath=a.sql,b.sql", "-outputPath=out"},
+		{"-dbName=app,billing", "-snapshotPath=app.json", "-outputPath=out"},
+		{"snapshot", "-dbName=app,billing", "-schemaPath=schema.sql", "-snapshotPath=s.json"},
+		{"-dbName=app", "-schemaPath=schema.sql", "-outputPath=out", "-api=fluent"},
+	}
+
+	for _, args := range tests {
+		Args = Arguments{}
+		var usageErr *UsageError
+		if err := Parse(args, io.Discard); !errors.As(err, &usageErr) {
+			t.Errorf("expected usage error for %v, got %v", args, err)
+		}
+		if Args.Command != "" {
+			t.Errorf("arguments were set for %v: %+v", args, Args)
+		}
+	}
+
+	for _, args := range [][]string{{"-h"}, {"help", "diff"}, {"migrate", "-help"}} {
+		if err := Parse(args, io.Discard); !errors.Is(err, flag.ErrHelp) {
+			t.Errorf("expected flag.ErrHelp for %v, got %v", args, err)
+		}
+	}
+}
+
+func TestParseConnectionDefaults(t *testing.T) {
+	defer func() { Args = Arguments{} }()
+
+	cnf := filepath.Join(t.TempDir(), "my.cnf")
+	content := "" +
+		"[mysqld]\n" +
+		"user = server\n" +
+		"[client]\n" +
+		"user = from_file\n" +
+		"password = \"p@ss/word\"\n" +
+		"host = db.internal\n" +
+		"# comment\n" +
+		"[mysql]\n" +
+		"ssl_ca = /etc/ca.pem\n"
+	if err := os.WriteFile(cnf, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MARGO_DB_USER", "from_env")
+	t.Setenv("MARGO_DB_NAME", "app")
+
+	err := Parse([]string{"snapshot", "-optionsFile=" + cnf, "-dbPort=3307", "-snapshotPath=s.json"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Args.DBUser != "from_env" || Args.DBPassword != "p@ss/word" || Args.DBIp != "db.internal" ||
+		Args.DBPort != "3307" || Args.DBName != "app" || Args.TLSCA != "/etc/ca.pem" {
+		t.Errorf("unexpected arguments: %+v", Args)
+	}
+
+	// flags win over the environment, the schema of a DSN counts as -dbName
+	t.Setenv("MARGO_DB_NAME", "")
+	err = Parse([]string{"snapshot", "-optionsFile=" + cnf, "-dsn=u:p@unix(/run/mysqld.sock)/shop", "-snapshotPath=s.json", "-dbUser=flag"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Args.DBUser != "flag" || Args.DBName != "shop" {
+		t.Errorf("unexpected arguments: %+v", Args)
+	}
+
+	// the database of the option file never replaces the schema of a DSN
+	os.Unsetenv("MARGO_DB_NAME")
+	if err = os.WriteFile(cnf, []byte("[client]\ndatabase = other\nuser = from_file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var log strings.Builder
+	err = Parse([]string{"snapshot", "-optionsFile=" + cnf, "-dsn=u:p@unix(/run/mysqld.sock)/shop", "-snapshotPath=s.json"}, &log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Args.DBName != "shop" || !strings.Contains(log.String(), cnf) {
+		t.Errorf("unexpected arguments: %+v, log %q", Args, log.String())
+	}
+
+	// commands that do not connect never read the option file
+	err = Parse([]string{"-optionsFile=" + cnf, "-dbName=app", "-schemaPath=schema.sql", "-outputPath=out"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Args.DBUser == "from_file" || Args.DBName != "app" {
+		t.Errorf("unexpected arguments: %+v", Args)
+	}
+	err = Parse([]string{"diff", "-optionsFile=" + cnf, "-from=a.json", "-to=dsn:u:p@tcp(h:3306)/app"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Args.DBUser == "from_file" {
+		t.Errorf("unexpected arguments: %+v", Args)
+	}
+}
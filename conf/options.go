@@ -0,0 +1,163 @@
+package conf
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Environment variables read for connection flags that were not given on the command line.
+var envFlags = map[string]string{
+	"dsn":        "MARGO_DSN",
+	"dbUser":     "MARGO_DB_USER",
+	"dbPassword": "MARGO_DB_PASSWORD",
+	"dbName":     "MARGO_DB_NAME",
+	"dbIp":       "MARGO_DB_IP",
+	"dbPort":     "MARGO_DB_PORT",
+	"dbSocket":   "MARGO_DB_SOCKET",
+}
+
+// Option file keys, as used by the mysql and mariadb clients, mapped to flags.
+var optionFileFlags = map[string]string{
+	"user":     "dbUser",
+	"password": "dbPassword",
+	"database": "dbName",
+	"host":     "dbIp",
+	"port":     "dbPort",
+	"socket":   "dbSocket",
+	"ssl-ca":   "tlsCA",
+	"ssl-cert": "tlsCert",
+	"ssl-key":  "tlsKey",
+}
+
+// Option file groups margo reads, later groups win.
+var optionFileGroups = map[string]bool{"client": true, "client-mariadb": true, "mysql": true, "margo": true}
+
+func connectionFlags(fs *flag.FlagSet, a *Arguments) {
+	fs.StringVar(&a.DSN, "dsn", "", "Optional: full data source name, e.g. user:pass@unix(/run/mysqld/mysqld.sock)/app?parseTime=true. Replaces the other connection flags.")
+	fs.StringVar(&a.DBUser, "dbUser", "", "Database user.")
+	fs.StringVar(&a.DBPassword, "dbPassword", "", "Database password.")
+	fs.StringVar(&a.DBName, "dbName", "", "Database name, generate accepts a comma separated list of schemas.")
+	fs.StringVar(&a.DBIp, "dbIp", "", "Database IP address or host name.")
+	fs.StringVar(&a.DBPort, "dbPort", "3306", "Database port.")
+	fs.StringVar(&a.DBSocket, "dbSocket", "", "Optional: Unix socket path, used instead of -dbIp and -dbPort.")
+	fs.StringVar(&a.DBParams, "dbParams", "", "Optional: extra DSN parameters, e.g. charset=utf8mb4&collation=utf8mb4_unicode_ci&timeout=5s.")
+	fs.StringVar(&a.TLS, "tls", "", "Optional: true, false, skip-verify or preferred. Implied by -tlsCA, -tlsCert or -tlsServerName unless false.")
+	fs.StringVar(&a.TLSCA, "tlsCA", "", "Optional: PEM file with the CA that signed the server certificate.")
+	fs.StringVar(&a.TLSCert, "tlsCert", "", "Optional: PEM client certificate, requires -tlsKey.")
+	fs.StringVar(&a.TLSKey, "tlsKey", "", "Optional: PEM client key, requires -tlsCert.")
+	fs.StringVar(&a.TLSServerName, "tlsServerName", "", "Optional: server name to verify the certificate against, defaults to the host.")
+	fs.StringVar(&a.OptionsFile, "optionsFile", "", "Optional: my.cnf style file with [client] credentials, ~/.my.cnf is read when it exists. Only read when the command connects.")
+}
+
+// applyConnectionDefaults fills the connection flags that were not set on the command line, first from the
+// environment and then, when the command connects, from the option file, whose path is logged to output. The
+// option file never sets -dbName when -schemaPath or -dsn give the schema.
+func applyConnectionDefaults(fs *flag.FlagSet, a *Arguments, connect bool, output io.Writer) error {
+	if fs.Lookup("dbUser") == nil {
+		return nil
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for name, env := range envFlags {
+		if v, ok := os.LookupEnv(env); ok && !set[name] {
+			if err := fs.Set(name, v); err != nil {
+				return &UsageError{Msg: env + ": " + err.Error()}
+			}
+			set[name] = true
+		}
+	}
+
+	path := a.OptionsFile
+	if path == "" && connect {
+		if home, err := os.UserHomeDir(); err == nil {
+			if _, err = os.Stat(filepath.Join(home, ".my.cnf")); err == nil {
+				path = filepath.Join(home, ".my.cnf")
+			}
+		}
+	}
+	if path != "" && connect {
+		options, err := ReadOptionFile(path)
+		if err != nil {
+			return &UsageError{Msg: "optionsFile '" + path + "': " + err.Error()}
+		}
+		fmt.Fprintln(output, "margo: reading connection options from "+path)
+		if a.SchemaPath != "" || a.DSN != "" {
+			set["dbName"] = true
+		}
+		for key, name := range optionFileFlags {
+			if v, ok := options[key]; ok && !set[name] {
+				if err = fs.Set(name, v); err != nil {
+					return &UsageError{Msg: path + ": " + key + ": " + err.Error()}
+				}
+			}
+		}
+	}
+
+	// the schema of a DSN counts as -dbName
+	if a.DSN != "" && a.DBName == "" {
+		cfg, err := mysql.ParseDSN(a.DSN)
+		if err != nil {
+			return &UsageError{Msg: "dsn: " + err.Error()}
+		}
+		a.DBName = cfg.DBName
+	}
+
+	return nil
+}
+
+// ReadOptionFile returns the client options of a my.cnf style file. Keys are lowercased with '_' turned into '-',
+// like the mysql client does.
+func ReadOptionFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	options := map[string]string{}
+	inGroup := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			inGroup = optionFileGroups[strings.ToLower(strings.Trim(line, "[] "))]
+			continue
+		}
+		if !inGroup {
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, "=")
+		key = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), "_", "-")
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		options[key] = value
+	}
+
+	return options, scanner.Err()
+}
+
+// missingConnectionFlags returns the connection flags still needed to reach a server.
+func missingConnectionFlags(fs *flag.FlagSet, a *Arguments) []string {
+	if a.DSN != "" {
+		return nil
+	}
+	if a.DBSocket != "" {
+		// socket authentication needs no password
+		return missingFlags(fs, "dbUser")
+	}
+	return missingFlags(fs, "dbUser", "dbPassword", "dbIp", "dbPort")
+}
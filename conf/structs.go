@@ -3,26 +3,108 @@ package conf
 type Arguments struct {
 	DBUser      string
 	DBPassword  string
-	DBName      string
+	DBName      string   // the schema being worked on, the first of Schemas
+	Schemas     []string // every schema of -dbName, only generate accepts more than one
 	DBIp        string
 	DBPort      string
-	OutputPath  string
-	QueriesPath string
+	DBSocket    string
+	DBParams    string
+	DSN         string
+	OptionsFile string
+
+	TLS           string
+	TLSCA         string
+	TLSCert       string
+	TLSKey        string
+	TLSServerName string
+
+	OutputPath   string
+	QueriesPath  string
+	SchemaPath   string
+	SnapshotPath string
+
+	Command string // CommandGenerate, CommandSnapshot, CommandDiff or CommandMigrate
+
+	DiffFrom   string
+	DiffTo     string
+	DiffFormat string
+
+	MigrationsPath  string
+	MigrationName   string
+	MigrationsTable string
+	MigrateAction   string // MigrateUp, MigrateDown or MigrateStatus
+	MigrateSteps    int
+	Regenerate      bool
+
+	Naming              string
+	Initialisms         []string
+	NamingOverridesPath string
+	SingularEntity      bool
+	OnInvalidName       string
+	OmitSchema          bool
+	API                 string   // APIVariants or APIContext
+	InsertReturning     bool     // DBInsert reads the row back with INSERT ... RETURNING
+	VersionColumns      []string // column or table.column, see template.VersionField
+	SoftDeleteColumns   []string // column or table.column, see template.SoftDeleteField
+	UUIDColumns         []string // column or table.column, see template.ManagedFields
+	CreatedColumns      []string // column or table.column, see template.ManagedFields
+	UpdatedColumns      []string // column or table.column, see template.ManagedFields
+
+	DryRun bool
+	Check  bool
+	Prune  bool
+}
+
+type Table struct {
+	Name        string       `json:"name"`
+	Fields      []TableField `json:"fields"`
+	Indexes     []Index      `json:"indexes,omitempty"`
+	ForeignKeys []ForeignKey `json:"foreignKeys,omitempty"`
+}
+
+// Schema is one database generated into its own package tree.
+type Schema struct {
+	Name    string
+	Tables  []Table
+	Queries []NamedQuery
 }
 
 type TableField struct {
-	Name       string
-	DataType   string
-	ColumnType string
+	Name       string `json:"name"`
+	DataType   string `json:"dataType"`
+	ColumnType string `json:"columnType"`
+	Nullable   bool   `json:"nullable,omitempty"`
+	Default    string `json:"default,omitempty"` // as reported by INFORMATION_SCHEMA: 'text', 0, NULL, current_timestamp(6); empty when there is none
+	Extra      string `json:"extra,omitempty"`   // auto_increment, on update current_timestamp(6), VIRTUAL GENERATED, ...
+
+	GoName string `json:"-"` // resolved by the naming package
+}
+
+type Index struct {
+	Name    string   `json:"name"` // PRIMARY for the primary key
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique,omitempty"`
+	Primary bool     `json:"primary,omitempty"`
+	Kind    string   `json:"kind,omitempty"` // FULLTEXT or SPATIAL, empty for regular indexes
+}
+
+type ForeignKey struct {
+	Name       string   `json:"name"`
+	Columns    []string `json:"columns"`
+	RefSchema  string   `json:"refSchema,omitempty"` // empty when the referenced table lives in the same schema
+	RefTable   string   `json:"refTable"`
+	RefColumns []string `json:"refColumns"`
+	OnDelete   string   `json:"onDelete"`
+	OnUpdate   string   `json:"onUpdate"`
 }
 
 type NamedQuery struct {
-	Name         string
-	Query        string
-	QueryEncoded string
-
-	Params  []string // from -- Params:
-	Returns []string // from -- Returns:
-	Mode    string   // from -- ResultMode: one|many|exec
-	MapAs   string   // from -- MapAs:
+	Name         string `json:"name"`
+	Query        string `json:"query"`
+	QueryEncoded string `json:"-"` // derived from Query
+
+	Params  []string `json:"params,omitempty"`  // from -- Params:
+	Returns []string `json:"returns,omitempty"` // from -- Returns:
+	Mode    string   `json:"mode"`              // from -- ResultMode: one|many|exec
+	MapAs   string   `json:"mapAs,omitempty"`   // from -- MapAs:
 }
@@ -5,3 +5,21 @@ const (
 	ResultModeOne  = "one"
 	ResultModeExec = "exec"
 )
+
+// API styles of the generated operations.
+const (
+	APIVariants = "variants" // Plain, Ctx, Tx and CtxTx variants of every operation
+	APIContext  = "context"  // one variant taking a context, which may carry the transaction
+)
+
+const (
+	CommandGenerate = "generate"
+	CommandSnapshot = "snapshot"
+	CommandDiff     = "diff"
+	CommandMigrate  = "migrate"
+	CommandVersion  = "version"
+
+	MigrateUp     = "up"
+	MigrateDown   = "down"
+	MigrateStatus = "status"
+)
@@ -1,17 +1,37 @@
 package db
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
+	"errors"
+	"net"
+	"os"
 	"runtime"
+	"strings"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/rah-0/nabu"
 
 	"github.com/rah-0/margo/conf"
 )
 
+// tlsConfigName is the name the custom TLS configuration is registered under with the driver.
+const tlsConfigName = "margo"
+
 func Connect() (*sql.DB, error) {
-	conn, err := sql.Open("mysql", conf.Args.DBUser+":"+conf.Args.DBPassword+"@tcp("+conf.Args.DBIp+":"+conf.Args.DBPort+")/"+conf.Args.DBName)
+	dsn, err := DSN()
+	if err != nil {
+		return nil, nabu.FromError(err).Log()
+	}
+
+	return ConnectDSN(dsn)
+}
+
+// ConnectDSN connects to the server of dsn, which is used as is, without the connection flags.
+func ConnectDSN(dsn string) (*sql.DB, error) {
+	conn, err := sql.Open("mysql", dsn)
 	if err != nil {
 		return nil, nabu.FromError(err).Log()
 	}
@@ -26,3 +46,120 @@ func Connect() (*sql.DB, error) {
 
 	return conn, nil
 }
+
+// DSN builds the data source name from -dsn or from the connection flags. Credentials are escaped by the driver,
+// so passwords may contain '@', '/' or ':'.
+func DSN() (string, error) {
+	cfg := mysql.NewConfig()
+	if conf.Args.DSN != "" {
+		var err error
+		if cfg, err = mysql.ParseDSN(conf.Args.DSN); err != nil {
+			return "", nabu.FromError(err).Log()
+		}
+	} else {
+		cfg.User = conf.Args.DBUser
+		cfg.Passwd = conf.Args.DBPassword
+		if conf.Args.DBSocket != "" {
+			cfg.Net = "unix"
+			cfg.Addr = conf.Args.DBSocket
+		} else {
+			cfg.Net = "tcp"
+			cfg.Addr = net.JoinHostPort(conf.Args.DBIp, conf.Args.DBPort)
+		}
+	}
+	if conf.Args.DBName != "" {
+		cfg.DBName = conf.Args.DBName
+	}
+
+	if err := applyTLS(cfg); err != nil {
+		return "", nabu.FromError(err).Log()
+	}
+
+	dsn := cfg.FormatDSN()
+	if conf.Args.DBParams == "" {
+		return dsn, nil
+	}
+
+	// let the driver validate the extra parameters, later ones win over the ones already in the DSN
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	cfg, err := mysql.ParseDSN(dsn + sep + strings.TrimPrefix(conf.Args.DBParams, "?"))
+	if err != nil {
+		return "", nabu.FromError(err).WithArgs(conf.Args.DBParams).Log()
+	}
+
+	return cfg.FormatDSN(), nil
+}
+
+// DSNSchema returns the schema dsn selects. The DSN is left out of the errors, as it may hold a password.
+func DSNSchema(dsn string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", nabu.FromError(err).Log()
+	}
+	if cfg.DBName == "" {
+		return "", nabu.FromError(errors.New("dsn selects no schema")).Log()
+	}
+	return cfg.DBName, nil
+}
+
+// RedactDSN returns dsn with its password masked, to be printed. An invalid dsn is returned masked entirely.
+func RedactDSN(dsn string) string {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "***"
+	}
+	if cfg.Passwd != "" {
+		cfg.Passwd = "***"
+	}
+	return cfg.FormatDSN()
+}
+
+func applyTLS(cfg *mysql.Config) error {
+	// an explicit -tls=false wins over the TLS files, which may come from an option file
+	if conf.Args.TLS == "false" || conf.Args.TLSCA == "" && conf.Args.TLSCert == "" && conf.Args.TLSServerName == "" {
+		if conf.Args.TLS != "" {
+			cfg.TLSConfig = conf.Args.TLS
+		}
+		return nil
+	}
+
+	tc := &tls.Config{
+		ServerName:         conf.Args.TLSServerName,
+		InsecureSkipVerify: conf.Args.TLS == "skip-verify",
+	}
+	if tc.ServerName == "" && cfg.Net != "unix" {
+		if host, _, err := net.SplitHostPort(cfg.Addr); err == nil {
+			tc.ServerName = host
+		}
+	}
+	if conf.Args.TLSCA != "" {
+		pem, err := os.ReadFile(conf.Args.TLSCA)
+		if err != nil {
+			return nabu.FromError(err).WithArgs(conf.Args.TLSCA).Log()
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nabu.FromError(errors.New("no certificate found in CA file")).WithArgs(conf.Args.TLSCA).Log()
+		}
+	}
+	if conf.Args.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(conf.Args.TLSCert, conf.Args.TLSKey)
+		if err != nil {
+			return nabu.FromError(err).WithArgs(conf.Args.TLSCert, conf.Args.TLSKey).Log()
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	if err := mysql.RegisterTLSConfig(tlsConfigName, tc); err != nil {
+		return nabu.FromError(err).Log()
+	}
+	cfg.TLSConfig = tlsConfigName
+	if conf.Args.TLS == "preferred" {
+		cfg.AllowFallbackToPlaintext = true
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/rah-0/margo/conf"
+)
+
+func TestDSN(t *testing.T) {
+	saved := conf.Args
+	defer func() { conf.Args = saved }()
+
+	tests := []struct {
+		args     conf.Arguments
+		expected string
+	}{
+		{
+			conf.Arguments{DBUser: "root", DBPassword: "p@ss/w:rd", DBIp: "127.0.0.1", DBPort: "3306", DBName: "app"},
+			"root:p@ss/w:rd@tcp(127.0.0.1:3306)/app",
+		},
+		{
+			conf.Arguments{DBUser: "root", DBSocket: "/run/mysqld/mysqld.sock", DBName: "app", DBParams: "parseTime=true&charset=utf8mb4"},
+			"root@unix(/run/mysqld/mysqld.sock)/app?charset=utf8mb4&parseTime=true",
+		},
+		{
+			conf.Arguments{DSN: "u:p@tcp(db:3307)/shop?timeout=5s", DBName: "other", TLS: "skip-verify"},
+			"u:p@tcp(db:3307)/other?timeout=5s&tls=skip-verify",
+		},
+		{
+			conf.Arguments{DBUser: "u", DBIp: "::1", DBPort: "3306", TLSServerName: "db.internal"},
+			"u@tcp([::1]:3306)/?tls=margo",
+		},
+		{
+			conf.Arguments{DBUser: "u", DBIp: "h", DBPort: "3306", TLS: "false", TLSCA: "/missing/ca.pem"},
+			"u@tcp(h:3306)/?tls=false",
+		},
+	}
+
+	for _, tt := range tests {
+		conf.Args = tt.args
+		dsn, err := DSN()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dsn != tt.expected {
+			t.Errorf("DSN() = %q; want %q", dsn, tt.expected)
+		}
+	}
+
+	conf.Args = conf.Arguments{DBUser: "u", DBIp: "h", DBPort: "3306", DBParams: "timeout=soon"}
+	if _, err := DSN(); err == nil {
+		t.Error("expected error for an invalid parameter, got nil")
+	}
+}
+
+func TestDSNSchema(t *testing.T) {
+	schema, err := DSNSchema("u:p@tcp(staging:3306)/app?timeout=5s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema != "app" {
+		t.Errorf("DSNSchema() = %q; want %q", schema, "app")
+	}
+	if _, err = DSNSchema("u:p@tcp(staging:3306)/"); err == nil {
+		t.Error("expected error for a DSN without schema, got nil")
+	}
+
+	if got := RedactDSN("u:s3cret@tcp(staging:3306)/app"); got != "u:***@tcp(staging:3306)/app" {
+		t.Errorf("RedactDSN() = %q", got)
+	}
+}
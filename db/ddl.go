@@ -0,0 +1,632 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rah-0/nabu"
+
+	"github.com/rah-0/margo/conf"
+	"github.com/rah-0/margo/util"
+)
+
+const (
+	tokIdent  = iota // bare word or `quoted` identifier
+	tokString        // 'quoted' or "quoted" string
+	tokNumber
+	tokPunct
+)
+
+type token struct {
+	kind   int
+	text   string // unquoted value for identifiers and strings
+	quoted bool   // identifier was backtick quoted, so it is never a keyword
+}
+
+func (t token) is(keyword string) bool {
+	return t.kind == tokIdent && !t.quoted && strings.EqualFold(t.text, keyword)
+}
+
+// sql renders the token back as SQL.
+func (t token) sql() string {
+	switch t.kind {
+	case tokString:
+		return "'" + strings.ReplaceAll(t.text, "'", "''") + "'"
+	case tokIdent:
+		if t.quoted {
+			return "`" + t.text + "`"
+		}
+	}
+	return t.text
+}
+
+// LoadDDL reads CREATE TABLE statements from a .sql file, or from every .sql file in a directory.
+func LoadDDL(path string) ([]conf.Table, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nabu.FromError(err).WithArgs(path).Log()
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = util.GetSQLFilesInDir(path); err != nil {
+			return nil, nabu.FromError(err).WithArgs(path).Log()
+		}
+		sort.Strings(files)
+	}
+
+	var tables []conf.Table
+	for _, f := range files {
+		content, err := util.ReadFileAsString(f)
+		if err != nil {
+			return nil, nabu.FromError(err).WithArgs(f).Log()
+		}
+		ts, err := ParseDDL(content)
+		if err != nil {
+			return nil, nabu.FromError(err).WithArgs(f).Log()
+		}
+		for _, t := range ts {
+			// the migration bookkeeping table is not part of the application schema
+			if t.Name != conf.Args.MigrationsTable {
+				tables = append(tables, t)
+			}
+		}
+	}
+
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	return tables, nil
+}
+
+// ParseDDL extracts the tables defined by CREATE TABLE statements, as printed by SHOW CREATE TABLE
+// or mysqldump. Every other statement is ignored.
+func ParseDDL(content string) ([]conf.Table, error) {
+	toks, err := tokenize(content)
+	if err != nil {
+		return nil, nabu.FromError(err).Log()
+	}
+
+	var tables []conf.Table
+	for _, stmt := range splitStatements(toks) {
+		if len(stmt) == 0 || !stmt[0].is("CREATE") {
+			continue
+		}
+		i := 1
+		if i+1 < len(stmt) && stmt[i].is("OR") && stmt[i+1].is("REPLACE") {
+			i += 2
+		}
+		if i < len(stmt) && stmt[i].is("TEMPORARY") {
+			i++
+		}
+		if i >= len(stmt) || !stmt[i].is("TABLE") {
+			continue
+		}
+		t, err := parseCreateTable(stmt[i+1:])
+		if err != nil {
+			return nil, nabu.FromError(err).Log()
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+func tokenize(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || (c == '-' && strings.HasPrefix(s[i:], "-- ")) || strings.HasPrefix(s[i:], "--\n"):
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return nil, errors.New("unterminated block comment")
+			}
+			i += end + 4
+		case c == '`':
+			end := i + 1
+			var b strings.Builder
+			for ; end < len(s); end++ {
+				if s[end] == '`' {
+					if end+1 < len(s) && s[end+1] == '`' {
+						b.WriteByte('`')
+						end++
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[end])
+			}
+			if end >= len(s) {
+				return nil, errors.New("unterminated quoted identifier")
+			}
+			toks = append(toks, token{kind: tokIdent, text: b.String(), quoted: true})
+			i = end + 1
+		case c == '\'' || c == '"':
+			end := i + 1
+			var b strings.Builder
+			for ; end < len(s); end++ {
+				if s[end] == '\\' && end+1 < len(s) {
+					end++
+					b.WriteByte(unescape(s[end]))
+					continue
+				}
+				if s[end] == c {
+					if end+1 < len(s) && s[end+1] == c {
+						b.WriteByte(c)
+						end++
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[end])
+			}
+			if end >= len(s) {
+				return nil, errors.New("unterminated string literal")
+			}
+			toks = append(toks, token{kind: tokString, text: b.String()})
+			i = end + 1
+		case isDigit(c) || (c == '-' && i+1 < len(s) && isDigit(s[i+1]) && lastIsOperand(toks)):
+			end := i + 1
+			for end < len(s) && (isDigit(s[end]) || s[end] == '.' || s[end] == 'e' || s[end] == 'E') {
+				end++
+			}
+			toks = append(toks, token{kind: tokNumber, text: s[i:end]})
+			i = end
+		case isWordChar(c):
+			end := i
+			for end < len(s) && isWordChar(s[end]) {
+				end++
+			}
+			toks = append(toks, token{kind: tokIdent, text: s[i:end]})
+			i = end
+		default:
+			toks = append(toks, token{kind: tokPunct, text: string(c)})
+			i++
+		}
+	}
+	return toks, nil
+}
+
+// lastIsOperand reports whether a minus sign starts a negative number (e.g. DEFAULT -1).
+func lastIsOperand(toks []token) bool {
+	return len(toks) > 0 && toks[len(toks)-1].kind == tokIdent
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	case 'r':
+		return '\r'
+	case '0':
+		return 0
+	}
+	return c
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || (c|0x20 >= 'a' && c|0x20 <= 'z') || c >= 0x80
+}
+
+func splitStatements(toks []token) [][]token {
+	var stmts [][]token
+	start := 0
+	for i, t := range toks {
+		if t.kind == tokPunct && t.text == ";" {
+			stmts = append(stmts, toks[start:i])
+			start = i + 1
+		}
+	}
+	return append(stmts, toks[start:])
+}
+
+// splitTopLevel splits toks on commas that are not nested in parentheses.
+func splitTopLevel(toks []token) [][]token {
+	var parts [][]token
+	depth, start := 0, 0
+	for i, t := range toks {
+		if t.kind != tokPunct {
+			continue
+		}
+		switch t.text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",":
+			if depth == 0 {
+				parts = append(parts, toks[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, toks[start:])
+}
+
+// group returns the tokens between the parenthesis at toks[i] and its closing one, and the index after it.
+func group(toks []token, i int) ([]token, int, error) {
+	if i >= len(toks) || toks[i].kind != tokPunct || toks[i].text != "(" {
+		return nil, i, errors.New("expected (")
+	}
+	depth := 0
+	for j := i; j < len(toks); j++ {
+		if toks[j].kind != tokPunct {
+			continue
+		}
+		switch toks[j].text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return toks[i+1 : j], j + 1, nil
+			}
+		}
+	}
+	return nil, i, errors.New("unbalanced parentheses")
+}
+
+func render(toks []token) string {
+	var b strings.Builder
+	for i, t := range toks {
+		if i > 0 && needsSpace(toks[i-1], t) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t.sql())
+	}
+	return b.String()
+}
+
+func needsSpace(prev, t token) bool {
+	if prev.kind == tokPunct && (prev.text == "(" || prev.text == ".") {
+		return false
+	}
+	if t.kind == tokPunct && (t.text == ")" || t.text == "," || t.text == "." || t.text == "(") {
+		return t.text == "(" && prev.kind == tokPunct && prev.text != "("
+	}
+	if prev.kind == tokPunct && prev.text == "," {
+		return false
+	}
+	return true
+}
+
+func parseCreateTable(toks []token) (conf.Table, error) {
+	t := conf.Table{}
+	i := 0
+	if i+2 < len(toks) && toks[i].is("IF") && toks[i+1].is("NOT") && toks[i+2].is("EXISTS") {
+		i += 3
+	}
+	if i >= len(toks) || toks[i].kind != tokIdent {
+		return t, errors.New("CREATE TABLE without a table name")
+	}
+	t.Name = toks[i].text
+	i++
+	if i+1 < len(toks) && toks[i].kind == tokPunct && toks[i].text == "." {
+		t.Name = toks[i+1].text // schema qualified name
+		i += 2
+	}
+
+	body, _, err := group(toks, i)
+	if err != nil {
+		return t, fmt.Errorf("table %q: %w", t.Name, err)
+	}
+
+	for _, def := range splitTopLevel(body) {
+		if len(def) == 0 {
+			continue
+		}
+		if err = parseDefinition(&t, def); err != nil {
+			return t, fmt.Errorf("table %q: %w", t.Name, err)
+		}
+	}
+	return t, nil
+}
+
+func parseDefinition(t *conf.Table, def []token) error {
+	i := 0
+	name := ""
+	if def[0].is("CONSTRAINT") {
+		i++
+		if i < len(def) && def[i].kind == tokIdent && !def[i].is("PRIMARY") && !def[i].is("UNIQUE") &&
+			!def[i].is("FOREIGN") && !def[i].is("CHECK") {
+			name = def[i].text
+			i++
+		}
+	}
+	if i >= len(def) {
+		return errors.New("incomplete constraint")
+	}
+
+	switch {
+	case def[i].is("PRIMARY"):
+		i += 2 // PRIMARY KEY
+		if i < len(def) && def[i].is("USING") {
+			i += 2
+		}
+		cols, err := keyColumns(def, i)
+		if err != nil {
+			return err
+		}
+		t.Indexes = append(t.Indexes, conf.Index{Name: "PRIMARY", Columns: cols, Unique: true, Primary: true})
+	case def[i].is("UNIQUE"), def[i].is("KEY"), def[i].is("INDEX"), def[i].is("FULLTEXT"), def[i].is("SPATIAL"):
+		idx := conf.Index{Name: name, Unique: def[i].is("UNIQUE")}
+		if def[i].is("FULLTEXT") || def[i].is("SPATIAL") {
+			idx.Kind = strings.ToUpper(def[i].text)
+		}
+		i++
+		if i < len(def) && (def[i].is("KEY") || def[i].is("INDEX")) {
+			i++
+		}
+		if i < len(def) && def[i].kind == tokIdent && !def[i].is("USING") {
+			idx.Name = def[i].text
+			i++
+		}
+		if i < len(def) && def[i].is("USING") {
+			i += 2
+		}
+		cols, err := keyColumns(def, i)
+		if err != nil {
+			return err
+		}
+		idx.Columns = cols
+		if idx.Name == "" {
+			idx.Name = cols[0]
+		}
+		t.Indexes = append(t.Indexes, idx)
+	case def[i].is("FOREIGN"):
+		i += 2 // FOREIGN KEY
+		if i < len(def) && def[i].kind == tokIdent {
+			if name == "" {
+				name = def[i].text
+			}
+			i++
+		}
+		cols, err := keyColumns(def, i)
+		if err != nil {
+			return err
+		}
+		_, i, _ = group(def, i)
+		fk, err := parseReferences(def[i:])
+		if err != nil {
+			return err
+		}
+		fk.Name = name
+		fk.Columns = cols
+		t.ForeignKeys = append(t.ForeignKeys, fk)
+	case def[i].is("CHECK"), def[i].is("PERIOD"):
+		// not needed for code generation
+	default:
+		tf, err := parseColumn(t, def)
+		if err != nil {
+			return err
+		}
+		t.Fields = append(t.Fields, tf)
+	}
+	return nil
+}
+
+// keyColumns parses "(col1, col2(10) DESC)" at def[i] into column names.
+func keyColumns(def []token, i int) ([]string, error) {
+	inner, _, err := group(def, i)
+	if err != nil {
+		return nil, err
+	}
+	var cols []string
+	for _, part := range splitTopLevel(inner) {
+		if len(part) == 0 || part[0].kind != tokIdent {
+			return nil, errors.New("invalid key column list")
+		}
+		cols = append(cols, part[0].text)
+	}
+	if len(cols) == 0 {
+		return nil, errors.New("empty key column list")
+	}
+	return cols, nil
+}
+
+func parseReferences(toks []token) (conf.ForeignKey, error) {
+	fk := conf.ForeignKey{OnDelete: "RESTRICT", OnUpdate: "RESTRICT"}
+	if len(toks) < 2 || !toks[0].is("REFERENCES") {
+		return fk, errors.New("expected REFERENCES")
+	}
+	i := 1
+	fk.RefTable = toks[i].text
+	i++
+	if i+1 < len(toks) && toks[i].kind == tokPunct && toks[i].text == "." {
+		fk.RefSchema = fk.RefTable
+		fk.RefTable = toks[i+1].text
+		i += 2
+	}
+	cols, err := keyColumns(toks, i)
+	if err != nil {
+		return fk, err
+	}
+	fk.RefColumns = cols
+	_, i, _ = group(toks, i)
+
+	for i < len(toks) {
+		if toks[i].is("ON") && i+2 < len(toks) {
+			action := strings.ToUpper(toks[i+2].text)
+			next := i + 3
+			if (toks[i+2].is("SET") || toks[i+2].is("NO")) && next < len(toks) {
+				action += " " + strings.ToUpper(toks[next].text)
+				next++
+			}
+			if toks[i+1].is("DELETE") {
+				fk.OnDelete = action
+			} else {
+				fk.OnUpdate = action
+			}
+			i = next
+			continue
+		}
+		i++
+	}
+	return fk, nil
+}
+
+var typeSynonyms = map[string]string{
+	"integer": "int",
+	"dec":     "decimal",
+	"numeric": "decimal",
+	"fixed":   "decimal",
+	"real":    "double",
+}
+
+func parseColumn(t *conf.Table, def []token) (conf.TableField, error) {
+	tf := conf.TableField{Name: def[0].text, Nullable: true}
+	if len(def) < 2 || def[1].kind != tokIdent {
+		return tf, fmt.Errorf("column %q has no type", tf.Name)
+	}
+
+	dataType := strings.ToLower(def[1].text)
+	if v, ok := typeSynonyms[dataType]; ok {
+		dataType = v
+	}
+	columnType := dataType
+	i := 2
+	if i < len(def) && def[i].kind == tokPunct && def[i].text == "(" {
+		args, next, err := group(def, i)
+		if err != nil {
+			return tf, fmt.Errorf("column %q: %w", tf.Name, err)
+		}
+		columnType += "(" + render(args) + ")"
+		i = next
+	}
+	if dataType == "bool" || dataType == "boolean" {
+		dataType, columnType = "tinyint", "tinyint(1)"
+	}
+	for i < len(def) && (def[i].is("UNSIGNED") || def[i].is("SIGNED") || def[i].is("ZEROFILL")) {
+		if !def[i].is("SIGNED") {
+			columnType += " " + strings.ToLower(def[i].text)
+		}
+		i++
+	}
+	tf.DataType = dataType
+	tf.ColumnType = columnType
+
+	var extras []string
+	hasDefault := false
+	for i < len(def) {
+		switch {
+		case def[i].is("NOT") && i+1 < len(def) && def[i+1].is("NULL"):
+			tf.Nullable = false
+			i += 2
+		case def[i].is("NULL"):
+			i++
+		case def[i].is("DEFAULT"):
+			expr, next := parseExpr(def, i+1)
+			tf.Default = expr
+			hasDefault = true
+			i = next
+		case def[i].is("AUTO_INCREMENT"):
+			extras = append(extras, "auto_increment")
+			i++
+		case def[i].is("ON") && i+1 < len(def) && def[i+1].is("UPDATE"):
+			expr, next := parseExpr(def, i+2)
+			extras = append(extras, "on update "+expr)
+			i = next
+		case def[i].is("PRIMARY"):
+			t.Indexes = append([]conf.Index{{Name: "PRIMARY", Columns: []string{tf.Name}, Unique: true, Primary: true}}, t.Indexes...)
+			i += 2
+		case def[i].is("UNIQUE"):
+			t.Indexes = append(t.Indexes, conf.Index{Name: tf.Name, Columns: []string{tf.Name}, Unique: true})
+			i++
+			if i < len(def) && def[i].is("KEY") {
+				i++
+			}
+		case def[i].is("CHARACTER") || def[i].is("COLLATE") || def[i].is("CHARSET") || def[i].is("COMMENT"):
+			if def[i].is("CHARACTER") {
+				i++
+			}
+			i += 2
+		case def[i].is("GENERATED"):
+			i += 2 // GENERATED ALWAYS
+		case def[i].is("AS"):
+			_, next, err := group(def, i+1)
+			if err != nil {
+				return tf, fmt.Errorf("column %q: %w", tf.Name, err)
+			}
+			i = next
+			kind := "VIRTUAL"
+			if i < len(def) && (def[i].is("PERSISTENT") || def[i].is("STORED")) {
+				kind = "STORED"
+			}
+			extras = append(extras, kind+" GENERATED")
+		case def[i].is("REFERENCES"):
+			fk, err := parseReferences(def[i:])
+			if err != nil {
+				return tf, fmt.Errorf("column %q: %w", tf.Name, err)
+			}
+			fk.Columns = []string{tf.Name}
+			t.ForeignKeys = append(t.ForeignKeys, fk)
+			i = len(def)
+		case def[i].is("CHECK"):
+			_, next, err := group(def, i+1)
+			if err != nil {
+				return tf, fmt.Errorf("column %q: %w", tf.Name, err)
+			}
+			i = next
+		default:
+			i++ // VIRTUAL, PERSISTENT, INVISIBLE, ...
+		}
+	}
+
+	// MariaDB reports an implicit NULL default for nullable columns
+	if !hasDefault && tf.Nullable && !strings.Contains(strings.Join(extras, " "), "GENERATED") {
+		tf.Default = "NULL"
+	}
+	tf.Extra = strings.Join(extras, " ")
+	return tf, nil
+}
+
+// parseExpr reads a DEFAULT or ON UPDATE value and renders it the way INFORMATION_SCHEMA does:
+// strings quoted, functions lowercased with parentheses, e.g. current_timestamp(6).
+func parseExpr(def []token, i int) (string, int) {
+	if i >= len(def) {
+		return "", i
+	}
+	t := def[i]
+	switch {
+	case t.kind == tokPunct && t.text == "(":
+		inner, next, err := group(def, i)
+		if err != nil {
+			return "", len(def)
+		}
+		return "(" + render(inner) + ")", next
+	case t.kind == tokString, t.kind == tokNumber:
+		return t.sql(), i + 1
+	case t.is("NULL"):
+		return "NULL", i + 1
+	case t.kind == tokIdent && i+1 < len(def) && def[i+1].kind == tokString:
+		return t.text + def[i+1].sql(), i + 2 // b'0', x'ff', _utf8mb4'abc'
+	case t.kind == tokIdent:
+		name := strings.ToLower(t.text)
+		if i+1 < len(def) && def[i+1].kind == tokPunct && def[i+1].text == "(" {
+			args, next, err := group(def, i+1)
+			if err != nil {
+				return "", len(def)
+			}
+			return name + "(" + render(args) + ")", next
+		}
+		switch name {
+		case "current_timestamp", "now", "localtime", "localtimestamp":
+			return "current_timestamp()", i + 1
+		}
+		return t.sql(), i + 1
+	}
+	return t.sql(), i + 1
+}
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rah-0/margo/conf"
+)
+
+func TestParseDDL(t *testing.T) {
+	ddl := "" +
+		"-- dump header\n" +
+		"SET NAMES utf8mb4;\n" +
+		"/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;\n" +
+		"CREATE TABLE IF NOT EXISTS `app`.`alpha` (\n" +
+		" `Uuid` uuid NOT NULL DEFAULT '00000000-0000-4000-8000-000000000000',\n" +
+		" `FirstInsert` datetime(6) NOT NULL DEFAULT current_timestamp(6),\n" +
+		" `LastUpdate` datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE current_timestamp(6),\n" +
+		" `Animal` varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT '' COMMENT 'it''s a name',\n" +
+		" `BigNumber` bigint(20) unsigned DEFAULT NULL,\n" +
+		" `Score` int(11) NOT NULL DEFAULT -1,\n" +
+		" `Flag` bit(1) NOT NULL DEFAULT b'0',\n" +
+		" `Kind` enum('one','two') DEFAULT NULL,\n" +
+		" `test_field` varchar(20),\n" +
+		" `Total` decimal(10,2) GENERATED ALWAYS AS (`Score` * 2) VIRTUAL,\n" +
+		" `beta_uuid` uuid DEFAULT NULL,\n" +
+		" PRIMARY KEY (`Uuid`),\n" +
+		" UNIQUE KEY `idx_animal` (`Animal`,`Score`),\n" +
+		" KEY `idx_test` (`test_field`(10)),\n" +
+		" FULLTEXT KEY `ft_animal` (`Animal`),\n" +
+		" CONSTRAINT `fk_beta` FOREIGN KEY (`beta_uuid`) REFERENCES `beta` (`uuid`) ON DELETE SET NULL ON UPDATE CASCADE,\n" +
+		" CONSTRAINT `chk_score` CHECK (`Score` >= -1)\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;\n" +
+		"INSERT INTO `alpha` VALUES ('x');\n" +
+		"CREATE TABLE beta (uuid UUID PRIMARY KEY, name VARCHAR(191) NOT NULL, n INTEGER UNSIGNED AUTO_INCREMENT UNIQUE, ok BOOLEAN)"
+
+	tables, err := ParseDDL(ddl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 2 {
+		t.Fatalf("expected 2 tables, got %d", len(tables))
+	}
+
+	alpha := tables[0]
+	if alpha.Name != "alpha" {
+		t.Errorf("expected table alpha, got %q", alpha.Name)
+	}
+
+	expectedFields := []conf.TableField{
+		{Name: "Uuid", DataType: "uuid", ColumnType: "uuid", Default: "'00000000-0000-4000-8000-000000000000'"},
+		{Name: "FirstInsert", DataType: "datetime", ColumnType: "datetime(6)", Default: "current_timestamp(6)"},
+		{Name: "LastUpdate", DataType: "datetime", ColumnType: "datetime(6)", Default: "current_timestamp(6)", Extra: "on update current_timestamp(6)"},
+		{Name: "Animal", DataType: "varchar", ColumnType: "varchar(255)", Default: "''"},
+		{Name: "BigNumber", DataType: "bigint", ColumnType: "bigint(20) unsigned", Nullable: true, Default: "NULL"},
+		{Name: "Score", DataType: "int", ColumnType: "int(11)", Default: "-1"},
+		{Name: "Flag", DataType: "bit", ColumnType: "bit(1)", Default: "b'0'"},
+		{Name: "Kind", DataType: "enum", ColumnType: "enum('one','two')", Nullable: true, Default: "NULL"},
+		{Name: "test_field", DataType: "varchar", ColumnType: "varchar(20)", Nullable: true, Default: "NULL"},
+		{Name: "Total", DataType: "decimal", ColumnType: "decimal(10,2)", Nullable: true, Extra: "VIRTUAL GENERATED"},
+		{Name: "beta_uuid", DataType: "uuid", ColumnType: "uuid", Nullable: true, Default: "NULL"},
+	}
+	if !reflect.DeepEqual(alpha.Fields, expectedFields) {
+		t.Errorf("fields mismatch\nexpected: %+v\ngot:      %+v", expectedFields, alpha.Fields)
+	}
+
+	expectedIndexes := []conf.Index{
+		{Name: "PRIMARY", Columns: []string{"Uuid"}, Unique: true, Primary: true},
+		{Name: "idx_animal", Columns: []string{"Animal", "Score"}, Unique: true},
+		{Name: "idx_test", Columns: []string{"test_field"}},
+		{Name: "ft_animal", Columns: []string{"Animal"}, Kind: "FULLTEXT"},
+	}
+	if !reflect.DeepEqual(alpha.Indexes, expectedIndexes) {
+		t.Errorf("indexes mismatch\nexpected: %+v\ngot:      %+v", expectedIndexes, alpha.Indexes)
+	}
+
+	expectedFks := []conf.ForeignKey{{
+		Name: "fk_beta", Columns: []string{"beta_uuid"}, RefTable: "beta", RefColumns: []string{"uuid"},
+		OnDelete: "SET NULL", OnUpdate: "CASCADE",
+	}}
+	if !reflect.DeepEqual(alpha.ForeignKeys, expectedFks) {
+		t.Errorf("foreign keys mismatch\nexpected: %+v\ngot:      %+v", expectedFks, alpha.ForeignKeys)
+	}
+
+	beta := tables[1]
+	expectedFields = []conf.TableField{
+		{Name: "uuid", DataType: "uuid", ColumnType: "uuid", Nullable: true, Default: "NULL"},
+		{Name: "name", DataType: "varchar", ColumnType: "varchar(191)"},
+		{Name: "n", DataType: "int", ColumnType: "int unsigned", Nullable: true, Default: "NULL", Extra: "auto_increment"},
+		{Name: "ok", DataType: "tinyint", ColumnType: "tinyint(1)", Nullable: true, Default: "NULL"},
+	}
+	if !reflect.DeepEqual(beta.Fields, expectedFields) {
+		t.Errorf("fields mismatch\nexpected: %+v\ngot:      %+v", expectedFields, beta.Fields)
+	}
+	if len(beta.Indexes) != 2 || !beta.Indexes[0].Primary || beta.Indexes[1].Name != "n" || !beta.Indexes[1].Unique {
+		t.Errorf("unexpected indexes: %+v", beta.Indexes)
+	}
+}
+
+func TestParseDDLErrors(t *testing.T) {
+	tests := []string{
+		"CREATE TABLE `alpha` (`id` int",
+		"CREATE TABLE `alpha` (`id` int, PRIMARY KEY ()",
+		"CREATE TABLE `alpha` (`id` int) COMMENT 'unterminated",
+	}
+
+	for _, ddl := range tests {
+		if _, err := ParseDDL(ddl); err == nil {
+			t.Errorf("expected error for %q, got nil", ddl)
+		}
+	}
+}
+
+func TestLoadDDL(t *testing.T) {
+	tables, err := LoadDDL("../doc/sql/tables.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, table := range tables {
+		names = append(names, table.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"all_types", "alpha", "beta"}) {
+		t.Errorf("unexpected tables: %v", names)
+	}
+}
+
+func TestLoadDDLMigrationsTable(t *testing.T) {
+	defer func(a conf.Arguments) { conf.Args = a }(conf.Args)
+
+	path := filepath.Join(t.TempDir(), "schema.sql")
+	content := "" +
+		"CREATE TABLE `margo_migrations` (`version` bigint NOT NULL, PRIMARY KEY (`version`));\n" +
+		"CREATE TABLE `users` (`id` int NOT NULL, PRIMARY KEY (`id`));\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"generate", "-dbName=app", "-schemaPath=" + path, "-outputPath=out"},
+		{"snapshot", "-dbName=app", "-schemaPath=" + path, "-snapshotPath=s.json"},
+	} {
+		if err := conf.Parse(args, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+		tables, err := LoadDDL(conf.Args.SchemaPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tables) != 1 || tables[0].Name != "users" {
+			t.Errorf("unexpected tables for %s: %v", args[0], tables)
+		}
+	}
+}
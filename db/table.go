@@ -11,7 +11,7 @@ import (
 	"github.com/rah-0/margo/util"
 )
 
-func GetDbTables(c *sql.DB) ([]string, error) {
+func GetDbTables(c *sql.DB, schema string) ([]string, error) {
 	var tables []string
 
 	rows, err := c.Query(`
@@ -19,8 +19,9 @@ func GetDbTables(c *sql.DB) ([]string, error) {
 	FROM information_schema.tables
 	WHERE table_schema = ?
 	  AND table_type = 'BASE TABLE'
+	  AND table_name <> ?
 	ORDER BY table_name`,
-		conf.Args.DBName,
+		schema, conf.Args.MigrationsTable,
 	)
 	if err != nil {
 		return tables, nabu.FromError(err).Log()
@@ -64,19 +65,22 @@ func NormalizeString(input string) string {
 	return strings.Join(parts, "")
 }
 
-func GetDbTableFields(c *sql.DB, tableName string) ([]conf.TableField, error) {
+func GetDbTableFields(c *sql.DB, schema, tableName string) ([]conf.TableField, error) {
 	var tfs []conf.TableField
 	rows, err := c.Query(`
 		SELECT 
 			COLUMN_NAME as columnName,
 			DATA_TYPE as dataType,
-			COLUMN_TYPE as columnType
+			COLUMN_TYPE as columnType,
+			IS_NULLABLE as isNullable,
+			COLUMN_DEFAULT as columnDefault,
+			EXTRA as extra
 		FROM 
 			INFORMATION_SCHEMA.COLUMNS
 		WHERE 
 			table_name = '` + tableName + `'
 				AND 
-					table_schema = '` + conf.Args.DBName + `'
+					table_schema = '` + schema + `'
 		ORDER BY 
 			ORDINAL_POSITION
 	`)
@@ -88,8 +92,11 @@ func GetDbTableFields(c *sql.DB, tableName string) ([]conf.TableField, error) {
 		var columnName string
 		var dataType string
 		var columnType string
+		var isNullable string
+		var columnDefault sql.NullString
+		var extra string
 
-		if err = rows.Scan(&columnName, &dataType, &columnType); err != nil {
+		if err = rows.Scan(&columnName, &dataType, &columnType, &isNullable, &columnDefault, &extra); err != nil {
 			return tfs, nabu.FromError(err).Log()
 		}
 
@@ -97,8 +104,139 @@ func GetDbTableFields(c *sql.DB, tableName string) ([]conf.TableField, error) {
 			Name:       columnName,
 			DataType:   dataType,
 			ColumnType: columnType,
+			Nullable:   isNullable == "YES",
+			Default:    columnDefault.String,
+			Extra:      extra,
 		})
 	}
 
 	return tfs, nil
 }
+
+func GetDbTableIndexes(c *sql.DB, schema, tableName string) ([]conf.Index, error) {
+	var idxs []conf.Index
+	rows, err := c.Query(`
+		SELECT
+			INDEX_NAME as indexName,
+			NON_UNIQUE as nonUnique,
+			INDEX_TYPE as indexType,
+			COLUMN_NAME as columnName
+		FROM
+			INFORMATION_SCHEMA.STATISTICS
+		WHERE
+			table_schema = ?
+				AND
+					table_name = ?
+		ORDER BY
+			INDEX_NAME = 'PRIMARY' DESC, INDEX_NAME, SEQ_IN_INDEX`,
+		schema, tableName,
+	)
+	if err != nil {
+		return idxs, nabu.FromError(err).Log()
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var indexName, indexType, columnName string
+		var nonUnique int
+
+		if err = rows.Scan(&indexName, &nonUnique, &indexType, &columnName); err != nil {
+			return idxs, nabu.FromError(err).Log()
+		}
+
+		if len(idxs) == 0 || idxs[len(idxs)-1].Name != indexName {
+			idx := conf.Index{Name: indexName, Unique: nonUnique == 0, Primary: indexName == "PRIMARY"}
+			if indexType == "FULLTEXT" || indexType == "SPATIAL" {
+				idx.Kind = indexType
+			}
+			idxs = append(idxs, idx)
+		}
+		idxs[len(idxs)-1].Columns = append(idxs[len(idxs)-1].Columns, columnName)
+	}
+
+	return idxs, rows.Err()
+}
+
+func GetDbTableForeignKeys(c *sql.DB, schema, tableName string) ([]conf.ForeignKey, error) {
+	var fks []conf.ForeignKey
+	rows, err := c.Query(`
+		SELECT
+			k.CONSTRAINT_NAME as constraintName,
+			k.COLUMN_NAME as columnName,
+			k.REFERENCED_TABLE_SCHEMA as refSchema,
+			k.REFERENCED_TABLE_NAME as refTable,
+			k.REFERENCED_COLUMN_NAME as refColumn,
+			r.DELETE_RULE as deleteRule,
+			r.UPDATE_RULE as updateRule
+		FROM
+			INFORMATION_SCHEMA.KEY_COLUMN_USAGE k
+			JOIN INFORMATION_SCHEMA.REFERENTIAL_CONSTRAINTS r
+				ON r.CONSTRAINT_SCHEMA = k.CONSTRAINT_SCHEMA
+					AND r.TABLE_NAME = k.TABLE_NAME
+					AND r.CONSTRAINT_NAME = k.CONSTRAINT_NAME
+		WHERE
+			k.table_schema = ?
+				AND
+					k.table_name = ?
+				AND
+					k.REFERENCED_TABLE_NAME IS NOT NULL
+		ORDER BY
+			k.CONSTRAINT_NAME, k.ORDINAL_POSITION`,
+		schema, tableName,
+	)
+	if err != nil {
+		return fks, nabu.FromError(err).Log()
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var constraintName, columnName, refSchema, refTable, refColumn, deleteRule, updateRule string
+
+		if err = rows.Scan(&constraintName, &columnName, &refSchema, &refTable, &refColumn, &deleteRule, &updateRule); err != nil {
+			return fks, nabu.FromError(err).Log()
+		}
+
+		if len(fks) == 0 || fks[len(fks)-1].Name != constraintName {
+			if refSchema == schema {
+				refSchema = ""
+			}
+			fks = append(fks, conf.ForeignKey{
+				Name:      constraintName,
+				RefSchema: refSchema,
+				RefTable:  refTable,
+				OnDelete:  deleteRule,
+				OnUpdate:  updateRule,
+			})
+		}
+		fk := &fks[len(fks)-1]
+		fk.Columns = append(fk.Columns, columnName)
+		fk.RefColumns = append(fk.RefColumns, refColumn)
+	}
+
+	return fks, rows.Err()
+}
+
+// GetDbSchema returns every table of schema with its columns, indexes and foreign keys.
+func GetDbSchema(c *sql.DB, schema string) ([]conf.Table, error) {
+	tableNames, err := GetDbTables(c, schema)
+	if err != nil {
+		return nil, nabu.FromError(err).Log()
+	}
+
+	tables := make([]conf.Table, 0, len(tableNames))
+	for _, tn := range tableNames {
+		t := conf.Table{Name: tn}
+		if t.Fields, err = GetDbTableFields(c, schema, tn); err != nil {
+			return nil, nabu.FromError(err).WithArgs(tn).Log()
+		}
+		if t.Indexes, err = GetDbTableIndexes(c, schema, tn); err != nil {
+			return nil, nabu.FromError(err).WithArgs(tn).Log()
+		}
+		if t.ForeignKeys, err = GetDbTableForeignKeys(c, schema, tn); err != nil {
+			return nil, nabu.FromError(err).WithArgs(tn).Log()
+		}
+		tables = append(tables, t)
+	}
+
+	return tables, nil
+}
@@ -2,10 +2,12 @@ package db
 
 import (
 	"testing"
+
+	"github.com/rah-0/margo/conf"
 )
 
 func TestGetDbTables(t *testing.T) {
-	tables, err := GetDbTables(conn)
+	tables, err := GetDbTables(conn, conf.Args.DBName)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -72,7 +74,7 @@ func TestNormalizeString(t *testing.T) {
 }
 
 func TestGetDbTableFields(t *testing.T) {
-	tables, err := GetDbTables(conn)
+	tables, err := GetDbTables(conn, conf.Args.DBName)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -81,7 +83,7 @@ func TestGetDbTableFields(t *testing.T) {
 	}
 
 	for _, table := range tables {
-		_, err := GetDbTableFields(conn, table)
+		_, err := GetDbTableFields(conn, conf.Args.DBName, table)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -0,0 +1,325 @@
+package diff
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/rah-0/nabu"
+
+	"github.com/rah-0/margo/conf"
+)
+
+// SchemaDiff lists what has to change to turn the From schema into the To schema.
+type SchemaDiff struct {
+	From          string       `json:"from"`
+	To            string       `json:"to"`
+	AddedTables   []conf.Table `json:"addedTables,omitempty"`
+	RemovedTables []conf.Table `json:"removedTables,omitempty"`
+	ChangedTables []TableDiff  `json:"changedTables,omitempty"`
+}
+
+type TableDiff struct {
+	Name               string             `json:"name"`
+	AddedColumns       []conf.TableField  `json:"addedColumns,omitempty"`
+	RemovedColumns     []conf.TableField  `json:"removedColumns,omitempty"`
+	ChangedColumns     []ColumnChange     `json:"changedColumns,omitempty"`
+	AddedIndexes       []conf.Index       `json:"addedIndexes,omitempty"`
+	RemovedIndexes     []conf.Index       `json:"removedIndexes,omitempty"`
+	ChangedIndexes     []IndexChange      `json:"changedIndexes,omitempty"`
+	AddedForeignKeys   []conf.ForeignKey  `json:"addedForeignKeys,omitempty"`
+	RemovedForeignKeys []conf.ForeignKey  `json:"removedForeignKeys,omitempty"`
+	ChangedForeignKeys []ForeignKeyChange `json:"changedForeignKeys,omitempty"`
+
+	// both versions of the table, needed to place added columns and to reverse the change
+	FromTable conf.Table `json:"-"`
+	ToTable   conf.Table `json:"-"`
+}
+
+type ColumnChange struct {
+	Name    string          `json:"name"`
+	Changes []string        `json:"changes"` // any of type, nullable, default, extra
+	From    conf.TableField `json:"from"`
+	To      conf.TableField `json:"to"`
+}
+
+type IndexChange struct {
+	Name string     `json:"name"`
+	From conf.Index `json:"from"`
+	To   conf.Index `json:"to"`
+}
+
+type ForeignKeyChange struct {
+	Name string          `json:"name"`
+	From conf.ForeignKey `json:"from"`
+	To   conf.ForeignKey `json:"to"`
+}
+
+const (
+	ChangeType     = "type"
+	ChangeNullable = "nullable"
+	ChangeDefault  = "default"
+	ChangeExtra    = "extra"
+
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatSQL  = "sql"
+)
+
+func (d *SchemaDiff) Empty() bool {
+	return len(d.AddedTables) == 0 && len(d.RemovedTables) == 0 && len(d.ChangedTables) == 0
+}
+
+// Compare matches tables, columns, indexes and foreign keys by name. Renames show up as a removal plus an addition.
+func Compare(from, to []conf.Table) *SchemaDiff {
+	d := &SchemaDiff{}
+
+	fromTables := map[string]conf.Table{}
+	for _, t := range from {
+		fromTables[t.Name] = t
+	}
+	toTables := map[string]conf.Table{}
+	for _, t := range to {
+		toTables[t.Name] = t
+		if _, ok := fromTables[t.Name]; !ok {
+			d.AddedTables = append(d.AddedTables, t)
+		}
+	}
+	for _, t := range from {
+		nt, ok := toTables[t.Name]
+		if !ok {
+			d.RemovedTables = append(d.RemovedTables, t)
+			continue
+		}
+		if td := compareTable(t, nt); td != nil {
+			d.ChangedTables = append(d.ChangedTables, *td)
+		}
+	}
+
+	sort.Slice(d.AddedTables, func(i, j int) bool { return d.AddedTables[i].Name < d.AddedTables[j].Name })
+	sort.Slice(d.RemovedTables, func(i, j int) bool { return d.RemovedTables[i].Name < d.RemovedTables[j].Name })
+	sort.Slice(d.ChangedTables, func(i, j int) bool { return d.ChangedTables[i].Name < d.ChangedTables[j].Name })
+
+	return d
+}
+
+func compareTable(from, to conf.Table) *TableDiff {
+	td := &TableDiff{Name: from.Name, FromTable: from, ToTable: to}
+
+	fromFields := map[string]conf.TableField{}
+	for _, f := range from.Fields {
+		fromFields[f.Name] = f
+	}
+	toFields := map[string]bool{}
+	for _, f := range to.Fields {
+		toFields[f.Name] = true
+		old, ok := fromFields[f.Name]
+		if !ok {
+			td.AddedColumns = append(td.AddedColumns, f)
+			continue
+		}
+		if changes := compareField(old, f); len(changes) > 0 {
+			td.ChangedColumns = append(td.ChangedColumns, ColumnChange{Name: f.Name, Changes: changes, From: old, To: f})
+		}
+	}
+	for _, f := range from.Fields {
+		if !toFields[f.Name] {
+			td.RemovedColumns = append(td.RemovedColumns, f)
+		}
+	}
+
+	fromIdxs := map[string]conf.Index{}
+	for _, idx := range from.Indexes {
+		fromIdxs[idx.Name] = idx
+	}
+	toIdxs := map[string]bool{}
+	for _, idx := range to.Indexes {
+		toIdxs[idx.Name] = true
+		old, ok := fromIdxs[idx.Name]
+		if !ok {
+			td.AddedIndexes = append(td.AddedIndexes, idx)
+		} else if !reflect.DeepEqual(old, idx) {
+			td.ChangedIndexes = append(td.ChangedIndexes, IndexChange{Name: idx.Name, From: old, To: idx})
+		}
+	}
+	for _, idx := range from.Indexes {
+		if !toIdxs[idx.Name] {
+			td.RemovedIndexes = append(td.RemovedIndexes, idx)
+		}
+	}
+
+	fromFks := map[string]conf.ForeignKey{}
+	for _, fk := range from.ForeignKeys {
+		fromFks[fk.Name] = fk
+	}
+	toFks := map[string]bool{}
+	for _, fk := range to.ForeignKeys {
+		toFks[fk.Name] = true
+		old, ok := fromFks[fk.Name]
+		if !ok {
+			td.AddedForeignKeys = append(td.AddedForeignKeys, fk)
+		} else if !reflect.DeepEqual(old, fk) {
+			td.ChangedForeignKeys = append(td.ChangedForeignKeys, ForeignKeyChange{Name: fk.Name, From: old, To: fk})
+		}
+	}
+	for _, fk := range from.ForeignKeys {
+		if !toFks[fk.Name] {
+			td.RemovedForeignKeys = append(td.RemovedForeignKeys, fk)
+		}
+	}
+
+	if len(td.AddedColumns)+len(td.RemovedColumns)+len(td.ChangedColumns)+
+		len(td.AddedIndexes)+len(td.RemovedIndexes)+len(td.ChangedIndexes)+
+		len(td.AddedForeignKeys)+len(td.RemovedForeignKeys)+len(td.ChangedForeignKeys) == 0 {
+		return nil
+	}
+
+	return td
+}
+
+func compareField(from, to conf.TableField) []string {
+	var changes []string
+	if !strings.EqualFold(from.ColumnType, to.ColumnType) {
+		changes = append(changes, ChangeType)
+	}
+	if from.Nullable != to.Nullable {
+		changes = append(changes, ChangeNullable)
+	}
+	if from.Default != to.Default {
+		changes = append(changes, ChangeDefault)
+	}
+	if !strings.EqualFold(from.Extra, to.Extra) {
+		changes = append(changes, ChangeExtra)
+	}
+
+	return changes
+}
+
+func (d *SchemaDiff) JSON() (string, error) {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return "", nabu.FromError(err).Log()
+	}
+
+	return string(b) + "\n", nil
+}
+
+// Text renders the diff for humans: + added, - removed, ~ changed.
+func (d *SchemaDiff) Text() string {
+	t := "--- " + d.From + "\n"
+	t += "+++ " + d.To + "\n"
+	if d.Empty() {
+		return t + "No differences\n"
+	}
+
+	for _, table := range d.AddedTables {
+		t += "+ table " + table.Name + "\n"
+		for _, f := range table.Fields {
+			t += "    + column " + DescribeField(f) + "\n"
+		}
+	}
+	for _, table := range d.RemovedTables {
+		t += "- table " + table.Name + "\n"
+	}
+	for _, td := range d.ChangedTables {
+		t += "~ table " + td.Name + "\n"
+		for _, f := range td.AddedColumns {
+			t += "    + column " + DescribeField(f) + "\n"
+		}
+		for _, f := range td.RemovedColumns {
+			t += "    - column " + DescribeField(f) + "\n"
+		}
+		for _, cc := range td.ChangedColumns {
+			var parts []string
+			for _, c := range cc.Changes {
+				switch c {
+				case ChangeType:
+					parts = append(parts, "type "+cc.From.ColumnType+" -> "+cc.To.ColumnType)
+				case ChangeNullable:
+					parts = append(parts, "nullable "+yesNo(cc.From.Nullable)+" -> "+yesNo(cc.To.Nullable))
+				case ChangeDefault:
+					parts = append(parts, "default "+orNone(cc.From.Default)+" -> "+orNone(cc.To.Default))
+				case ChangeExtra:
+					parts = append(parts, "extra "+orNone(cc.From.Extra)+" -> "+orNone(cc.To.Extra))
+				}
+			}
+			t += "    ~ column " + cc.Name + ": " + strings.Join(parts, "; ") + "\n"
+		}
+		for _, idx := range td.AddedIndexes {
+			t += "    + index " + DescribeIndex(idx) + "\n"
+		}
+		for _, idx := range td.RemovedIndexes {
+			t += "    - index " + DescribeIndex(idx) + "\n"
+		}
+		for _, ic := range td.ChangedIndexes {
+			t += "    ~ index " + DescribeIndex(ic.From) + " -> " + DescribeIndex(ic.To) + "\n"
+		}
+		for _, fk := range td.AddedForeignKeys {
+			t += "    + foreign key " + DescribeForeignKey(fk) + "\n"
+		}
+		for _, fk := range td.RemovedForeignKeys {
+			t += "    - foreign key " + DescribeForeignKey(fk) + "\n"
+		}
+		for _, fc := range td.ChangedForeignKeys {
+			t += "    ~ foreign key " + DescribeForeignKey(fc.From) + " -> " + DescribeForeignKey(fc.To) + "\n"
+		}
+	}
+
+	return t
+}
+
+func DescribeField(f conf.TableField) string {
+	s := f.Name + " " + f.ColumnType
+	if f.Nullable {
+		s += " NULL"
+	} else {
+		s += " NOT NULL"
+	}
+	if f.Default != "" && !(f.Nullable && f.Default == "NULL") {
+		s += " DEFAULT " + f.Default
+	}
+	if f.Extra != "" {
+		s += " " + f.Extra
+	}
+
+	return s
+}
+
+func DescribeIndex(idx conf.Index) string {
+	s := idx.Name + " (" + strings.Join(idx.Columns, ", ") + ")"
+	switch {
+	case idx.Primary:
+		s += " PRIMARY"
+	case idx.Unique:
+		s += " UNIQUE"
+	case idx.Kind != "":
+		s += " " + idx.Kind
+	}
+
+	return s
+}
+
+func DescribeForeignKey(fk conf.ForeignKey) string {
+	ref := fk.RefTable
+	if fk.RefSchema != "" {
+		ref = fk.RefSchema + "." + ref
+	}
+
+	return fk.Name + " (" + strings.Join(fk.Columns, ", ") + ") -> " + ref + " (" + strings.Join(fk.RefColumns, ", ") + ")" +
+		" ON DELETE " + fk.OnDelete + " ON UPDATE " + fk.OnUpdate
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
@@ -0,0 +1,96 @@
+package diff
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rah-0/margo/conf"
+)
+
+func schemaV1() []conf.Table {
+	return []conf.Table{
+		{
+			Name: "alpha",
+			Fields: []conf.TableField{
+				{Name: "id", DataType: "int", ColumnType: "int(11)", Extra: "auto_increment"},
+				{Name: "name", DataType: "varchar", ColumnType: "varchar(20)", Nullable: true, Default: "NULL"},
+				{Name: "legacy", DataType: "int", ColumnType: "int(11)", Default: "0"},
+				{Name: "beta_id", DataType: "int", ColumnType: "int(11)"},
+			},
+			Indexes: []conf.Index{
+				{Name: "PRIMARY", Columns: []string{"id"}, Unique: true, Primary: true},
+				{Name: "idx_name", Columns: []string{"name"}},
+			},
+			ForeignKeys: []conf.ForeignKey{{
+				Name: "fk_beta", Columns: []string{"beta_id"}, RefTable: "beta", RefColumns: []string{"id"},
+				OnDelete: "RESTRICT", OnUpdate: "RESTRICT",
+			}},
+		},
+		{Name: "beta", Fields: []conf.TableField{{Name: "id", DataType: "int", ColumnType: "int(11)"}}},
+		{Name: "gamma", Fields: []conf.TableField{{Name: "id", DataType: "int", ColumnType: "int(11)"}}},
+	}
+}
+
+func schemaV2() []conf.Table {
+	tables := schemaV1()
+	alpha := &tables[0]
+	alpha.Fields = []conf.TableField{
+		alpha.Fields[0],
+		{Name: "name", DataType: "varchar", ColumnType: "varchar(255)", Default: "''"},
+		alpha.Fields[3],
+		{Name: "created", DataType: "datetime", ColumnType: "datetime(6)", Default: "current_timestamp(6)"},
+	}
+	alpha.Indexes = []conf.Index{
+		alpha.Indexes[0],
+		{Name: "idx_name", Columns: []string{"name"}, Unique: true},
+		{Name: "idx_created", Columns: []string{"created"}},
+	}
+	alpha.ForeignKeys[0].OnDelete = "CASCADE"
+
+	return []conf.Table{
+		*alpha,
+		tables[1],
+		{Name: "delta", Fields: []conf.TableField{{Name: "id", DataType: "int", ColumnType: "int(11)"}}},
+	}
+}
+
+func TestCompare(t *testing.T) {
+	d := Compare(schemaV1(), schemaV2())
+	d.From, d.To = "v1.json", "v2.json"
+
+	expected := "" +
+		"--- v1.json\n" +
+		"+++ v2.json\n" +
+		"+ table delta\n" +
+		"    + column id int(11) NOT NULL\n" +
+		"- table gamma\n" +
+		"~ table alpha\n" +
+		"    + column created datetime(6) NOT NULL DEFAULT current_timestamp(6)\n" +
+		"    - column legacy int(11) NOT NULL DEFAULT 0\n" +
+		"    ~ column name: type varchar(20) -> varchar(255); nullable YES -> NO; default NULL -> ''\n" +
+		"    + index idx_created (created)\n" +
+		"    ~ index idx_name (name) -> idx_name (name) UNIQUE\n" +
+		"    ~ foreign key fk_beta (beta_id) -> beta (id) ON DELETE RESTRICT ON UPDATE RESTRICT -> fk_beta (beta_id) -> beta (id) ON DELETE CASCADE ON UPDATE RESTRICT\n"
+	if got := d.Text(); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+
+	out, err := d.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded SchemaDiff
+	if err = json.Unmarshal([]byte(out), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.ChangedTables) != 1 || decoded.ChangedTables[0].ChangedColumns[0].Changes[0] != ChangeType {
+		t.Errorf("unexpected JSON output:\n%s", out)
+	}
+}
+
+func TestCompareEqual(t *testing.T) {
+	d := Compare(schemaV1(), schemaV1())
+	if !d.Empty() {
+		t.Errorf("expected no differences, got:\n%s", d.Text())
+	}
+}
@@ -0,0 +1,78 @@
+package diff
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/rah-0/nabu"
+
+	"github.com/rah-0/margo/conf"
+	"github.com/rah-0/margo/db"
+	"github.com/rah-0/margo/snapshot"
+)
+
+// SourceDBPrefix marks a source as a schema on the server given by the connection flags, e.g. db:app_staging.
+const SourceDBPrefix = "db:"
+
+// SourceDSNPrefix marks a source as the schema a DSN of its own selects, e.g. dsn:user:pass@tcp(staging:3306)/app,
+// so that both sides of a diff can be on different servers.
+const SourceDSNPrefix = "dsn:"
+
+// LoadSource reads the tables of a snapshot (.json), a DDL dump (.sql file or directory) or a live schema (db:name or
+// dsn:DSN).
+func LoadSource(source string) ([]conf.Table, error) {
+	if schema, ok := strings.CutPrefix(source, SourceDBPrefix); ok {
+		conn, err := db.Connect()
+		if err != nil {
+			return nil, nabu.FromError(err).WithArgs(source).Log()
+		}
+		defer conn.Close()
+
+		return loadDB(conn, schema, source)
+	}
+
+	if dsn, ok := strings.CutPrefix(source, SourceDSNPrefix); ok {
+		schema, err := db.DSNSchema(dsn)
+		if err != nil {
+			return nil, nabu.FromError(err).WithArgs(SourceName(source)).Log()
+		}
+		conn, err := db.ConnectDSN(dsn)
+		if err != nil {
+			return nil, nabu.FromError(err).WithArgs(SourceName(source)).Log()
+		}
+		defer conn.Close()
+
+		return loadDB(conn, schema, SourceName(source))
+	}
+
+	if strings.HasSuffix(strings.ToLower(source), ".json") {
+		s, err := snapshot.Read(source)
+		if err != nil {
+			return nil, nabu.FromError(err).WithArgs(source).Log()
+		}
+		return s.Tables, nil
+	}
+
+	tables, err := db.LoadDDL(source)
+	if err != nil {
+		return nil, nabu.FromError(err).WithArgs(source).Log()
+	}
+
+	return tables, nil
+}
+
+// SourceName returns source as it is printed, with the password of a dsn: source masked.
+func SourceName(source string) string {
+	if dsn, ok := strings.CutPrefix(source, SourceDSNPrefix); ok {
+		return SourceDSNPrefix + db.RedactDSN(dsn)
+	}
+	return source
+}
+
+func loadDB(conn *sql.DB, schema, name string) ([]conf.Table, error) {
+	tables, err := db.GetDbSchema(conn, schema)
+	if err != nil {
+		return nil, nabu.FromError(err).WithArgs(name).Log()
+	}
+	return tables, nil
+}
@@ -0,0 +1,416 @@
+package diff
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rah-0/margo/conf"
+)
+
+// Statements returns the DDL that moves the From schema to the To schema. Foreign keys are dropped first and added
+// last so tables and columns can be created, changed and dropped in any order.
+func (d *SchemaDiff) Statements() []string {
+	var drops, tables, alters, fks []string
+
+	for _, td := range d.ChangedTables {
+		var clauses []string
+		for _, fk := range td.RemovedForeignKeys {
+			clauses = append(clauses, "DROP FOREIGN KEY "+quote(fk.Name))
+		}
+		for _, fc := range td.ChangedForeignKeys {
+			clauses = append(clauses, "DROP FOREIGN KEY "+quote(fc.From.Name))
+		}
+		for _, idx := range td.RemovedIndexes {
+			clauses = append(clauses, dropIndex(idx))
+		}
+		for _, ic := range td.ChangedIndexes {
+			clauses = append(clauses, dropIndex(ic.From))
+		}
+		if len(clauses) > 0 {
+			drops = append(drops, alterTable(td.Name, clauses))
+		}
+	}
+
+	for _, t := range d.AddedTables {
+		tables = append(tables, CreateTable(t))
+		for _, fk := range t.ForeignKeys {
+			fks = append(fks, "ALTER TABLE "+quote(t.Name)+" ADD "+foreignKeyDefinition(fk)+";")
+		}
+	}
+
+	for _, td := range d.ChangedTables {
+		var clauses []string
+		for _, f := range td.AddedColumns {
+			clauses = append(clauses, "ADD COLUMN "+ColumnDefinition(f)+columnPosition(td.ToTable, f.Name))
+		}
+		for _, cc := range td.ChangedColumns {
+			clauses = append(clauses, "MODIFY COLUMN "+ColumnDefinition(cc.To))
+		}
+		for _, f := range td.RemovedColumns {
+			clauses = append(clauses, "DROP COLUMN "+quote(f.Name))
+		}
+		for _, idx := range td.AddedIndexes {
+			clauses = append(clauses, "ADD "+indexDefinition(idx))
+		}
+		for _, ic := range td.ChangedIndexes {
+			clauses = append(clauses, "ADD "+indexDefinition(ic.To))
+		}
+		if len(clauses) > 0 {
+			alters = append(alters, alterTable(td.Name, clauses))
+		}
+
+		clauses = nil
+		for _, fk := range td.AddedForeignKeys {
+			clauses = append(clauses, "ADD "+foreignKeyDefinition(fk))
+		}
+		for _, fc := range td.ChangedForeignKeys {
+			clauses = append(clauses, "ADD "+foreignKeyDefinition(fc.To))
+		}
+		if len(clauses) > 0 {
+			fks = append(fks, alterTable(td.Name, clauses))
+		}
+	}
+
+	var stmts []string
+	stmts = append(stmts, drops...)
+	stmts = append(stmts, tables...)
+	stmts = append(stmts, alters...)
+	stmts = append(stmts, fks...)
+	for _, t := range dropOrder(d.RemovedTables) {
+		stmts = append(stmts, "DROP TABLE "+quote(t.Name)+";")
+	}
+
+	return stmts
+}
+
+// SQL renders the statements as a migration script, with a warning for every table or column it may lose data of.
+func (d *SchemaDiff) SQL() string {
+	t := "-- " + d.From + " -> " + d.To + "\n"
+	for _, l := range d.DataLoss() {
+		t += "-- WARNING: " + l + "\n"
+	}
+
+	return t + "\n" + strings.Join(d.Statements(), "\n\n") + "\n"
+}
+
+// Reverse returns the diff that undoes d. Dropped tables and columns come back empty, their data is not restored.
+func (d *SchemaDiff) Reverse() *SchemaDiff {
+	var from, to []conf.Table
+	from = append(from, d.AddedTables...)
+	to = append(to, d.RemovedTables...)
+	for _, td := range d.ChangedTables {
+		from = append(from, td.ToTable)
+		to = append(to, td.FromTable)
+	}
+
+	r := Compare(from, to)
+	r.From, r.To = d.To, d.From
+
+	return r
+}
+
+// DataLoss lists what the statements of d may lose: the tables and columns they drop, the columns whose type they
+// narrow or that they make NOT NULL, and the generated columns they create as plain ones.
+func (d *SchemaDiff) DataLoss() []string {
+	var lost []string
+	for _, t := range d.RemovedTables {
+		lost = append(lost, "drops table "+t.Name)
+	}
+	for _, t := range d.AddedTables {
+		lost = append(lost, generatedColumns(t.Name, t.Fields)...)
+	}
+	for _, td := range d.ChangedTables {
+		for _, f := range td.RemovedColumns {
+			lost = append(lost, "drops column "+td.Name+"."+f.Name)
+		}
+		lost = append(lost, generatedColumns(td.Name, td.AddedColumns)...)
+		for _, cc := range td.ChangedColumns {
+			name := td.Name + "." + cc.Name
+			if narrows(cc.From.ColumnType, cc.To.ColumnType) {
+				lost = append(lost, "narrows column "+name+" from "+cc.From.ColumnType+" to "+cc.To.ColumnType)
+			}
+			if cc.From.Nullable && !cc.To.Nullable {
+				lost = append(lost, "makes column "+name+" NOT NULL")
+			}
+			lost = append(lost, generatedColumns(td.Name, []conf.TableField{cc.To})...)
+		}
+	}
+
+	return lost
+}
+
+// generatedColumns lists the generated columns of fields, whose expression is not part of the schema information.
+func generatedColumns(table string, fields []conf.TableField) []string {
+	var lost []string
+	for _, f := range fields {
+		if isGenerated(f) {
+			lost = append(lost, "creates generated column "+table+"."+f.Name+" as a plain column, its expression is unknown")
+		}
+	}
+	return lost
+}
+
+func isGenerated(f conf.TableField) bool {
+	return strings.Contains(strings.ToLower(f.Extra), "generated")
+}
+
+// Ranks of the types of a family, a lower rank holds fewer values.
+var typeRanks = map[string]struct {
+	family string
+	rank   int
+}{
+	"tinyint":   {"int", 1},
+	"smallint":  {"int", 2},
+	"mediumint": {"int", 3},
+	"int":       {"int", 4},
+	"integer":   {"int", 4},
+	"bigint":    {"int", 5},
+
+	"char":       {"string", 1},
+	"varchar":    {"string", 1},
+	"tinytext":   {"string", 2},
+	"text":       {"string", 3},
+	"mediumtext": {"string", 4},
+	"longtext":   {"string", 5},
+
+	"binary":     {"bytes", 1},
+	"varbinary":  {"bytes", 1},
+	"tinyblob":   {"bytes", 2},
+	"blob":       {"bytes", 3},
+	"mediumblob": {"bytes", 4},
+	"longblob":   {"bytes", 5},
+}
+
+// narrows reports whether changing a column from type from to type to may lose or reject stored values. Changes
+// between unrelated types count as narrowing.
+func narrows(from, to string) bool {
+	if strings.EqualFold(from, to) {
+		return false
+	}
+	fb, fargs, funsigned := parseColumnType(from)
+	tb, targs, tunsigned := parseColumnType(to)
+	fr, fok := typeRanks[fb]
+	tr, tok := typeRanks[tb]
+
+	switch {
+	case fok && tok && fr.family == tr.family:
+		if fr.family == "int" {
+			return tr.rank < fr.rank || funsigned != tunsigned && (tr.rank == fr.rank || tunsigned)
+		}
+		if tr.rank != fr.rank {
+			return tr.rank < fr.rank
+		}
+		// char, varchar, binary and varbinary hold their length
+		return argAt(targs, 0) < argAt(fargs, 0)
+	case fb != tb:
+		return true
+	case fb == "enum" || fb == "set":
+		for _, v := range fargs {
+			if !slices.Contains(targs, v) {
+				return true
+			}
+		}
+		return false
+	case fb == "decimal" || fb == "numeric":
+		// the digits before and after the point
+		fp, fs := argAt(fargs, 0), argAt(fargs, 1)
+		tp, ts := argAt(targs, 0), argAt(targs, 1)
+		return ts < fs || tp-ts < fp-fs || tunsigned && !funsigned
+	case fb == "datetime" || fb == "timestamp" || fb == "time":
+		// the fractional seconds
+		return argAt(targs, 0) < argAt(fargs, 0)
+	}
+	return funsigned != tunsigned || argAt(targs, 0) < argAt(fargs, 0) || argAt(targs, 1) < argAt(fargs, 1)
+}
+
+// parseColumnType splits a COLUMN_TYPE like decimal(10,2) unsigned into its lowercase base type, its arguments and
+// whether it is unsigned.
+func parseColumnType(ct string) (string, []string, bool) {
+	ct = strings.TrimSpace(ct)
+	base, rest, _ := strings.Cut(ct, "(")
+	var args []string
+	if rest != "" {
+		i := strings.LastIndexByte(rest, ')')
+		if i < 0 {
+			i = len(rest)
+		}
+		args = splitArgs(rest[:i])
+		rest = rest[min(i+1, len(rest)):]
+	} else if j := strings.IndexByte(base, ' '); j >= 0 {
+		base, rest = base[:j], base[j:]
+	}
+	return strings.ToLower(strings.TrimSpace(base)), args, strings.Contains(strings.ToLower(rest), "unsigned")
+}
+
+// splitArgs splits the arguments of a column type on the commas outside quotes.
+func splitArgs(s string) []string {
+	var args []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ',':
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(args, strings.TrimSpace(s[start:]))
+}
+
+// argAt returns the i-th argument as a number, 0 when it is missing.
+func argAt(args []string, i int) int {
+	if i >= len(args) {
+		return 0
+	}
+	n, _ := strconv.Atoi(args[i])
+	return n
+}
+
+func CreateTable(t conf.Table) string {
+	var defs []string
+	for _, f := range t.Fields {
+		defs = append(defs, ColumnDefinition(f))
+	}
+	for _, idx := range t.Indexes {
+		defs = append(defs, indexDefinition(idx))
+	}
+
+	return "CREATE TABLE " + quote(t.Name) + " (\n  " + strings.Join(defs, ",\n  ") + "\n);"
+}
+
+// ColumnDefinition renders a column the way SHOW CREATE TABLE does.
+func ColumnDefinition(f conf.TableField) string {
+	s := quote(f.Name) + " " + f.ColumnType
+
+	extra := strings.ToLower(f.Extra)
+	if strings.Contains(extra, "generated") {
+		// the expression is not part of the schema information, keep the column as a plain one
+		extra = ""
+	}
+
+	if f.Nullable {
+		s += " NULL"
+	} else {
+		s += " NOT NULL"
+	}
+	if f.Default != "" && !(f.Default == "NULL" && !f.Nullable) {
+		s += " DEFAULT " + f.Default
+	}
+	if strings.Contains(extra, "auto_increment") {
+		s += " AUTO_INCREMENT"
+	}
+	if i := strings.Index(extra, "on update "); i >= 0 {
+		s += " ON UPDATE " + f.Extra[i+len("on update "):]
+	}
+
+	return s
+}
+
+func indexDefinition(idx conf.Index) string {
+	cols := quoteAll(idx.Columns)
+	switch {
+	case idx.Primary:
+		return "PRIMARY KEY (" + cols + ")"
+	case idx.Unique:
+		return "UNIQUE KEY " + quote(idx.Name) + " (" + cols + ")"
+	case idx.Kind != "":
+		return idx.Kind + " KEY " + quote(idx.Name) + " (" + cols + ")"
+	}
+	return "KEY " + quote(idx.Name) + " (" + cols + ")"
+}
+
+func dropIndex(idx conf.Index) string {
+	if idx.Primary {
+		return "DROP PRIMARY KEY"
+	}
+	return "DROP INDEX " + quote(idx.Name)
+}
+
+func foreignKeyDefinition(fk conf.ForeignKey) string {
+	ref := quote(fk.RefTable)
+	if fk.RefSchema != "" {
+		ref = quote(fk.RefSchema) + "." + ref
+	}
+
+	s := "CONSTRAINT " + quote(fk.Name) + " FOREIGN KEY (" + quoteAll(fk.Columns) + ") REFERENCES " + ref +
+		" (" + quoteAll(fk.RefColumns) + ")"
+	if fk.OnDelete != "" {
+		s += " ON DELETE " + fk.OnDelete
+	}
+	if fk.OnUpdate != "" {
+		s += " ON UPDATE " + fk.OnUpdate
+	}
+
+	return s
+}
+
+// columnPosition keeps the column order of the target table for added columns.
+func columnPosition(t conf.Table, name string) string {
+	for i, f := range t.Fields {
+		if f.Name != name {
+			continue
+		}
+		if i == 0 {
+			return " FIRST"
+		}
+		return " AFTER " + quote(t.Fields[i-1].Name)
+	}
+	return ""
+}
+
+func alterTable(name string, clauses []string) string {
+	return "ALTER TABLE " + quote(name) + "\n  " + strings.Join(clauses, ",\n  ") + ";"
+}
+
+// dropOrder puts tables before the tables they reference, so dropping them in order never breaks a foreign key.
+func dropOrder(tables []conf.Table) []conf.Table {
+	sorted := append([]conf.Table{}, tables...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	var out []conf.Table
+	done := map[string]bool{}
+	visiting := map[string]bool{}
+	var visit func(t conf.Table)
+	visit = func(t conf.Table) {
+		if done[t.Name] || visiting[t.Name] {
+			return
+		}
+		visiting[t.Name] = true
+		// referencing tables go first
+		for _, other := range sorted {
+			for _, fk := range other.ForeignKeys {
+				if fk.RefSchema == "" && fk.RefTable == t.Name && other.Name != t.Name {
+					visit(other)
+				}
+			}
+		}
+		visiting[t.Name] = false
+		done[t.Name] = true
+		out = append(out, t)
+	}
+	for _, t := range sorted {
+		visit(t)
+	}
+
+	return out
+}
+
+func quote(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func quoteAll(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = quote(n)
+	}
+	return strings.Join(quoted, ", ")
+}
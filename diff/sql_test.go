@@ -0,0 +1,139 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rah-0/margo/conf"
+)
+
+func TestStatements(t *testing.T) {
+	d := Compare(schemaV1(), schemaV2())
+
+	expected := []string{
+		"ALTER TABLE `alpha`\n" +
+			"  DROP FOREIGN KEY `fk_beta`,\n" +
+			"  DROP INDEX `idx_name`;",
+		"CREATE TABLE `delta` (\n" +
+			"  `id` int(11) NOT NULL\n" +
+			");",
+		"ALTER TABLE `alpha`\n" +
+			"  ADD COLUMN `created` datetime(6) NOT NULL DEFAULT current_timestamp(6) AFTER `beta_id`,\n" +
+			"  MODIFY COLUMN `name` varchar(255) NOT NULL DEFAULT '',\n" +
+			"  DROP COLUMN `legacy`,\n" +
+			"  ADD KEY `idx_created` (`created`),\n" +
+			"  ADD UNIQUE KEY `idx_name` (`name`);",
+		"ALTER TABLE `alpha`\n" +
+			"  ADD CONSTRAINT `fk_beta` FOREIGN KEY (`beta_id`) REFERENCES `beta` (`id`) ON DELETE CASCADE ON UPDATE RESTRICT;",
+		"DROP TABLE `gamma`;",
+	}
+	got := d.Statements()
+	if strings.Join(got, "\n\n") != strings.Join(expected, "\n\n") {
+		t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(expected, "\n\n"), strings.Join(got, "\n\n"))
+	}
+
+	lost := d.DataLoss()
+	expected = []string{"drops table gamma", "drops column alpha.legacy", "makes column alpha.name NOT NULL"}
+	if strings.Join(lost, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected data loss: %q", lost)
+	}
+}
+
+func TestDataLoss(t *testing.T) {
+	from := []conf.Table{{Name: "alpha", Fields: []conf.TableField{
+		{Name: "id", ColumnType: "int(10) unsigned"},
+		{Name: "code", ColumnType: "varchar(20)"},
+	}}}
+	to := []conf.Table{{Name: "alpha", Fields: []conf.TableField{
+		{Name: "id", ColumnType: "bigint(20) unsigned"},
+		{Name: "code", ColumnType: "varchar(10)"},
+		{Name: "total", ColumnType: "int(11)", Nullable: true, Extra: "STORED GENERATED"},
+	}}}
+
+	d := Compare(from, to)
+	d.From, d.To = "a", "b"
+	expected := "-- a -> b\n" +
+		"-- WARNING: creates generated column alpha.total as a plain column, its expression is unknown\n" +
+		"-- WARNING: narrows column alpha.code from varchar(20) to varchar(10)\n"
+	if got := d.SQL(); !strings.HasPrefix(got, expected) {
+		t.Errorf("expected the warnings:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestNarrows(t *testing.T) {
+	tests := []struct {
+		from, to string
+		expected bool
+	}{
+		{"int(11)", "int(10)", false},
+		{"int(11)", "bigint(20)", false},
+		{"bigint(20)", "int(11)", true},
+		{"int(10) unsigned", "int(11)", true},
+		{"int(10) unsigned", "bigint(20)", false},
+		{"int(11)", "bigint(20) unsigned", true},
+		{"varchar(100)", "varchar(255)", false},
+		{"varchar(255)", "char(100)", true},
+		{"varchar(255)", "text", false},
+		{"mediumtext", "text", true},
+		{"decimal(10,2)", "decimal(12,2)", false},
+		{"decimal(10,2)", "decimal(10,4)", true},
+		{"datetime(6)", "datetime", true},
+		{"enum('a','b')", "enum('a','b','c')", false},
+		{"enum('a','b')", "enum('a')", true},
+		{"int(11)", "varchar(255)", true},
+	}
+
+	for _, tt := range tests {
+		if got := narrows(tt.from, tt.to); got != tt.expected {
+			t.Errorf("narrows(%q, %q) = %v; want %v", tt.from, tt.to, got, tt.expected)
+		}
+	}
+}
+
+func TestReverse(t *testing.T) {
+	d := Compare(schemaV1(), schemaV2())
+	r := d.Reverse()
+
+	// applying the reverse must lead back to v1
+	back := Compare(schemaV2(), schemaV1())
+	if r.Text() != back.Text() {
+		t.Errorf("expected:\n%s\ngot:\n%s", back.Text(), r.Text())
+	}
+	if !strings.Contains(strings.Join(r.Statements(), "\n"), "ADD COLUMN `legacy` int(11) NOT NULL DEFAULT 0 AFTER `name`") {
+		t.Errorf("dropped column is not restored in place:\n%s", strings.Join(r.Statements(), "\n"))
+	}
+}
+
+func TestColumnDefinition(t *testing.T) {
+	tests := []struct {
+		field    conf.TableField
+		expected string
+	}{
+		{conf.TableField{Name: "id", ColumnType: "int(10) unsigned", Extra: "auto_increment"}, "`id` int(10) unsigned NOT NULL AUTO_INCREMENT"},
+		{conf.TableField{Name: "note", ColumnType: "text", Nullable: true, Default: "NULL"}, "`note` text NULL DEFAULT NULL"},
+		{conf.TableField{Name: "updated", ColumnType: "datetime(6)", Default: "current_timestamp(6)", Extra: "on update current_timestamp(6)"}, "`updated` datetime(6) NOT NULL DEFAULT current_timestamp(6) ON UPDATE current_timestamp(6)"},
+		{conf.TableField{Name: "total", ColumnType: "int(11)", Nullable: true, Extra: "VIRTUAL GENERATED"}, "`total` int(11) NULL"},
+	}
+
+	for _, tt := range tests {
+		if got := ColumnDefinition(tt.field); got != tt.expected {
+			t.Errorf("got %q; want %q", got, tt.expected)
+		}
+	}
+}
+
+func TestDropOrder(t *testing.T) {
+	tables := []conf.Table{
+		{Name: "a"},
+		{Name: "b", ForeignKeys: []conf.ForeignKey{{RefTable: "c"}}},
+		{Name: "c", ForeignKeys: []conf.ForeignKey{{RefTable: "a"}}},
+	}
+
+	var names []string
+	for _, t := range dropOrder(tables) {
+		names = append(names, t.Name)
+	}
+	if strings.Join(names, ",") != "b,c,a" {
+		t.Errorf("got %v; want [b c a]", names)
+	}
+}
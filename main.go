@@ -1,67 +1,385 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/rah-0/nabu"
 
 	"github.com/rah-0/margo/conf"
 	"github.com/rah-0/margo/db"
+	"github.com/rah-0/margo/diff"
+	"github.com/rah-0/margo/migration"
+	"github.com/rah-0/margo/naming"
+	"github.com/rah-0/margo/snapshot"
 	"github.com/rah-0/margo/template"
+	"github.com/rah-0/margo/util"
+)
+
+// Exit codes, a usage error is reported the same way the flag package does.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
 )
 
+// errStale is returned by -check when the generated code differs from the schema.
+var errStale = errors.New("generated code is stale")
+
 func main() {
-	conf.CheckFlags()
+	os.Exit(run(os.Args[1:]))
+}
 
-	conn, err := db.Connect()
-	if err != nil {
-		nabu.FromError(err).Log()
-		return
+func run(args []string) int {
+	err := conf.Parse(args, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
 	}
-	defer conn.Close()
-
-	if err = template.PathCreateOutputDir(); err != nil {
-		nabu.FromError(err).WithLevelFatal().Log()
-		return
+	var usageErr *conf.UsageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintln(os.Stderr, "Error: "+usageErr.Error())
+		return exitUsage
 	}
 
-	if err = template.PathCreateDBDir(); err != nil {
+	if err = naming.Load(); err != nil {
 		nabu.FromError(err).WithLevelFatal().Log()
-		return
+		return exitFailure
 	}
 
-	tableNames, err := db.GetDbTables(conn)
+	switch conf.Args.Command {
+	case conf.CommandVersion:
+		fmt.Print(versionInfo())
+	case conf.CommandSnapshot:
+		err = runSnapshot()
+	case conf.CommandDiff:
+		err = runDiff()
+	case conf.CommandMigrate:
+		err = runMigrate()
+	default:
+		err = runGenerate()
+	}
+	if errors.Is(err, errStale) {
+		return exitFailure
+	}
 	if err != nil {
 		nabu.FromError(err).WithLevelFatal().Log()
-		return
+		return exitFailure
 	}
 
-	if err = template.PathCreateTableDirs(tableNames); err != nil {
-		nabu.FromError(err).WithLevelFatal().Log()
-		return
+	return exitOK
+}
+
+func runSnapshot() error {
+	tables, nqs, err := loadSchema(conf.Args.SchemaPath, "")
+	if err != nil {
+		return err
 	}
 
-	nqs, err := template.CreateGoFileQueries(tableNames)
+	return snapshot.Write(conf.Args.SnapshotPath, snapshot.New(conf.Args.DBName, tables, nqs))
+}
+
+func runGenerate() error {
+	schemas, err := loadSchemas()
 	if err != nil {
-		nabu.FromError(err).WithLevelFatal().Log()
-		return
+		return err
 	}
 
-	for _, tn := range tableNames {
-		tfs, err := db.GetDbTableFields(conn, tn)
+	// Validate every identifier before anything is written
+	packages := map[string]string{template.RuntimePackage: "the runtime package"}
+	for _, s := range schemas {
+		pkg := strings.ToLower(naming.Package(s.Name))
+		if other, ok := packages[pkg]; ok {
+			return nabu.FromError(fmt.Errorf("schema %q maps to package %q, already used by %q", s.Name, naming.Package(s.Name), other)).Log()
+		}
+		packages[pkg] = s.Name
+
+		report, err := naming.Validate(s.Tables)
 		if err != nil {
-			nabu.FromError(err).WithLevelFatal().Log()
-			return
+			fmt.Fprintln(os.Stderr, report.Error())
+			return err
+		}
+		for _, p := range report.Problems {
+			fmt.Fprintln(os.Stderr, p.String())
+		}
+
+		// the version, soft delete and autofill columns must exist and have a fitting type
+		for _, t := range s.Tables {
+			if _, err = template.ResolveManagedFields(t.Name, t.Fields); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = template.PathCreateOutputDir(); err != nil {
+		return err
+	}
+
+	if err = template.CreateGoFileRuntime(); err != nil {
+		return err
+	}
+
+	refs, notes, err := template.ResolveReferences(schemas)
+	if err != nil {
+		return err
+	}
+	for _, n := range notes {
+		fmt.Fprintln(os.Stderr, n)
+	}
+
+	// the template package works on conf.Args.DBName
+	dbName := conf.Args.DBName
+	defer func() { conf.Args.DBName = dbName }()
+
+	tables, queries := 0, 0
+	for _, s := range schemas {
+		conf.Args.DBName = s.Name
+		if err = generateSchema(s, refs); err != nil {
+			return err
+		}
+		tables += len(s.Tables)
+		queries += len(s.Queries)
+	}
+
+	if conf.Args.DryRun {
+		for _, fc := range util.FileChanges() {
+			fmt.Println(fc.Status + " " + fc.Path)
+		}
+		for _, fc := range util.StaleFiles() {
+			fmt.Print(fc.Diff)
 		}
-		
+	}
+
+	if conf.Args.Check {
+		stale := util.StaleFiles()
+		if len(stale) > 0 {
+			fmt.Fprintln(os.Stderr, "Generated code is stale, run margo to regenerate:")
+			for _, fc := range stale {
+				fmt.Fprintln(os.Stderr, " ", fc.Status, fc.Path)
+			}
+			return errStale
+		}
+		return nil
+	}
+
+	printSummary(tables, queries)
+	return nil
+}
+
+// generateSchema writes the package tree of the schema in conf.Args.DBName.
+func generateSchema(s conf.Schema, refs map[string][]template.Reference) error {
+	tableNames := make([]string, 0, len(s.Tables))
+	for _, t := range s.Tables {
+		tableNames = append(tableNames, t.Name)
+	}
+
+	if err := template.PathCreateDBDir(); err != nil {
+		return err
+	}
+
+	if err := template.PathCreateTableDirs(tableNames); err != nil {
+		return err
+	}
+
+	nqs, err := template.CreateGoFileQueries(tableNames, s.Queries)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range s.Tables {
 		tnqs := []conf.NamedQuery{}
 		for _, nq := range nqs {
-			if nq.MapAs == tn {
+			if nq.MapAs == t.Name {
 				tnqs = append(tnqs, nq)
 			}
 		}
 
-		if err := template.CreateGoFileEntity(tn, tfs, tnqs); err != nil {
-			nabu.FromError(err).WithLevelFatal().Log()
-			return
+		if err = template.CreateGoFileEntity(t.Name, t.Fields, tnqs, refs[template.ReferenceKey(s.Name, t.Name)]); err != nil {
+			return err
 		}
 	}
+
+	if conf.Args.Prune {
+		return template.PruneStaleFiles()
+	}
+	return nil
+}
+
+// printSummary reports what a generate run did, or would do with -dryRun.
+func printSummary(tables, queries int) {
+	counts := map[string]int{}
+	for _, fc := range util.FileChanges() {
+		counts[fc.Status]++
+	}
+
+	verb := "Generated"
+	if conf.Args.DryRun {
+		verb = "Would generate"
+	}
+	fmt.Printf("%s %d tables and %d queries into %s: %d created, %d modified, %d unchanged, %d deleted\n",
+		verb, tables, queries, conf.Args.OutputPath,
+		counts[util.FileCreated], counts[util.FileModified], counts[util.FileUnchanged], counts[util.FileDeleted])
+}
+
+// loadSchemas reads every schema of -dbName, pairing them by position with the files of -schemaPath or
+// -snapshotPath when those are lists. Named queries from -queriesPath are generated into the first schema.
+func loadSchemas() ([]conf.Schema, error) {
+	schemaPaths := conf.SplitList(conf.Args.SchemaPath)
+	snapshotPaths := conf.SplitList(conf.Args.SnapshotPath)
+	n := max(len(conf.Args.Schemas), len(schemaPaths), len(snapshotPaths))
+
+	dbName := conf.Args.DBName
+	defer func() { conf.Args.DBName = dbName }()
+
+	schemas := make([]conf.Schema, 0, n)
+	for i := 0; i < n; i++ {
+		conf.Args.DBName = ""
+		if i < len(conf.Args.Schemas) {
+			conf.Args.DBName = conf.Args.Schemas[i]
+		}
+		schemaPath, snapshotPath := "", ""
+		if i < len(schemaPaths) {
+			schemaPath = schemaPaths[i]
+		}
+		if i < len(snapshotPaths) {
+			snapshotPath = snapshotPaths[i]
+		}
+
+		tables, nqs, err := loadSchema(schemaPath, snapshotPath)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && conf.Args.QueriesPath != "" {
+			nqs = nil
+		}
+		schemas = append(schemas, conf.Schema{Name: conf.Args.DBName, Tables: tables, Queries: nqs})
+	}
+
+	return schemas, nil
+}
+
+// loadSchema reads tables and named queries of conf.Args.DBName from a snapshot, a DDL dump or the database, in that
+// order of preference.
+func loadSchema(schemaPath, snapshotPath string) ([]conf.Table, []conf.NamedQuery, error) {
+	if snapshotPath != "" {
+		s, err := snapshot.Read(snapshotPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		if conf.Args.DBName == "" {
+			conf.Args.DBName = s.Schema
+		}
+
+		// queries from -queriesPath win over the recorded ones
+		if conf.Args.QueriesPath == "" {
+			return s.Tables, s.Queries, nil
+		}
+		nqs, err := template.LoadNamedQueries()
+		return s.Tables, nqs, err
+	}
+
+	nqs, err := template.LoadNamedQueries()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if schemaPath != "" {
+		tables, err := db.LoadDDL(schemaPath)
+		return tables, nqs, err
+	}
+
+	conn, err := db.Connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	tables, err := db.GetDbSchema(conn, conf.Args.DBName)
+	return tables, nqs, err
+}
+
+func runDiff() error {
+	from, err := diff.LoadSource(conf.Args.DiffFrom)
+	if err != nil {
+		return err
+	}
+	to, err := diff.LoadSource(conf.Args.DiffTo)
+	if err != nil {
+		return err
+	}
+
+	d := diff.Compare(from, to)
+	d.From = diff.SourceName(conf.Args.DiffFrom)
+	d.To = diff.SourceName(conf.Args.DiffTo)
+
+	switch conf.Args.DiffFormat {
+	case diff.FormatJSON:
+		out, err := d.JSON()
+		if err != nil {
+			return err
+		}
+		fmt.Print(out)
+	case diff.FormatSQL:
+		if !d.Empty() {
+			fmt.Print(d.SQL())
+		}
+	default:
+		fmt.Print(d.Text())
+	}
+
+	if conf.Args.MigrationsPath == "" || d.Empty() {
+		return nil
+	}
+	m, err := migration.Write(conf.Args.MigrationsPath, conf.Args.MigrationName, d.SQL(), d.Reverse().SQL())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "created "+m.UpPath)
+	fmt.Fprintln(os.Stderr, "created "+m.DownPath)
+
+	return nil
+}
+
+func runMigrate() error {
+	conn, err := db.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if conf.Args.MigrateAction == conf.MigrateStatus {
+		states, err := migration.Status(conn, conf.Args.MigrationsPath)
+		if err != nil {
+			return err
+		}
+		for _, s := range states {
+			fmt.Printf("%04d %-40s %-8s %s\n", s.Version, s.Name, s.Status, s.AppliedAt)
+		}
+		return nil
+	}
+
+	var done []migration.File
+	if conf.Args.MigrateAction == conf.MigrateUp {
+		done, err = migration.Up(conn, conf.Args.MigrationsPath, conf.Args.MigrateSteps)
+	} else {
+		done, err = migration.Down(conn, conf.Args.MigrationsPath, conf.Args.MigrateSteps)
+	}
+	// report what ran even when a later migration failed
+	for _, f := range done {
+		fmt.Printf("%s %04d_%s\n", conf.Args.MigrateAction, f.Version, f.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(done) == 0 {
+		fmt.Println("No migrations to run")
+		return nil
+	}
+
+	if conf.Args.Regenerate {
+		return runGenerate()
+	}
+	return nil
 }
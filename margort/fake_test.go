@@ -0,0 +1,100 @@
+package margort
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+)
+
+// fakeDriver is a database/sql driver that records the statements it is given and fails the ones it is told to.
+type fakeDriver struct {
+	mu   sync.Mutex
+	log  []string
+	errs []error
+}
+
+var fake = &fakeDriver{}
+
+func init() { sql.Register("margort_fake", fake) }
+
+// openFake returns a database on the fake driver with an empty log.
+func openFake(t *testing.T) *sql.DB {
+	t.Helper()
+	fake.reset()
+	db, err := sql.Open("margort_fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// reset clears the log and the queued errors.
+func (d *fakeDriver) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.log, d.errs = nil, nil
+}
+
+// fail queues the errors of the next statements, nil for one that succeeds.
+func (d *fakeDriver) fail(errs ...error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.errs = append(d.errs, errs...)
+}
+
+// statements returns the statements run so far: BEGIN, COMMIT, ROLLBACK or the executed query with its arguments.
+func (d *fakeDriver) statements() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.log...)
+}
+
+func (d *fakeDriver) record(s string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.log = append(d.log, s)
+	if len(d.errs) > 0 {
+		err := d.errs[0]
+		d.errs = d.errs[1:]
+		return err
+	}
+	return nil
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return fakeTx(c), c.d.record("BEGIN") }
+func (c fakeConn) ExecContext(_ context.Context, q string, args []driver.NamedValue) (driver.Result, error) {
+	vs := make([]driver.Value, len(args))
+	for i, a := range args {
+		vs[i] = a.Value
+	}
+	if err := c.d.record(fmt.Sprint(q, " ", vs)); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+func (c fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return fakeRows{}, nil
+}
+
+type fakeTx struct{ d *fakeDriver }
+
+func (tx fakeTx) Commit() error   { return tx.d.record("COMMIT") }
+func (tx fakeTx) Rollback() error { return tx.d.record("ROLLBACK") }
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string         { return nil }
+func (fakeRows) Close() error              { return nil }
+func (fakeRows) Next([]driver.Value) error { return io.EOF }
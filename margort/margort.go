@@ -0,0 +1,559 @@
+package margort
+
+import (
+	"context"
+	crand "crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/rand/v2"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// DBTX is what a Repo runs its queries on, implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// RowResult is the sql.Result of an INSERT ... RETURNING, which the driver runs as a query without one.
+type RowResult struct {
+	ID   int64 // value of the auto_increment column of the row read back, 0 when there is none
+	Rows int64 // 1 when the row was read back
+}
+
+func (r RowResult) LastInsertId() (int64, error) { return r.ID, nil }
+func (r RowResult) RowsAffected() (int64, error) { return r.Rows, nil }
+
+type primaryKey struct{}
+
+// Picker returns the index of the replica a read runs on, out of n. An index out of range sends it to the primary.
+type Picker func(ctx context.Context, n int) int
+
+// RoundRobin returns a Picker that cycles through the replicas.
+func RoundRobin() Picker {
+	var next atomic.Uint64
+	return func(_ context.Context, n int) int {
+		return int((next.Add(1) - 1) % uint64(n))
+	}
+}
+
+// WithPrimary returns a context whose reads go to the primary, e.g. to read a row written just before.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// UsePrimary reports whether the reads made with ctx go to the primary, because of WithPrimary or because ctx
+// carries a transaction.
+func UsePrimary(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	if _, ok := TxFromContext(ctx); ok {
+		return true
+	}
+	v, _ := ctx.Value(primaryKey{}).(bool)
+	return v
+}
+
+type schemaKey struct{}
+
+// WithSchema returns a context in which the tables generated for schema are queried in target instead,
+// e.g. a per-test copy of the database. An empty target leaves the table names unqualified.
+func WithSchema(ctx context.Context, schema, target string) context.Context {
+	m := map[string]string{schema: target}
+	if prev, ok := ctx.Value(schemaKey{}).(map[string]string); ok {
+		for k, v := range prev {
+			if k != schema {
+				m[k] = v
+			}
+		}
+	}
+	return context.WithValue(ctx, schemaKey{}, m)
+}
+
+// Schema returns the schema WithSchema set for the tables generated for schema.
+func Schema(ctx context.Context, schema string) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	m, ok := ctx.Value(schemaKey{}).(map[string]string)
+	if !ok {
+		return "", false
+	}
+	target, ok := m[schema]
+	return target, ok
+}
+
+// Qualify returns the quoted table name, prefixed with the quoted schema unless it is empty.
+func Qualify(schema, table string) string {
+	table = "`" + strings.ReplaceAll(table, "`", "``") + "`"
+	if schema == "" {
+		return table
+	}
+	return "`" + strings.ReplaceAll(schema, "`", "``") + "`." + table
+}
+
+// Deleted selects the rows of a soft deleted table that DBSelect, DBExists, DBUpdate and DBUpdateWhere see, set with
+// QueryParams.WithDeleted of the generated packages.
+type Deleted int
+
+const (
+	ExcludeDeleted Deleted = iota // the rows not deleted, the default
+	IncludeDeleted                // every row
+	OnlyDeleted                   // the deleted rows only
+)
+
+// NewUUID returns the value DBInsert fills the empty UUID columns with. Set it to UUIDv4 or another generator
+// before the first insert.
+var NewUUID = UUIDv7
+
+// Now returns the time DBInsert and DBUpdate fill the created and updated columns with, e.g. set it to return
+// time.Now().UTC() when the session time zone is UTC.
+var Now = time.Now
+
+// NextTimestamp returns the value of a timestamp version column after v, both in layout: the current UTC time
+// truncated to unit, the precision of the column. Two updates can fall on the same value at that precision, which
+// MariaDB does not count as a change, so the result is always past v.
+func NextTimestamp(v, layout string, unit time.Duration) string {
+	next := time.Now().UTC().Truncate(unit)
+	if current, err := time.Parse(layout, v); err == nil && !next.After(current) {
+		next = current.Add(unit)
+	}
+	return next.Format(layout)
+}
+
+// UUIDv4 returns a random UUID.
+func UUIDv4() string {
+	var b [16]byte
+	crand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return formatUUID(b)
+}
+
+// UUIDv7 returns a UUID starting with the Unix time in milliseconds, so that keys sort by creation time.
+func UUIDv7() string {
+	var b [16]byte
+	crand.Read(b[6:])
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixMilli()))
+	copy(b[:6], ms[2:])
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+	return formatUUID(b)
+}
+
+func formatUUID(b [16]byte) string {
+	h := hex.EncodeToString(b[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// Cond is a condition of DBDeleteWhere and DBUpdateWhere on a field: Field Op Value, a list of values for IN
+// and NOT IN, none for IS NULL and IS NOT NULL.
+type Cond struct {
+	Field string
+	Op    string
+	Value any
+}
+
+func Eq(field string, v any) Cond        { return Cond{Field: field, Op: "=", Value: v} }
+func Ne(field string, v any) Cond        { return Cond{Field: field, Op: "<>", Value: v} }
+func Lt(field string, v any) Cond        { return Cond{Field: field, Op: "<", Value: v} }
+func Le(field string, v any) Cond        { return Cond{Field: field, Op: "<=", Value: v} }
+func Gt(field string, v any) Cond        { return Cond{Field: field, Op: ">", Value: v} }
+func Ge(field string, v any) Cond        { return Cond{Field: field, Op: ">=", Value: v} }
+func Like(field string, v any) Cond      { return Cond{Field: field, Op: "LIKE", Value: v} }
+func In(field string, vs ...any) Cond    { return Cond{Field: field, Op: "IN", Value: vs} }
+func NotIn(field string, vs ...any) Cond { return Cond{Field: field, Op: "NOT IN", Value: vs} }
+func IsNull(field string) Cond           { return Cond{Field: field, Op: "IS NULL"} }
+func IsNotNull(field string) Cond        { return Cond{Field: field, Op: "IS NOT NULL"} }
+
+// SQL returns the condition on the quoted column with its placeholders, and the values bound to them.
+func (c Cond) SQL(column string) (string, []any, error) {
+	switch c.Op {
+	case "=", "<>", "<", "<=", ">", ">=", "<=>", "LIKE", "NOT LIKE":
+		return column + " " + c.Op + " ?", []any{c.Value}, nil
+	case "IS NULL", "IS NOT NULL":
+		return column + " " + c.Op, nil, nil
+	case "IN", "NOT IN":
+		vs, ok := c.Value.([]any)
+		if !ok {
+			return "", nil, errors.New(c.Op + " needs a []any value: " + c.Field)
+		}
+		if len(vs) == 0 {
+			// nothing is in an empty list, and a NOT IN matching every row would change the whole table
+			if c.Op == "IN" {
+				return "FALSE", nil, nil
+			}
+			return "", nil, errors.New("NOT IN needs at least one value: " + c.Field)
+		}
+		return column + " " + c.Op + " (?" + strings.Repeat(", ?", len(vs)-1) + ")", vs, nil
+	}
+	return "", nil, errors.New("unsupported operator " + c.Op + ": " + c.Field)
+}
+
+// Where returns the conditions joined with AND, each on the column that column returns for its field, and the
+// values bound to them. column returns an empty string for an unknown field. At least one condition is required, so
+// that a missing one cannot change the whole table.
+func Where(conds []Cond, column func(field string) string) (string, []any, error) {
+	if len(conds) == 0 {
+		return "", nil, errors.New("params.Conds must not be empty")
+	}
+	parts := make([]string, 0, len(conds))
+	var args []any
+	for _, c := range conds {
+		col := column(c.Field)
+		if col == "" {
+			return "", nil, errors.New("unknown field " + c.Field)
+		}
+		part, values, err := c.SQL(col)
+		if err != nil {
+			return "", nil, err
+		}
+		parts = append(parts, part)
+		args = append(args, values...)
+	}
+	return strings.Join(parts, " AND "), args, nil
+}
+
+// Assignment sets Field to Value in DBUpdateWhere.
+type Assignment struct {
+	Field string
+	Value any
+}
+
+func Assign(field string, v any) Assignment { return Assignment{Field: field, Value: v} }
+
+// Classes of MariaDB errors, matched by errors.Is on the errors of the generated functions and by the Is
+// functions on any error.
+var (
+	ErrDuplicateKey        = errors.New("duplicate key")
+	ErrForeignKeyViolation = errors.New("foreign key violation")
+	ErrDeadlock            = errors.New("deadlock")
+	ErrLockTimeout         = errors.New("lock wait timeout")
+	ErrDataTooLong         = errors.New("data too long")
+	ErrConnectionLost      = errors.New("connection lost")
+)
+
+// ErrStaleEntity is returned by DBUpdate on a table with a version column when the row changed since it was read.
+var ErrStaleEntity = errors.New("stale entity")
+
+var duplicateKeyRegex = regexp.MustCompile(`for key '([^']+)'`)
+
+// ErrorNumber returns the MariaDB error number of err.
+func ErrorNumber(err error) (uint16, bool) {
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		return me.Number, true
+	}
+	return 0, false
+}
+
+// Classify returns the class of err, one of the Err variables, or nil.
+func Classify(err error) error {
+	if errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, driver.ErrBadConn) {
+		return ErrConnectionLost
+	}
+	n, ok := ErrorNumber(err)
+	if !ok {
+		return nil
+	}
+	switch n {
+	case 1062, 1169, 1586:
+		return ErrDuplicateKey
+	case 1216, 1217, 1451, 1452:
+		return ErrForeignKeyViolation
+	case 1213:
+		return ErrDeadlock
+	case 1205:
+		return ErrLockTimeout
+	case 1406:
+		return ErrDataTooLong
+	case 1927, 2006, 2013:
+		return ErrConnectionLost
+	}
+	return nil
+}
+
+func IsDuplicateKey(err error) bool {
+	return errors.Is(err, ErrDuplicateKey) || Classify(err) == ErrDuplicateKey
+}
+
+func IsForeignKeyViolation(err error) bool {
+	return errors.Is(err, ErrForeignKeyViolation) || Classify(err) == ErrForeignKeyViolation
+}
+
+func IsDeadlock(err error) bool {
+	return errors.Is(err, ErrDeadlock) || Classify(err) == ErrDeadlock
+}
+
+func IsLockTimeout(err error) bool {
+	return errors.Is(err, ErrLockTimeout) || Classify(err) == ErrLockTimeout
+}
+
+func IsDataTooLong(err error) bool {
+	return errors.Is(err, ErrDataTooLong) || Classify(err) == ErrDataTooLong
+}
+
+func IsConnectionLost(err error) bool {
+	return errors.Is(err, ErrConnectionLost) || Classify(err) == ErrConnectionLost
+}
+
+// DuplicateKeyIndex returns the name of the index a duplicate key error violated.
+func DuplicateKeyIndex(err error) string {
+	var me *mysql.MySQLError
+	if !IsDuplicateKey(err) || !errors.As(err, &me) {
+		return ""
+	}
+	m := duplicateKeyRegex.FindStringSubmatch(me.Message)
+	if m == nil {
+		return ""
+	}
+	// MySQL prefixes the index with the table
+	return m[1][strings.LastIndex(m[1], ".")+1:]
+}
+
+// Error is an error of a generated function, with the table and the operation it happened in.
+type Error struct {
+	Table string // qualified table name, empty for the general named queries
+	Op    string // name of the generated function, e.g. DBInsert or QueryActiveUsers
+	Index string // index a duplicate key error violated
+	Err   error
+}
+
+func (e *Error) Error() string {
+	s := e.Op
+	if e.Table != "" {
+		s += " " + e.Table
+	}
+	if e.Index != "" {
+		s += ": duplicate key " + e.Index
+	}
+	return s + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the class of the wrapped error, e.g. errors.Is(err, ErrDuplicateKey).
+func (e *Error) Is(target error) bool {
+	c := Classify(e.Err)
+	return c != nil && c == target
+}
+
+// WrapError returns err as an *Error of table and op, or err itself when it is nil or already one.
+func WrapError(err error, table, op string) error {
+	var e *Error
+	if err == nil || errors.As(err, &e) {
+		return err
+	}
+	return &Error{Table: table, Op: op, Index: DuplicateKeyIndex(err), Err: err}
+}
+
+// IsRetryable reports whether err is a deadlock or a lock wait timeout, after which the whole transaction can
+// be run again.
+func IsRetryable(err error) bool {
+	return IsDeadlock(err) || IsLockTimeout(err)
+}
+
+// Beginner begins transactions, like a *sql.DB or a *sql.Conn.
+type Beginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+type txKey struct{}
+
+// txState is a transaction a context carries, begun on db, nil when unknown. parent is the state of the context
+// it was added to, so that a context carries a transaction of each database.
+type txState struct {
+	tx     *sql.Tx
+	depth  int
+	db     any
+	parent *txState
+}
+
+// ContextWithTx returns a context carrying tx, in which RunTx uses savepoints instead of a new transaction. As the
+// database tx was begun on is unknown, queries of any database run in it, see ContextWithDBTx.
+func ContextWithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return ContextWithDBTx(ctx, nil, tx)
+}
+
+// ContextWithDBTx returns a context carrying tx begun on db. Queries and RunTx on another database ignore it.
+func ContextWithDBTx(ctx context.Context, db DBTX, tx *sql.Tx) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var origin any
+	if db != nil {
+		origin = db
+	}
+	return context.WithValue(ctx, txKey{}, &txState{tx: tx, db: origin, parent: topTx(ctx)})
+}
+
+// TxFromContext returns the transaction ctx carries, the last one added when it carries several.
+func TxFromContext(ctx context.Context) (*sql.Tx, bool) {
+	if s := topTx(ctx); s != nil {
+		return s.tx, true
+	}
+	return nil, false
+}
+
+// TxFor returns the transaction ctx carries for db: the last one added that was begun on db or on an unknown
+// database.
+func TxFor(ctx context.Context, db DBTX) (*sql.Tx, bool) {
+	if s := txFor(ctx, db); s != nil {
+		return s.tx, true
+	}
+	return nil, false
+}
+
+func topTx(ctx context.Context) *txState {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(txKey{}).(*txState)
+	return s
+}
+
+func txFor(ctx context.Context, db any) *txState {
+	for s := topTx(ctx); s != nil; s = s.parent {
+		if s.db == nil || s.db == db {
+			return s
+		}
+	}
+	return nil
+}
+
+// RunTx runs fn in a transaction begun on db, which it commits when fn returns nil and rolls back when fn returns
+// an error or panics. A transaction failing with a retryable error is run again up to retries times, waiting
+// a randomized, doubling backoff in between. The context passed to fn carries the transaction: when ctx already
+// carries one for db, see TxFor, fn runs in a savepoint of it instead, without opts and retries, and only the work
+// of fn is rolled back on error.
+func RunTx(ctx context.Context, db Beginner, opts *sql.TxOptions, retries int, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if db == nil {
+		return errors.New("db not initialized")
+	}
+	if s := txFor(ctx, db); s != nil {
+		return runSavepoint(ctx, s, fn)
+	}
+	backoff := 20 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := runTx(ctx, db, opts, fn)
+		if err == nil || attempt >= retries || !IsRetryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff/2 + rand.N(backoff/2+1)):
+		}
+		backoff = min(2*backoff, time.Second)
+	}
+}
+
+func runTx(ctx context.Context, db Beginner, opts *sql.TxOptions, fn func(ctx context.Context, tx *sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+	if err = fn(context.WithValue(ctx, txKey{}, &txState{tx: tx, db: db, parent: topTx(ctx)}), tx); err != nil {
+		if rerr := tx.Rollback(); rerr != nil && !errors.Is(rerr, sql.ErrTxDone) {
+			return errors.Join(err, rerr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// runSavepoint runs fn in a savepoint named after its depth, so that siblings reuse the name once released.
+// The rollback must run even when ctx is done. After a deadlock the server has already rolled back the whole
+// transaction, so the error is returned as is for the outermost RunTx to retry. A lock wait timeout only rolls
+// back the statement that timed out, so the work of fn is rolled back to the savepoint like on any other error.
+func runSavepoint(ctx context.Context, s *txState, fn func(ctx context.Context, tx *sql.Tx) error) (err error) {
+	name := "margo_sp_" + strconv.Itoa(s.depth+1)
+	if _, err = s.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	rollback := func() error {
+		_, err := s.tx.ExecContext(context.WithoutCancel(ctx), "ROLLBACK TO SAVEPOINT "+name)
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = rollback()
+			panic(p)
+		}
+	}()
+	inner := &txState{tx: s.tx, depth: s.depth + 1, db: s.db, parent: topTx(ctx)}
+	if err = fn(context.WithValue(ctx, txKey{}, inner), s.tx); err != nil {
+		if IsDeadlock(err) {
+			return err
+		}
+		if rerr := rollback(); rerr != nil {
+			return errors.Join(err, rerr)
+		}
+		return err
+	}
+	_, err = s.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	return err
+}
+
+// Hooks an entity implements in a non-generated file of its package. DBInsert, DBUpdate, DBDelete and
+// DBHardDelete run the Before hook first and cancel the operation when it fails, and the After hook once the
+// write succeeded, returning its error. Within a transaction the context of the hooks carries it.
+type (
+	BeforeInserter interface {
+		BeforeInsert(ctx context.Context) error
+	}
+	AfterInserter interface {
+		AfterInsert(ctx context.Context) error
+	}
+	BeforeUpdater interface {
+		BeforeUpdate(ctx context.Context) error
+	}
+	AfterUpdater interface {
+		AfterUpdate(ctx context.Context) error
+	}
+	BeforeDeleter interface {
+		BeforeDelete(ctx context.Context) error
+	}
+	AfterDeleter interface {
+		AfterDelete(ctx context.Context) error
+	}
+)
+
+// HookContext returns the context passed to the hooks of an operation run on db with ctx and tx. It is never nil and
+// carries tx, if any, so that TxFor returns it for db.
+func HookContext(ctx context.Context, db DBTX, tx *sql.Tx) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if tx == nil {
+		return ctx
+	}
+	if cur, ok := TxFor(ctx, db); ok && cur == tx {
+		return ctx
+	}
+	return ContextWithDBTx(ctx, db, tx)
+}
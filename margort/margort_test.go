@@ -0,0 +1,404 @@
+package margort
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var deadlock = &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}
+
+func TestSource(t *testing.T) {
+	if !strings.HasPrefix(Source, "package margort\n\n") {
+		t.Errorf("unexpected start of the source: %.40q", Source)
+	}
+}
+
+func TestCondSQL(t *testing.T) {
+	tests := []struct {
+		cond Cond
+		sql  string
+		args []any
+		err  string
+	}{
+		{Eq("f", 1), "c = ?", []any{1}, ""},
+		{Ne("f", 1), "c <> ?", []any{1}, ""},
+		{Lt("f", 1), "c < ?", []any{1}, ""},
+		{Le("f", 1), "c <= ?", []any{1}, ""},
+		{Gt("f", 1), "c > ?", []any{1}, ""},
+		{Ge("f", 1), "c >= ?", []any{1}, ""},
+		{Like("f", "a%"), "c LIKE ?", []any{"a%"}, ""},
+		{Cond{Field: "f", Op: "NOT LIKE", Value: "a%"}, "c NOT LIKE ?", []any{"a%"}, ""},
+		{Cond{Field: "f", Op: "<=>", Value: nil}, "c <=> ?", []any{nil}, ""},
+		{IsNull("f"), "c IS NULL", nil, ""},
+		{IsNotNull("f"), "c IS NOT NULL", nil, ""},
+		{In("f", 1), "c IN (?)", []any{1}, ""},
+		{In("f", 1, "b", 3), "c IN (?, ?, ?)", []any{1, "b", 3}, ""},
+		{NotIn("f", 1, 2), "c NOT IN (?, ?)", []any{1, 2}, ""},
+		{In("f"), "FALSE", nil, ""},
+		{NotIn("f"), "", nil, "NOT IN needs at least one value: f"},
+		{Cond{Field: "f", Op: "IN", Value: []int{1, 2}}, "", nil, "IN needs a []any value: f"},
+		{Cond{Field: "f", Op: "NOT IN", Value: 1}, "", nil, "NOT IN needs a []any value: f"},
+		{Cond{Field: "f", Op: "BETWEEN", Value: 1}, "", nil, "unsupported operator BETWEEN: f"},
+		{Cond{Field: "f", Op: "= 1 OR 1 =", Value: 1}, "", nil, "unsupported operator = 1 OR 1 =: f"},
+		{Cond{Field: "f"}, "", nil, "unsupported operator : f"},
+	}
+	for _, tt := range tests {
+		sql, args, err := tt.cond.SQL("c")
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%+v: got error %v, expected %q", tt.cond, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: %v", tt.cond, err)
+			continue
+		}
+		if sql != tt.sql || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%+v: got %q %v, expected %q %v", tt.cond, sql, args, tt.sql, tt.args)
+		}
+	}
+}
+
+func TestWhere(t *testing.T) {
+	column := func(field string) string {
+		if field == "id" || field == "name" {
+			return "users." + field
+		}
+		return ""
+	}
+	tests := []struct {
+		conds []Cond
+		where string
+		args  []any
+		err   string
+	}{
+		{nil, "", nil, "params.Conds must not be empty"},
+		{[]Cond{}, "", nil, "params.Conds must not be empty"},
+		{[]Cond{Eq("id", 1)}, "users.id = ?", []any{1}, ""},
+		{[]Cond{In("id", 1, 2), IsNull("name"), Like("name", "a%")},
+			"users.id IN (?, ?) AND users.name IS NULL AND users.name LIKE ?", []any{1, 2, "a%"}, ""},
+		{[]Cond{Eq("id", 1), Eq("email", "a")}, "", nil, "unknown field email"},
+		{[]Cond{Eq("id", 1), NotIn("name")}, "", nil, "NOT IN needs at least one value: name"},
+		{[]Cond{{Field: "id", Op: "~"}}, "", nil, "unsupported operator ~: id"},
+	}
+	for _, tt := range tests {
+		where, args, err := Where(tt.conds, column)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%v: got error %v, expected %q", tt.conds, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.conds, err)
+			continue
+		}
+		if where != tt.where || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%v: got %q %v, expected %q %v", tt.conds, where, args, tt.where, tt.args)
+		}
+	}
+}
+
+func TestNextTimestamp(t *testing.T) {
+	const layout = "2006-01-02 15:04:05"
+	tests := map[string]string{
+		"2999-01-01 00:00:00": "2999-01-01 00:00:01",
+		"2999-12-31 23:59:59": "3000-01-01 00:00:00",
+	}
+	for v, expected := range tests {
+		if got := NextTimestamp(v, layout, time.Second); got != expected {
+			t.Errorf("%s: got %s, expected %s", v, got, expected)
+		}
+	}
+	if got := NextTimestamp("2999-01-01 00:00:00.999999", layout+".000000", time.Microsecond); got != "2999-01-01 00:00:01.000000" {
+		t.Errorf("unexpected version: %s", got)
+	}
+
+	// two updates within the same second
+	v := NextTimestamp("", layout, time.Second)
+	if next := NextTimestamp(v, layout, time.Second); next <= v {
+		t.Errorf("%s is not past %s", next, v)
+	}
+	if past := NextTimestamp("2000-01-01 00:00:00", layout, time.Second); past[:4] != time.Now().UTC().Format("2006") {
+		t.Errorf("unexpected version after an old one: %s", past)
+	}
+}
+
+func TestRunTx(t *testing.T) {
+	db := openFake(t)
+	failed := errors.New("failed")
+	tests := []struct {
+		errs     []error // returned by the calls of fn
+		retries  int
+		err      error
+		expected []string
+	}{
+		{[]error{nil}, 3, nil, []string{"BEGIN", "COMMIT"}},
+		{[]error{failed}, 3, failed, []string{"BEGIN", "ROLLBACK"}},
+		{[]error{deadlock, &mysql.MySQLError{Number: 1205}, nil}, 3, nil, []string{"BEGIN", "ROLLBACK", "BEGIN", "ROLLBACK", "BEGIN", "COMMIT"}},
+		{[]error{deadlock, deadlock, deadlock}, 2, deadlock, []string{"BEGIN", "ROLLBACK", "BEGIN", "ROLLBACK", "BEGIN", "ROLLBACK"}},
+		{[]error{deadlock}, 0, deadlock, []string{"BEGIN", "ROLLBACK"}},
+	}
+	for i, tt := range tests {
+		fake.reset()
+		calls := 0
+		err := RunTx(context.Background(), db, nil, tt.retries, func(ctx context.Context, tx *sql.Tx) error {
+			if got, ok := TxFromContext(ctx); !ok || got != tx {
+				t.Errorf("%d: the context does not carry the transaction", i)
+			}
+			calls++
+			return tt.errs[calls-1]
+		})
+		if err != tt.err || calls != len(tt.errs) {
+			t.Errorf("%d: got %v after %d calls, expected %v after %d", i, err, calls, tt.err, len(tt.errs))
+		}
+		if log := fake.statements(); !reflect.DeepEqual(log, tt.expected) {
+			t.Errorf("%d: got %q, expected %q", i, log, tt.expected)
+		}
+	}
+
+	if err := RunTx(context.Background(), nil, nil, 3, func(context.Context, *sql.Tx) error { return nil }); err == nil {
+		t.Error("expected an error without db")
+	}
+}
+
+func TestRunTxSavepoint(t *testing.T) {
+	db := openFake(t)
+	rollback := []string{"BEGIN", "SAVEPOINT margo_sp_1 []", "ROLLBACK TO SAVEPOINT margo_sp_1 []", "COMMIT"}
+	tests := []struct {
+		err      error
+		expected []string
+	}{
+		{nil, []string{"BEGIN", "SAVEPOINT margo_sp_1 []", "RELEASE SAVEPOINT margo_sp_1 []", "COMMIT"}},
+		{errors.New("failed"), rollback},
+		// the lock wait timeout only rolled back its statement, the earlier work of the savepoint is still there
+		{&mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, rollback},
+		// the deadlock rolled back the whole transaction, the savepoint is gone
+		{deadlock, []string{"BEGIN", "SAVEPOINT margo_sp_1 []", "COMMIT"}},
+	}
+	for _, tt := range tests {
+		fake.reset()
+		err := RunTx(context.Background(), db, nil, 0, func(ctx context.Context, tx *sql.Tx) error {
+			if err := RunTx(ctx, db, nil, 0, func(context.Context, *sql.Tx) error { return tt.err }); err != tt.err {
+				t.Errorf("%v: unexpected error %v", tt.err, err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%v: %v", tt.err, err)
+		}
+		if log := fake.statements(); !reflect.DeepEqual(log, tt.expected) {
+			t.Errorf("%v: got %q, expected %q", tt.err, log, tt.expected)
+		}
+	}
+}
+
+func TestRunTxOtherDB(t *testing.T) {
+	db := openFake(t)
+	other, err := sql.Open("margort_fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	err = RunTx(context.Background(), db, nil, 0, func(ctx context.Context, tx *sql.Tx) error {
+		return RunTx(ctx, other, nil, 0, func(ctx context.Context, otx *sql.Tx) error {
+			if otx == tx {
+				t.Error("the transaction of another database was reused")
+			}
+			if got, ok := TxFor(ctx, db); !ok || got != tx {
+				t.Error("the transaction of db is lost")
+			}
+			if got, ok := TxFor(ctx, other); !ok || got != otx {
+				t.Error("the context does not carry the transaction of other")
+			}
+			// back on db, fn runs in a savepoint of its transaction
+			return RunTx(ctx, db, nil, 0, func(ctx context.Context, stx *sql.Tx) error {
+				if stx != tx {
+					t.Error("the savepoint is not part of the transaction of db")
+				}
+				if got, _ := TxFor(ctx, other); got != otx {
+					t.Error("the transaction of other is lost in the savepoint")
+				}
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"BEGIN", "BEGIN", "SAVEPOINT margo_sp_1 []", "RELEASE SAVEPOINT margo_sp_1 []", "COMMIT", "COMMIT"}
+	if log := fake.statements(); !reflect.DeepEqual(log, expected) {
+		t.Errorf("got %q, expected %q", log, expected)
+	}
+
+	// the database of a transaction put in the context is unknown, so every database uses it
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if got, ok := TxFor(ContextWithTx(context.Background(), tx), other); !ok || got != tx {
+		t.Error("the transaction of an unknown database is ignored")
+	}
+	if _, ok := TxFor(ContextWithDBTx(context.Background(), db, tx), other); ok {
+		t.Error("the transaction of db is used for other")
+	}
+}
+
+func TestRunTxPanic(t *testing.T) {
+	db := openFake(t)
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Errorf("unexpected panic %v", p)
+		}
+		if log := fake.statements(); !reflect.DeepEqual(log, []string{"BEGIN", "ROLLBACK"}) {
+			t.Errorf("unexpected statements %q", log)
+		}
+	}()
+	_ = RunTx(context.Background(), db, nil, 3, func(context.Context, *sql.Tx) error {
+		panic("boom")
+	})
+	t.Error("the panic was swallowed")
+}
+
+func TestHookContext(t *testing.T) {
+	db := openFake(t)
+	if HookContext(nil, db, nil) == nil {
+		t.Error("the hook context is nil")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	ctx := HookContext(context.Background(), db, tx)
+	if got, ok := TxFor(ctx, db); !ok || got != tx {
+		t.Error("the hook context does not carry the transaction")
+	}
+	if HookContext(ctx, db, tx) != ctx {
+		t.Error("the context already carrying the transaction was replaced")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err       error
+		class     error
+		retryable bool
+	}{
+		{&mysql.MySQLError{Number: 1062}, ErrDuplicateKey, false},
+		{&mysql.MySQLError{Number: 1169}, ErrDuplicateKey, false},
+		{&mysql.MySQLError{Number: 1586}, ErrDuplicateKey, false},
+		{&mysql.MySQLError{Number: 1216}, ErrForeignKeyViolation, false},
+		{&mysql.MySQLError{Number: 1217}, ErrForeignKeyViolation, false},
+		{&mysql.MySQLError{Number: 1451}, ErrForeignKeyViolation, false},
+		{&mysql.MySQLError{Number: 1452}, ErrForeignKeyViolation, false},
+		{&mysql.MySQLError{Number: 1213}, ErrDeadlock, true},
+		{&mysql.MySQLError{Number: 1205}, ErrLockTimeout, true},
+		{&mysql.MySQLError{Number: 1406}, ErrDataTooLong, false},
+		{&mysql.MySQLError{Number: 1927}, ErrConnectionLost, false},
+		{&mysql.MySQLError{Number: 2006}, ErrConnectionLost, false},
+		{&mysql.MySQLError{Number: 2013}, ErrConnectionLost, false},
+		{mysql.ErrInvalidConn, ErrConnectionLost, false},
+		{driver.ErrBadConn, ErrConnectionLost, false},
+		{&mysql.MySQLError{Number: 1146}, nil, false},
+		{errors.New("plain"), nil, false},
+		{nil, nil, false},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.err); got != tt.class {
+			t.Errorf("%v: got class %v, expected %v", tt.err, got, tt.class)
+		}
+		if got := IsRetryable(tt.err); got != tt.retryable {
+			t.Errorf("%v: got retryable %v", tt.err, got)
+		}
+		wrapped := WrapError(tt.err, "app.users", "DBInsert")
+		if tt.class != nil && !errors.Is(wrapped, tt.class) {
+			t.Errorf("%v: the wrapped error is not %v", tt.err, tt.class)
+		}
+		if tt.class != ErrDeadlock && errors.Is(wrapped, ErrDeadlock) {
+			t.Errorf("%v: the wrapped error is a deadlock", tt.err)
+		}
+	}
+
+	if n, ok := ErrorNumber(WrapError(&mysql.MySQLError{Number: 1452}, "t", "op")); !ok || n != 1452 {
+		t.Errorf("unexpected error number %d, %v", n, ok)
+	}
+	if _, ok := ErrorNumber(errors.New("plain")); ok {
+		t.Error("a plain error has an error number")
+	}
+}
+
+func TestDuplicateKeyIndex(t *testing.T) {
+	tests := map[error]string{
+		&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a@b.c' for key 'users_email'"}:       "users_email",
+		&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a@b.c' for key 'users.users_email'"}: "users_email",
+		&mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"}:               "PRIMARY",
+		&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}:                                     "",
+		&mysql.MySQLError{Number: 1452, Message: "for key 'fk'"}:                                        "",
+		errors.New("Duplicate entry 'a' for key 'users_email'"):                                         "",
+	}
+	for err, expected := range tests {
+		if got := DuplicateKeyIndex(err); got != expected {
+			t.Errorf("%v: got %q, expected %q", err, got, expected)
+		}
+	}
+}
+
+func TestWrapError(t *testing.T) {
+	if WrapError(nil, "app.users", "DBInsert") != nil {
+		t.Error("nil was wrapped")
+	}
+
+	cause := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a@b.c' for key 'users_email'"}
+	err := WrapError(cause, "app.users", "DBInsert")
+	var me *Error
+	if !errors.As(err, &me) || me.Table != "app.users" || me.Op != "DBInsert" || me.Index != "users_email" || me.Err != cause {
+		t.Fatalf("unexpected error %#v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "DBInsert app.users: duplicate key users_email: ") {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+	var cause2 *mysql.MySQLError
+	if !errors.As(err, &cause2) || cause2 != cause {
+		t.Error("the cause is not unwrapped")
+	}
+	if again := WrapError(err, "app.orders", "DBUpdate"); again != err {
+		t.Errorf("a wrapped error was wrapped again: %v", again)
+	}
+	if got := WrapError(errors.New("boom"), "", "QueryActive").Error(); got != "QueryActive: boom" {
+		t.Errorf("unexpected message %q", got)
+	}
+}
+
+// runs last, the rollback of the cancelled transaction may be recorded after RunTx returned
+func TestRunTxCancelledBackoff(t *testing.T) {
+	db := openFake(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calls := 0
+	start := time.Now()
+	err := RunTx(ctx, db, nil, 10, func(context.Context, *sql.Tx) error {
+		calls++
+		cancel()
+		return deadlock
+	})
+	if err != deadlock || calls != 1 {
+		t.Errorf("got %v after %d calls", err, calls)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("RunTx waited %s after the context was cancelled", elapsed)
+	}
+}
@@ -0,0 +1,10 @@
+// Package margort holds the helpers shared by the generated packages. margo writes a copy of margort.go below the
+// output path, so that the generated code does not depend on margo.
+package margort
+
+import _ "embed"
+
+// Source is the content of margort.go.
+//
+//go:embed margort.go
+var Source string
@@ -0,0 +1,110 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rah-0/nabu"
+)
+
+// Migration files are named NNNN_name.up.sql and NNNN_name.down.sql, the version orders them.
+var fileRegex = regexp.MustCompile(`^(\d+)_([^.]+)\.(up|down)\.sql$`)
+
+var nameCleanRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+const (
+	DirectionUp   = "up"
+	DirectionDown = "down"
+)
+
+type File struct {
+	Version  int
+	Name     string
+	UpPath   string
+	DownPath string // empty when the migration can't be reverted
+}
+
+// List returns the migrations in dir ordered by version.
+func List(dir string) ([]File, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, nabu.FromError(err).WithArgs(dir).Log()
+	}
+
+	byVersion := map[int]*File{}
+	for _, e := range entries {
+		m := fileRegex.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(m[1])
+		f, ok := byVersion[version]
+		if !ok {
+			f = &File{Version: version, Name: m[2]}
+			byVersion[version] = f
+		}
+		if f.Name != m[2] {
+			return nil, nabu.FromError(fmt.Errorf("version %d is used by %q and %q", version, f.Name, m[2])).WithArgs(dir).Log()
+		}
+		if m[3] == DirectionUp {
+			f.UpPath = filepath.Join(dir, e.Name())
+		} else {
+			f.DownPath = filepath.Join(dir, e.Name())
+		}
+	}
+
+	files := make([]File, 0, len(byVersion))
+	for _, f := range byVersion {
+		if f.UpPath == "" {
+			return nil, nabu.FromError(fmt.Errorf("migration %d_%s has no up file", f.Version, f.Name)).WithArgs(dir).Log()
+		}
+		files = append(files, *f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Version < files[j].Version })
+
+	return files, nil
+}
+
+// Write stores a new migration after the last one in dir. down may be empty for migrations that can't be reverted.
+func Write(dir, name, up, down string) (File, error) {
+	name = strings.Trim(nameCleanRegex.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return File{}, nabu.FromError(errors.New("migration name is empty")).Log()
+	}
+
+	files, err := List(dir)
+	if err != nil {
+		return File{}, nabu.FromError(err).WithArgs(dir).Log()
+	}
+	f := File{Version: 1, Name: name}
+	if len(files) > 0 {
+		f.Version = files[len(files)-1].Version + 1
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return File{}, nabu.FromError(err).WithArgs(dir).Log()
+	}
+
+	base := fmt.Sprintf("%04d_%s", f.Version, name)
+	f.UpPath = filepath.Join(dir, base+"."+DirectionUp+".sql")
+	if err = os.WriteFile(f.UpPath, []byte(up), 0644); err != nil {
+		return File{}, nabu.FromError(err).WithArgs(f.UpPath).Log()
+	}
+	if down != "" {
+		f.DownPath = filepath.Join(dir, base+"."+DirectionDown+".sql")
+		if err = os.WriteFile(f.DownPath, []byte(down), 0644); err != nil {
+			return File{}, nabu.FromError(err).WithArgs(f.DownPath).Log()
+		}
+	}
+
+	return f, nil
+}
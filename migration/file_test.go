@@ -0,0 +1,62 @@
+package migration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteAndList(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "migrations")
+
+	first, err := Write(dir, "Create users", "CREATE TABLE users (id int);\n", "DROP TABLE users;\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(first.UpPath) != "0001_create_users.up.sql" || filepath.Base(first.DownPath) != "0001_create_users.down.sql" {
+		t.Errorf("unexpected paths: %s, %s", first.UpPath, first.DownPath)
+	}
+
+	// unrelated files are ignored and numbering continues after the highest version
+	if err = os.WriteFile(filepath.Join(dir, "README.md"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "0009_manual.up.sql"), []byte("SELECT 1;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	second, err := Write(dir, "add-email", "ALTER TABLE users ADD email text;\n", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Version != 10 || second.DownPath != "" {
+		t.Errorf("unexpected migration: %+v", second)
+	}
+
+	files, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || files[0].Version != 1 || files[1].Name != "manual" || files[2].Name != "add_email" {
+		t.Errorf("unexpected files: %+v", files)
+	}
+}
+
+func TestListErrors(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "0001_a.down.sql"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := List(dir); err == nil {
+		t.Error("expected error for a migration without up file, got nil")
+	}
+
+	dir = t.TempDir()
+	for _, n := range []string{"0001_a.up.sql", "0001_b.up.sql"} {
+		if err := os.WriteFile(filepath.Join(dir, n), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := List(dir); err == nil {
+		t.Error("expected error for a duplicated version, got nil")
+	}
+}
@@ -0,0 +1,271 @@
+package migration
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rah-0/nabu"
+
+	"github.com/rah-0/margo/conf"
+)
+
+// LockTimeout is how long a run waits for another deploy to release the migration lock.
+const LockTimeout = 60 * time.Second
+
+const (
+	StatusApplied  = "applied"
+	StatusPending  = "pending"
+	StatusModified = "modified" // applied, but the up file changed since
+	StatusMissing  = "missing"  // applied, but the file is gone
+)
+
+type State struct {
+	Version   int
+	Name      string
+	Status    string
+	AppliedAt string
+}
+
+type applied struct {
+	version   int
+	name      string
+	checksum  string
+	appliedAt string
+}
+
+func Checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Up applies pending migrations in order, all of them when steps is 0. It returns the applied migrations.
+func Up(c *sql.DB, dir string, steps int) ([]File, error) {
+	var done []File
+	err := withLock(c, func(conn *sql.Conn) error {
+		files, records, err := load(conn, dir)
+		if err != nil {
+			return err
+		}
+
+		// never build on top of history that was edited after it ran
+		for _, f := range files {
+			r, ok := records[f.Version]
+			if !ok {
+				continue
+			}
+			content, err := os.ReadFile(f.UpPath)
+			if err != nil {
+				return nabu.FromError(err).WithArgs(f.UpPath).Log()
+			}
+			if Checksum(content) != r.checksum {
+				return nabu.FromError(fmt.Errorf("migration %04d_%s was modified after it was applied", f.Version, f.Name)).Log()
+			}
+		}
+
+		for _, f := range files {
+			if _, ok := records[f.Version]; ok {
+				continue
+			}
+			if steps > 0 && len(done) == steps {
+				break
+			}
+
+			content, err := os.ReadFile(f.UpPath)
+			if err != nil {
+				return nabu.FromError(err).WithArgs(f.UpPath).Log()
+			}
+			if err = execScript(conn, f, string(content)); err != nil {
+				return err
+			}
+			_, err = conn.ExecContext(context.Background(),
+				"INSERT INTO "+quote(conf.Args.MigrationsTable)+" (version, name, checksum) VALUES (?, ?, ?)",
+				f.Version, f.Name, Checksum(content))
+			if err != nil {
+				return nabu.FromError(err).WithArgs(f.UpPath).Log()
+			}
+			done = append(done, f)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Down reverts the last applied migrations, one when steps is 0. It returns the reverted migrations.
+func Down(c *sql.DB, dir string, steps int) ([]File, error) {
+	if steps <= 0 {
+		steps = 1
+	}
+
+	var done []File
+	err := withLock(c, func(conn *sql.Conn) error {
+		files, records, err := load(conn, dir)
+		if err != nil {
+			return err
+		}
+		byVersion := map[int]File{}
+		for _, f := range files {
+			byVersion[f.Version] = f
+		}
+
+		versions := make([]int, 0, len(records))
+		for v := range records {
+			versions = append(versions, v)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+		for _, v := range versions {
+			if len(done) == steps {
+				break
+			}
+			f, ok := byVersion[v]
+			if !ok || f.DownPath == "" {
+				return nabu.FromError(fmt.Errorf("migration %04d_%s has no down file", v, records[v].name)).Log()
+			}
+
+			content, err := os.ReadFile(f.DownPath)
+			if err != nil {
+				return nabu.FromError(err).WithArgs(f.DownPath).Log()
+			}
+			if err = execScript(conn, f, string(content)); err != nil {
+				return err
+			}
+			_, err = conn.ExecContext(context.Background(), "DELETE FROM "+quote(conf.Args.MigrationsTable)+" WHERE version = ?", v)
+			if err != nil {
+				return nabu.FromError(err).WithArgs(f.DownPath).Log()
+			}
+			done = append(done, f)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Status lists every known migration, from the directory and from the bookkeeping table, ordered by version. It only
+// reads, so it does not wait for the migration lock.
+func Status(c *sql.DB, dir string) ([]State, error) {
+	ctx := context.Background()
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return nil, nabu.FromError(err).Log()
+	}
+	defer conn.Close()
+
+	files, records, err := load(conn, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var states []State
+	for _, f := range files {
+		s := State{Version: f.Version, Name: f.Name, Status: StatusPending}
+		if r, ok := records[f.Version]; ok {
+			content, err := os.ReadFile(f.UpPath)
+			if err != nil {
+				return nil, nabu.FromError(err).WithArgs(f.UpPath).Log()
+			}
+			s.Status = StatusApplied
+			if Checksum(content) != r.checksum {
+				s.Status = StatusModified
+			}
+			s.AppliedAt = r.appliedAt
+			delete(records, f.Version)
+		}
+		states = append(states, s)
+	}
+	for _, r := range records {
+		states = append(states, State{Version: r.version, Name: r.name, Status: StatusMissing, AppliedAt: r.appliedAt})
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states, nil
+}
+
+// withLock runs fn on a single connection holding a named lock, so concurrent deploys apply migrations one at a time.
+func withLock(c *sql.DB, fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return nabu.FromError(err).Log()
+	}
+	defer conn.Close()
+
+	lockName := "margo_migrate." + conf.Args.DBName
+	var got sql.NullInt64
+	if err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, int(LockTimeout.Seconds())).Scan(&got); err != nil {
+		return nabu.FromError(err).WithArgs(lockName).Log()
+	}
+	if got.Int64 != 1 {
+		return nabu.FromError(errors.New("timed out waiting for the migration lock, another migration is running")).WithArgs(lockName).Log()
+	}
+	defer conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", lockName)
+
+	return fn(conn)
+}
+
+func load(conn *sql.Conn, dir string) ([]File, map[int]applied, error) {
+	files, err := List(dir)
+	if err != nil {
+		return nil, nil, nabu.FromError(err).WithArgs(dir).Log()
+	}
+
+	ctx := context.Background()
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+quote(conf.Args.MigrationsTable)+` (
+		version INT NOT NULL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		applied_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+	)`)
+	if err != nil {
+		return nil, nil, nabu.FromError(err).WithArgs(conf.Args.MigrationsTable).Log()
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM "+quote(conf.Args.MigrationsTable))
+	if err != nil {
+		return nil, nil, nabu.FromError(err).WithArgs(conf.Args.MigrationsTable).Log()
+	}
+	defer rows.Close()
+
+	records := map[int]applied{}
+	for rows.Next() {
+		var r applied
+		if err = rows.Scan(&r.version, &r.name, &r.checksum, &r.appliedAt); err != nil {
+			return nil, nil, nabu.FromError(err).Log()
+		}
+		records[r.version] = r
+	}
+
+	return files, records, rows.Err()
+}
+
+// execScript runs the statements of a migration one by one. MariaDB commits DDL implicitly, so a failure can leave a
+// migration half applied; the error names the statement to fix by hand.
+func execScript(conn *sql.Conn, f File, script string) error {
+	stmts, err := SplitStatements(script)
+	if err != nil {
+		return nabu.FromError(err).WithArgs(f.Version, f.Name).Log()
+	}
+
+	for i, stmt := range stmts {
+		if _, err = conn.ExecContext(context.Background(), stmt); err != nil {
+			return nabu.FromError(fmt.Errorf("migration %04d_%s, statement %d of %d: %w", f.Version, f.Name, i+1, len(stmts), err)).Log()
+		}
+	}
+
+	return nil
+}
+
+func quote(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
@@ -0,0 +1,109 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SplitStatements splits a script on semicolons outside of quotes and comments. Comments are kept with the statement
+// that follows them, empty statements and statements of only comments are dropped. The DELIMITER command of the mysql
+// client is not SQL and is rejected.
+func SplitStatements(script string) ([]string, error) {
+	var stmts []string
+	start := 0
+
+	add := func(end int) {
+		if stmt := strings.TrimSpace(script[start:end]); stmt != "" && !onlyComments(stmt) {
+			stmts = append(stmts, stmt)
+		}
+	}
+
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			end := closingQuote(script, i)
+			if end < 0 {
+				return nil, errors.New("unterminated quote in migration")
+			}
+			i = end
+		case (c == 'D' || c == 'd') && delimiterAt(script, i) && onlyComments(script[start:i]):
+			return nil, fmt.Errorf("DELIMITER on line %d is a mysql client command, not supported in migrations", strings.Count(script[:i], "\n")+1)
+		case dashCommentAt(script, i), c == '#':
+			if end := strings.IndexByte(script[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(script)
+			}
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				return nil, errors.New("unterminated comment in migration")
+			}
+			i += end + 3
+		case c == ';':
+			add(i)
+			start = i + 1
+		}
+	}
+	add(len(script))
+
+	return stmts, nil
+}
+
+// closingQuote returns the index of the quote closing the one at i, doubled quotes and backslashes escape.
+func closingQuote(s string, i int) int {
+	q := s[i]
+	for j := i + 1; j < len(s); j++ {
+		switch {
+		case s[j] == '\\' && q != '`':
+			j++
+		case s[j] == q && j+1 < len(s) && s[j+1] == q:
+			j++
+		case s[j] == q:
+			return j
+		}
+	}
+	return -1
+}
+
+// dashCommentAt reports whether a -- comment starts at i: MariaDB needs whitespace or the end of input after the dashes.
+func dashCommentAt(s string, i int) bool {
+	if !strings.HasPrefix(s[i:], "--") {
+		return false
+	}
+	return i+2 == len(s) || strings.IndexByte(" \t\r\n", s[i+2]) >= 0
+}
+
+// delimiterAt reports whether the word at i is DELIMITER.
+func delimiterAt(s string, i int) bool {
+	const word = "DELIMITER"
+	if len(s) <= i+len(word) || !strings.EqualFold(s[i:i+len(word)], word) {
+		return false
+	}
+	return strings.IndexByte(" \t", s[i+len(word)]) >= 0
+}
+
+// onlyComments reports whether stmt holds nothing but whitespace and comments. The executable comments /*! */ and
+// /*M! */ run on MariaDB and count as SQL.
+func onlyComments(stmt string) bool {
+	for i := 0; i < len(stmt); i++ {
+		switch c := stmt[i]; {
+		case dashCommentAt(stmt, i), c == '#':
+			end := strings.IndexByte(stmt[i:], '\n')
+			if end < 0 {
+				return true
+			}
+			i += end
+		case c == '/' && strings.HasPrefix(stmt[i:], "/*") && !strings.HasPrefix(stmt[i:], "/*!") && !strings.HasPrefix(stmt[i:], "/*M!"):
+			end := strings.Index(stmt[i+2:], "*/")
+			if end < 0 {
+				return true
+			}
+			i += end + 3
+		case strings.IndexByte(" \t\r\n", c) < 0:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package migration
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	script := "" +
+		"-- /tmp/a.json -> /tmp/b.sql\n" +
+		"-- WARNING: drops table gamma\n" +
+		"\n" +
+		"ALTER TABLE `alpha`\n" +
+		"  MODIFY COLUMN `name` varchar(20) NOT NULL DEFAULT 'a;b';\n" +
+		"\n" +
+		"INSERT INTO `x;y` VALUES ('it''s; fine', \"q\\\";\", 1); # trailing; comment\n" +
+		"/* block; comment */ DROP TABLE `gamma`;\n" +
+		";\n" +
+		"SELECT 5--1;\n" +
+		"--\ttab; comment\n" +
+		"/* only; a block */ /* comment */;\n" +
+		"/*!40101 SET NAMES utf8mb4 */;\n" +
+		"-- only a comment\n" +
+		"--"
+
+	expected := []string{
+		"-- /tmp/a.json -> /tmp/b.sql\n-- WARNING: drops table gamma\n\nALTER TABLE `alpha`\n  MODIFY COLUMN `name` varchar(20) NOT NULL DEFAULT 'a;b'",
+		"INSERT INTO `x;y` VALUES ('it''s; fine', \"q\\\";\", 1)",
+		"# trailing; comment\n/* block; comment */ DROP TABLE `gamma`",
+		"SELECT 5--1",
+		"/*!40101 SET NAMES utf8mb4 */",
+	}
+
+	got, err := SplitStatements(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected:\n%q\ngot:\n%q", expected, got)
+	}
+}
+
+func TestSplitStatementsErrors(t *testing.T) {
+	for _, script := range []string{"SELECT 'a;", "SELECT 1; /* open", "SELECT 1;\n-- procedure\ndelimiter //\nSELECT 2//"} {
+		if _, err := SplitStatements(script); err == nil {
+			t.Errorf("expected error for %q, got nil", script)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package naming
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/fatih/camelcase"
+	"github.com/rah-0/nabu"
+
+	"github.com/rah-0/margo/conf"
+	"github.com/rah-0/margo/db"
+	"github.com/rah-0/margo/util"
+)
+
+const (
+	StrategyLegacy = "legacy"
+	StrategyGo     = "go"
+
+	DefaultEntityName = "Entity"
+)
+
+// DefaultInitialisms is the list of initialisms golint expects to be fully uppercased.
+var DefaultInitialisms = []string{
+	"ACL", "API", "ASCII", "CPU", "CSS", "DNS", "EOF", "GUID", "HTML", "HTTP", "HTTPS",
+	"ID", "IP", "JSON", "LHS", "QPS", "RAM", "RHS", "RPC", "SLA", "SMTP", "SQL", "SSH",
+	"TCP", "TLS", "TTL", "UDP", "UI", "UID", "UUID", "URI", "URL", "UTF8", "VM", "XML",
+	"XMPP", "XSRF", "XSS",
+}
+
+// Strategy turns a raw database identifier into an exported Go identifier.
+type Strategy interface {
+	Normalize(raw string) string
+}
+
+// Legacy keeps the original db.NormalizeString behaviour (user_id -> UserId).
+type Legacy struct{}
+
+func (Legacy) Normalize(raw string) string {
+	return db.NormalizeString(raw)
+}
+
+// Go follows the Go naming conventions, uppercasing known initialisms (user_id -> UserID).
+type Go struct {
+	Initialisms map[string]bool
+}
+
+func NewGo(extra []string) Go {
+	g := Go{Initialisms: make(map[string]bool)}
+	for _, i := range DefaultInitialisms {
+		g.Initialisms[i] = true
+	}
+	for _, i := range extra {
+		i = strings.ToUpper(strings.TrimSpace(i))
+		if i != "" {
+			g.Initialisms[i] = true
+		}
+	}
+	return g
+}
+
+func (g Go) Normalize(raw string) string {
+	for _, sep := range []string{"_", "-", ".", " "} {
+		raw = strings.ReplaceAll(raw, sep, " ")
+	}
+
+	var out strings.Builder
+	for _, part := range strings.Fields(raw) {
+		for _, w := range splitWords(part) {
+			if g.Initialisms[strings.ToUpper(w)] {
+				out.WriteString(strings.ToUpper(w))
+			} else {
+				out.WriteString(util.Capitalize(w))
+			}
+		}
+	}
+	return out.String()
+}
+
+// splitWords splits mixed case words (userID -> user, ID) while keeping
+// single-case words (user2fa, HTTP) intact. Digit runs stick to the previous word.
+func splitWords(part string) []string {
+	if part == strings.ToLower(part) || part == strings.ToUpper(part) {
+		return []string{part}
+	}
+
+	var words []string
+	for _, w := range camelcase.Split(part) {
+		if len(words) > 0 && isDigits(w) {
+			words[len(words)-1] += w
+			continue
+		}
+		words = append(words, w)
+	}
+	return words
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Overrides pins the Go name of specific tables and columns.
+// Field keys are either "table.column" or just "column" to match every table.
+type Overrides struct {
+	Packages map[string]string `json:"packages,omitempty"`
+	Entities map[string]string `json:"entities,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty"`
+}
+
+var (
+	strategy  Strategy = Legacy{}
+	overrides          = Overrides{}
+	singular           = false
+)
+
+func SetStrategy(s Strategy) {
+	strategy = s
+}
+
+func SetOverrides(o Overrides) {
+	overrides = o
+}
+
+func SetSingularEntity(enabled bool) {
+	singular = enabled
+}
+
+// Load configures the naming strategy from conf.Args.
+func Load() error {
+	switch conf.Args.Naming {
+	case "", StrategyLegacy:
+		SetStrategy(Legacy{})
+	case StrategyGo:
+		SetStrategy(NewGo(conf.Args.Initialisms))
+	default:
+		return nabu.FromError(errors.New("unknown naming strategy")).WithArgs(conf.Args.Naming).Log()
+	}
+
+	o := Overrides{}
+	if conf.Args.NamingOverridesPath != "" {
+		content, err := util.ReadFileAsString(conf.Args.NamingOverridesPath)
+		if err != nil {
+			return nabu.FromError(err).WithArgs(conf.Args.NamingOverridesPath).Log()
+		}
+		if err = json.Unmarshal([]byte(content), &o); err != nil {
+			return nabu.FromError(err).WithArgs(conf.Args.NamingOverridesPath).Log()
+		}
+	}
+	SetOverrides(o)
+	SetSingularEntity(conf.Args.SingularEntity)
+
+	return nil
+}
+
+// Package returns the Go package (and directory) name for a table or database.
+func Package(rawName string) string {
+	if v, ok := overrides.Packages[rawName]; ok {
+		return v
+	}
+	return strategy.Normalize(rawName)
+}
+
+// Entity returns the struct name generated for a table.
+func Entity(rawTableName string) string {
+	if v, ok := overrides.Entities[rawTableName]; ok {
+		return v
+	}
+	if !singular {
+		return DefaultEntityName
+	}
+	return Singularize(strategy.Normalize(rawTableName))
+}
+
+// Field returns the struct field name for a column. An empty table name only matches column-wide overrides.
+func Field(rawTableName, rawColumnName string) string {
+	if v, ok := overrides.Fields[rawTableName+"."+rawColumnName]; ok && rawTableName != "" {
+		return v
+	}
+	if v, ok := overrides.Fields[rawColumnName]; ok {
+		return v
+	}
+	return strategy.Normalize(rawColumnName)
+}
+
+// Reference returns the method generated on Entity to load the row a foreign key points at, DBRef followed by the
+// field names of its columns.
+func Reference(rawTableName string, columns []string) string {
+	name := "DBRef"
+	for _, c := range columns {
+		name += Field(rawTableName, c)
+	}
+	return name
+}
+
+// ResolveFields returns a copy of tfs with GoName set, failing when two columns end up with the same name.
+func ResolveFields(rawTableName string, tfs []conf.TableField) ([]conf.TableField, error) {
+	out := make([]conf.TableField, len(tfs))
+	seen := make(map[string]string, len(tfs))
+	for i, tf := range tfs {
+		tf.GoName = Field(rawTableName, tf.Name)
+		if prev, ok := seen[tf.GoName]; ok {
+			err := fmt.Errorf("columns %q and %q of table %q both map to Go field %q", prev, tf.Name, rawTableName, tf.GoName)
+			return nil, nabu.FromError(err).Log()
+		}
+		seen[tf.GoName] = tf.Name
+		out[i] = tf
+	}
+	return out, nil
+}
+
+// Singularize applies simple English plural rules to the last word of a Go identifier.
+func Singularize(s string) string {
+	lower := strings.ToLower(s)
+	switch {
+	case strings.HasSuffix(lower, "ies") && len(s) > 3:
+		return s[:len(s)-3] + "y"
+	case strings.HasSuffix(lower, "sses"), strings.HasSuffix(lower, "xes"),
+		strings.HasSuffix(lower, "ches"), strings.HasSuffix(lower, "shes"):
+		return s[:len(s)-2]
+	case strings.HasSuffix(lower, "ss"), strings.HasSuffix(lower, "us"), strings.HasSuffix(lower, "is"):
+		return s
+	case strings.HasSuffix(lower, "s") && len(s) > 1:
+		return s[:len(s)-1]
+	}
+	return s
+}
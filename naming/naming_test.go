@@ -0,0 +1,117 @@
+package naming
+
+import (
+	"testing"
+
+	"github.com/rah-0/margo/conf"
+)
+
+func TestGoNormalize(t *testing.T) {
+	g := NewGo([]string{"sku"})
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"user_id", "UserID"},
+		{"uuid_field", "UUIDField"},
+		{"userID", "UserID"},
+		{"userId", "UserID"},
+		{"api_url", "APIURL"},
+		{"json_payload", "JSONPayload"},
+		{"HTTPConnection", "HTTPConnection"},
+		{"test_field", "TestField"},
+		{"TestField", "TestField"},
+		{"user2fa_status", "User2faStatus"},
+		{"ip_address_v4", "IPAddressV4"},
+		{"product_sku", "ProductSKU"},
+		{"Uuid", "UUID"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		result := g.Normalize(tt.input)
+		if result != tt.expected {
+			t.Errorf("Go.Normalize(%q) = %q; want %q", tt.input, result, tt.expected)
+		}
+	}
+}
+
+func TestLegacyNormalize(t *testing.T) {
+	if got := (Legacy{}).Normalize("user_id"); got != "UserId" {
+		t.Errorf("Legacy.Normalize(%q) = %q; want %q", "user_id", got, "UserId")
+	}
+}
+
+func TestSingularize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Users", "User"},
+		{"Categories", "Category"},
+		{"Addresses", "Address"},
+		{"Boxes", "Box"},
+		{"Batches", "Batch"},
+		{"Status", "Status"},
+		{"Class", "Class"},
+		{"Alpha", "Alpha"},
+		{"UserAccounts", "UserAccount"},
+	}
+
+	for _, tt := range tests {
+		result := Singularize(tt.input)
+		if result != tt.expected {
+			t.Errorf("Singularize(%q) = %q; want %q", tt.input, result, tt.expected)
+		}
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	SetStrategy(NewGo(nil))
+	SetOverrides(Overrides{
+		Packages: map[string]string{"user_accounts": "Accounts"},
+		Entities: map[string]string{"user_accounts": "Account"},
+		Fields:   map[string]string{"user_accounts.uid": "OwnerID", "uuid": "Key"},
+	})
+	SetSingularEntity(true)
+	defer func() {
+		SetStrategy(Legacy{})
+		SetOverrides(Overrides{})
+		SetSingularEntity(false)
+	}()
+
+	if got := Package("user_accounts"); got != "Accounts" {
+		t.Errorf("Package = %q; want %q", got, "Accounts")
+	}
+	if got := Entity("user_accounts"); got != "Account" {
+		t.Errorf("Entity = %q; want %q", got, "Account")
+	}
+	if got := Entity("users"); got != "User" {
+		t.Errorf("Entity = %q; want %q", got, "User")
+	}
+	if got := Field("user_accounts", "uid"); got != "OwnerID" {
+		t.Errorf("Field = %q; want %q", got, "OwnerID")
+	}
+	if got := Field("other", "uid"); got != "UID" {
+		t.Errorf("Field = %q; want %q", got, "UID")
+	}
+	if got := Field("other", "uuid"); got != "Key" {
+		t.Errorf("Field = %q; want %q", got, "Key")
+	}
+}
+
+func TestResolveFieldsCollision(t *testing.T) {
+	tfs := []conf.TableField{{Name: "test_field"}, {Name: "TestField"}}
+	if _, err := ResolveFields("alpha", tfs); err == nil {
+		t.Error("expected collision error, got nil")
+	}
+
+	tfs = []conf.TableField{{Name: "uuid"}, {Name: "test_field"}}
+	resolved, err := ResolveFields("alpha", tfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved[1].GoName != "TestField" {
+		t.Errorf("GoName = %q; want %q", resolved[1].GoName, "TestField")
+	}
+}
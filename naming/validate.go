@@ -0,0 +1,242 @@
+package naming
+
+import (
+	"go/token"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/rah-0/nabu"
+
+	"github.com/rah-0/margo/conf"
+)
+
+const (
+	OnInvalidFail   = "fail"
+	OnInvalidEscape = "escape"
+)
+
+// reservedPackageNames are identifiers declared in the generated database package (queries.go),
+// which refers to every table package by name.
+var reservedPackageNames = map[string]bool{
+	"SetDB": true, "SetDBSchema": true, "WithSchema": true, "NewTx": true, "NewCtxTx": true, "NewTxOpts": true,
+	"NewCtxTxOpts": true, "NamedQuery": true, "QueryParams": true, "NewQueryParams": true, "margort": true,
+	"Repo": true, "NewRepo": true, "SetReplicaPicker": true, "WithTx": true, "WithTxContext": true,
+	"SetTxRetries": true,
+}
+
+// reservedEntityNames are identifiers declared at package level in every entity.go.
+var reservedEntityNames = map[string]bool{
+	"FQTN": true, "SchemaName": true, "TableName": true, "Fields": true, "QueryParams": true, "NewQueryParams": true,
+	"QueryResult": true, "NamedQuery": true, "SetDB": true, "SetDBSchema": true, "GetValuePlaceholder": true,
+	"GetValuesPlaceholders": true, "GetQualifiedField": true, "GetQualifiedFields": true, "GetQualifiedPlaceholder": true,
+	"GetQualifiedPlaceholders": true, "DBTruncate": true, "DBSelectAll": true, "Repo": true, "NewRepo": true,
+	"NewRepoSchema": true, "SetReplicaPicker": true, "DBDeleteWhere": true, "DBUpdateWhere": true,
+}
+
+// reservedFieldNames are the methods generated on Entity and the hooks of margort it may implement.
+var reservedFieldNames = map[string]bool{
+	"GetFieldValue": true, "GetFieldsValues": true, "BeforeInsert": true, "AfterInsert": true, "BeforeUpdate": true,
+	"AfterUpdate": true, "BeforeDelete": true, "AfterDelete": true,
+}
+
+func init() {
+	for _, op := range []string{"DBInsert", "DBDelete", "DBHardDelete", "DBRestore", "DBUpdate", "DBSelect", "DBExists"} {
+		for _, suffix := range []string{"", "Ctx", "Tx", "CtxTx"} {
+			reservedFieldNames[op+suffix] = true
+		}
+	}
+	for _, op := range []string{"DBTruncate", "DBSelectAll", "DBDeleteWhere", "DBUpdateWhere"} {
+		for _, suffix := range []string{"Ctx", "Tx", "CtxTx"} {
+			reservedEntityNames[op+suffix] = true
+		}
+	}
+}
+
+// Problem describes a generated identifier that is invalid or clashes with another one.
+type Problem struct {
+	Table   string
+	Column  string // empty for package and entity names
+	Kind    string // package, entity or field
+	Name    string
+	Reason  string
+	Escaped string // set when -onInvalidName=escape renamed the identifier
+}
+
+func (p Problem) String() string {
+	s := "table " + strconv.Quote(p.Table)
+	if p.Column != "" {
+		s += " column " + strconv.Quote(p.Column)
+	}
+	s += ": " + p.Kind + " " + strconv.Quote(p.Name) + " " + p.Reason
+	if p.Escaped != "" {
+		s += ", renamed to " + strconv.Quote(p.Escaped)
+	}
+	return s
+}
+
+// Report collects every Problem found by Validate.
+type Report struct {
+	Problems []Problem
+}
+
+func (r *Report) Error() string {
+	lines := []string{"invalid Go identifiers (use -onInvalidName=escape to rename them):"}
+	for _, p := range r.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate checks the package, entity and field names generated for tables before anything is written.
+// With -onInvalidName=escape every problem is resolved deterministically and registered as an override,
+// otherwise a *Report listing all problems is returned.
+func Validate(tables []conf.Table) (*Report, error) {
+	escape := conf.Args.OnInvalidName == OnInvalidEscape
+	report := &Report{}
+	packages := make(map[string]bool, len(tables))
+
+	for _, t := range tables {
+		pkg := Package(t.Name)
+		reason := checkIdentifier(pkg, false)
+		if reason == "" && (token.IsKeyword(strings.ToLower(pkg)) || reservedPackageNames[pkg]) {
+			reason = "is a reserved word"
+		}
+		if reason == "" && packages[strings.ToLower(pkg)] {
+			reason = "is already used by another table"
+		}
+		if reason != "" {
+			p := Problem{Table: t.Name, Kind: "package", Name: pkg, Reason: reason}
+			if escape {
+				pkg = unique(escapeIdentifier(pkg, reservedPackageNames, true), packages, true)
+				p.Escaped = pkg
+				setOverride(&overrides.Packages, t.Name, pkg)
+			}
+			report.Problems = append(report.Problems, p)
+		}
+		packages[strings.ToLower(pkg)] = true
+
+		entity := Entity(t.Name)
+		if reason = checkIdentifier(entity, true); reason == "" && reservedEntityNames[entity] {
+			reason = "clashes with a generated identifier"
+		}
+		if reason != "" {
+			p := Problem{Table: t.Name, Kind: "entity", Name: entity, Reason: reason}
+			if escape {
+				entity = escapeIdentifier(entity, reservedEntityNames, false)
+				p.Escaped = entity
+				setOverride(&overrides.Entities, t.Name, entity)
+			}
+			report.Problems = append(report.Problems, p)
+		}
+
+		// methods following the foreign keys of this table
+		references := make(map[string]bool, len(t.ForeignKeys)*4)
+		for _, fk := range t.ForeignKeys {
+			for _, suffix := range []string{"", "Ctx", "Tx", "CtxTx"} {
+				references[Reference(t.Name, fk.Columns)+suffix] = true
+			}
+		}
+
+		fields := make(map[string]bool, len(t.Fields))
+		for _, tf := range t.Fields {
+			field := Field(t.Name, tf.Name)
+			reason = checkIdentifier(field, true)
+			if reason == "" && (reservedFieldNames[field] || references[field]) {
+				reason = "clashes with a generated method"
+			}
+			if reason == "" && fields[field] {
+				reason = "is already used by another column"
+			}
+			if reason != "" {
+				p := Problem{Table: t.Name, Column: tf.Name, Kind: "field", Name: field, Reason: reason}
+				if escape {
+					field = escapeIdentifier(field, reservedFieldNames, false)
+					if references[field] {
+						field += "_"
+					}
+					field = unique(field, fields, false)
+					p.Escaped = field
+					setOverride(&overrides.Fields, t.Name+"."+tf.Name, field)
+				}
+				report.Problems = append(report.Problems, p)
+			}
+			fields[field] = true
+		}
+	}
+
+	if len(report.Problems) > 0 && !escape {
+		return report, nabu.FromError(report).Log()
+	}
+	return report, nil
+}
+
+// checkIdentifier returns why name is not a valid Go identifier, or an empty string.
+func checkIdentifier(name string, exported bool) string {
+	if name == "" {
+		return "is empty"
+	}
+	for i, r := range name {
+		if i == 0 && !unicode.IsLetter(r) {
+			return "does not start with a letter"
+		}
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return "contains invalid character " + strconv.QuoteRune(r)
+		}
+	}
+	if exported && !token.IsExported(name) {
+		return "is not exported"
+	}
+	return ""
+}
+
+// escapeIdentifier drops invalid characters, prefixes names that cannot start an identifier
+// with X and suffixes reserved words with an underscore. Package names are compared to
+// keywords case-insensitively and do not need to be exported.
+func escapeIdentifier(name string, reserved map[string]bool, pkg bool) string {
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			b.WriteRune(r)
+		}
+	}
+	name = b.String()
+
+	if name == "" || checkIdentifier(name, !pkg) != "" {
+		name = "X" + name
+	}
+	keyword := token.IsKeyword(name)
+	if pkg {
+		keyword = token.IsKeyword(strings.ToLower(name))
+	}
+	if keyword || reserved[name] {
+		name += "_"
+	}
+	return name
+}
+
+// unique appends the lowest numeric suffix that makes name unused.
+func unique(name string, used map[string]bool, foldCase bool) string {
+	key := func(s string) string {
+		if foldCase {
+			return strings.ToLower(s)
+		}
+		return s
+	}
+	if !used[key(name)] {
+		return name
+	}
+	for i := 2; ; i++ {
+		candidate := name + strconv.Itoa(i)
+		if !used[key(candidate)] {
+			return candidate
+		}
+	}
+}
+
+func setOverride(m *map[string]string, key, value string) {
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+	(*m)[key] = value
+}
@@ -0,0 +1,110 @@
+package naming
+
+import (
+	"testing"
+
+	"github.com/rah-0/margo/conf"
+)
+
+func invalidTables() []conf.Table {
+	return []conf.Table{
+		{Name: "type", Fields: []conf.TableField{{Name: "id"}}},
+		{Name: "select", Fields: []conf.TableField{{Name: "id"}}},
+		{Name: "new_tx", Fields: []conf.TableField{{Name: "id"}}},
+		{Name: "metrics", Fields: []conf.TableField{
+			{Name: "1st_value"},
+			{Name: "a_b"},
+			{Name: "aB"},
+			{Name: "db_insert"},
+			{Name: "price!"},
+		}},
+		{Name: "user_data", Fields: []conf.TableField{{Name: "id"}}},
+		{Name: "UserData", Fields: []conf.TableField{{Name: "id"}}},
+		{
+			Name:        "orders",
+			Fields:      []conf.TableField{{Name: "owner_id"}, {Name: "db_ref_owner_id"}},
+			ForeignKeys: []conf.ForeignKey{{Name: "fk_owner", Columns: []string{"owner_id"}, RefTable: "users", RefColumns: []string{"id"}}},
+		},
+	}
+}
+
+func TestValidateFail(t *testing.T) {
+	SetStrategy(NewGo([]string{"DB"}))
+	SetOverrides(Overrides{})
+	conf.Args.OnInvalidName = OnInvalidFail
+	defer func() {
+		SetStrategy(Legacy{})
+		SetOverrides(Overrides{})
+		conf.Args.OnInvalidName = ""
+	}()
+
+	report, err := Validate(invalidTables())
+	if err == nil {
+		t.Fatal("expected validation error, got nil")
+	}
+
+	expected := []string{
+		`table "type": package "Type" is a reserved word`,
+		`table "select": package "Select" is a reserved word`,
+		`table "new_tx": package "NewTx" is a reserved word`,
+		`table "metrics" column "1st_value": field "1stValue" does not start with a letter`,
+		`table "metrics" column "aB": field "AB" is already used by another column`,
+		`table "metrics" column "db_insert": field "DBInsert" clashes with a generated method`,
+		`table "metrics" column "price!": field "Price!" contains invalid character '!'`,
+		`table "UserData": package "UserData" is already used by another table`,
+		`table "orders" column "db_ref_owner_id": field "DBRefOwnerID" clashes with a generated method`,
+	}
+	if len(report.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %d:\n%s", len(expected), len(report.Problems), report.Error())
+	}
+	for i, p := range report.Problems {
+		if p.String() != expected[i] {
+			t.Errorf("problem %d = %q; want %q", i, p.String(), expected[i])
+		}
+	}
+}
+
+func TestValidateEscape(t *testing.T) {
+	SetStrategy(NewGo([]string{"DB"}))
+	SetOverrides(Overrides{})
+	conf.Args.OnInvalidName = OnInvalidEscape
+	defer func() {
+		SetStrategy(Legacy{})
+		SetOverrides(Overrides{})
+		conf.Args.OnInvalidName = ""
+	}()
+
+	if _, err := Validate(invalidTables()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		got      string
+		expected string
+	}{
+		{Package("type"), "Type_"},
+		{Package("select"), "Select_"},
+		{Package("new_tx"), "NewTx_"},
+		{Package("UserData"), "UserData2"},
+		{Field("metrics", "1st_value"), "X1stValue"},
+		{Field("metrics", "a_b"), "AB"},
+		{Field("metrics", "aB"), "AB2"},
+		{Field("metrics", "db_insert"), "DBInsert_"},
+		{Field("metrics", "price!"), "Price"},
+		{Field("orders", "db_ref_owner_id"), "DBRefOwnerID_"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.expected {
+			t.Errorf("got %q; want %q", tt.got, tt.expected)
+		}
+	}
+
+	// escaped names are stable and valid on a second pass
+	report, err := Validate(invalidTables())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("expected no problems after escaping, got:\n%s", report.Error())
+	}
+}
@@ -0,0 +1,103 @@
+package snapshot
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/rah-0/nabu"
+
+	"github.com/rah-0/margo/conf"
+)
+
+// Version is bumped whenever the file format changes in a way older readers can't handle.
+const Version = 1
+
+// Snapshot is the intermediate representation between the schema source and the generator.
+type Snapshot struct {
+	Version int               `json:"version"`
+	Schema  string            `json:"schema"`
+	Tables  []conf.Table      `json:"tables"`
+	Queries []conf.NamedQuery `json:"queries"`
+}
+
+// New builds a snapshot with tables and queries sorted by name, so the same schema always produces the same file.
+func New(schema string, tables []conf.Table, nqs []conf.NamedQuery) *Snapshot {
+	s := &Snapshot{
+		Version: Version,
+		Schema:  schema,
+		Tables:  append([]conf.Table{}, tables...),
+		Queries: append([]conf.NamedQuery{}, nqs...),
+	}
+	sort.SliceStable(s.Tables, func(i, j int) bool { return s.Tables[i].Name < s.Tables[j].Name })
+	sort.SliceStable(s.Queries, func(i, j int) bool { return s.Queries[i].Name < s.Queries[j].Name })
+
+	return s
+}
+
+func Marshal(s *Snapshot) ([]byte, error) {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, nabu.FromError(err).Log()
+	}
+
+	return append(b, '\n'), nil
+}
+
+func Unmarshal(b []byte) (*Snapshot, error) {
+	s := &Snapshot{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, nabu.FromError(err).Log()
+	}
+	if s.Version < 1 || s.Version > Version {
+		return nil, nabu.FromError(errors.New("unsupported snapshot version " + strconv.Itoa(s.Version))).Log()
+	}
+	if s.Schema == "" {
+		return nil, nabu.FromError(errors.New("snapshot has no schema name")).Log()
+	}
+
+	for i, nq := range s.Queries {
+		s.Queries[i].QueryEncoded = base64.StdEncoding.EncodeToString([]byte(nq.Query))
+		if nq.Mode == "" {
+			s.Queries[i].Mode = conf.ResultModeMany
+		}
+	}
+
+	return s, nil
+}
+
+func Write(path string, s *Snapshot) error {
+	b, err := Marshal(s)
+	if err != nil {
+		return nabu.FromError(err).WithArgs(path).Log()
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return nabu.FromError(err).WithArgs(dir).Log()
+		}
+	}
+
+	if err = os.WriteFile(path, b, 0644); err != nil {
+		return nabu.FromError(err).WithArgs(path).Log()
+	}
+
+	return nil
+}
+
+func Read(path string) (*Snapshot, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nabu.FromError(err).WithArgs(path).Log()
+	}
+
+	s, err := Unmarshal(b)
+	if err != nil {
+		return nil, nabu.FromError(err).WithArgs(path).Log()
+	}
+
+	return s, nil
+}
@@ -0,0 +1,104 @@
+package snapshot
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rah-0/margo/conf"
+)
+
+func sampleTables() []conf.Table {
+	return []conf.Table{
+		{
+			Name: "beta",
+			Fields: []conf.TableField{
+				{Name: "uuid", DataType: "uuid", ColumnType: "uuid"},
+				{Name: "name", DataType: "varchar", ColumnType: "varchar(191)", Nullable: true, Default: "NULL"},
+			},
+			Indexes: []conf.Index{{Name: "PRIMARY", Columns: []string{"uuid"}, Unique: true, Primary: true}},
+		},
+		{
+			Name: "alpha",
+			Fields: []conf.TableField{
+				{Name: "id", DataType: "int", ColumnType: "int(11)", Extra: "auto_increment"},
+				{Name: "beta_uuid", DataType: "uuid", ColumnType: "uuid", Nullable: true, Default: "NULL"},
+			},
+			ForeignKeys: []conf.ForeignKey{{
+				Name: "fk_beta", Columns: []string{"beta_uuid"}, RefTable: "beta", RefColumns: []string{"uuid"},
+				OnDelete: "CASCADE", OnUpdate: "RESTRICT",
+			}},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	nqs := []conf.NamedQuery{{
+		Name:   "GetAlpha",
+		Query:  "SELECT id FROM alpha WHERE id = ?",
+		Params: []string{"id"},
+		Mode:   conf.ResultModeOne,
+		MapAs:  "alpha",
+	}}
+
+	s := New("app", sampleTables(), nqs)
+	if s.Tables[0].Name != "alpha" || s.Tables[1].Name != "beta" {
+		t.Fatalf("tables are not sorted: %s, %s", s.Tables[0].Name, s.Tables[1].Name)
+	}
+
+	p := filepath.Join(t.TempDir(), "snapshots", "schema.json")
+	if err := Write(p, s); err != nil {
+		t.Fatal(err)
+	}
+	read, err := Read(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(read.Tables, s.Tables) {
+		t.Errorf("tables mismatch\nexpected: %+v\ngot:      %+v", s.Tables, read.Tables)
+	}
+	if read.Queries[0].QueryEncoded != "U0VMRUNUIGlkIEZST00gYWxwaGEgV0hFUkUgaWQgPSA/" {
+		t.Errorf("QueryEncoded was not restored: %q", read.Queries[0].QueryEncoded)
+	}
+	read.Queries[0].QueryEncoded = ""
+	if !reflect.DeepEqual(read.Queries, nqs) {
+		t.Errorf("queries mismatch\nexpected: %+v\ngot:      %+v", nqs, read.Queries)
+	}
+}
+
+func TestMarshalIsStable(t *testing.T) {
+	a, err := Marshal(New("app", sampleTables(), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := sampleTables()
+	tables[0], tables[1] = tables[1], tables[0]
+	b, err := Marshal(New("app", tables, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(a) != string(b) {
+		t.Errorf("snapshot depends on input order:\n%s\n%s", a, b)
+	}
+	if strings.Contains(string(a), "GoName") {
+		t.Error("resolved Go names must not be part of the snapshot")
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []string{
+		`{`,
+		`{"version": 0, "schema": "app"}`,
+		`{"version": 99, "schema": "app"}`,
+		`{"version": 1}`,
+	}
+
+	for _, in := range tests {
+		if _, err := Unmarshal([]byte(in)); err == nil {
+			t.Errorf("expected error for %s, got nil", in)
+		}
+	}
+}
@@ -4,10 +4,10 @@ import (
 	"path/filepath"
 
 	"github.com/rah-0/margo/conf"
-	"github.com/rah-0/margo/db"
+	"github.com/rah-0/margo/naming"
 	"github.com/rah-0/margo/util"
 )
 
 func PathCreateDBDir() error {
-	return util.EnsureDir(filepath.Join(conf.Args.OutputPath, db.NormalizeString(conf.Args.DBName)))
+	return util.EnsureDir(filepath.Join(conf.Args.OutputPath, naming.Package(conf.Args.DBName)), isPreview())
 }
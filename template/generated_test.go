@@ -0,0 +1,160 @@
+package template
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rah-0/margo/conf"
+	"github.com/rah-0/margo/naming"
+)
+
+// TestGeneratedCode writes the code generated for a few tables of both APIs into a temporary module and vets it with
+// the go tool, so that generated code which does not compile fails here. What the code does is tested on margort and
+// on the generated text.
+func TestGeneratedCode(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go tool not found")
+	}
+	defer func(a conf.Arguments) { conf.Args = a }(conf.Args)
+	dir := t.TempDir()
+	conf.Args.OutputPath = dir
+	conf.Args.VersionColumns = []string{"version"}
+	conf.Args.SoftDeleteColumns = []string{"deleted_at"}
+	conf.Args.UUIDColumns = []string{"uuid"}
+	conf.Args.CreatedColumns = []string{"created_at"}
+	conf.Args.UpdatedColumns = []string{"updated_at"}
+
+	sum, err := os.ReadFile("../go.sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeGenerated(t, dir, "go.mod", "module gentest\n\ngo 1.24\n\nrequire github.com/go-sql-driver/mysql "+requiredVersion(t, "github.com/go-sql-driver/mysql")+"\n")
+	writeGenerated(t, dir, "go.sum", string(sum))
+	writeGenerated(t, dir, RuntimePackage+"/"+RuntimePackage+".go", GetFileContentRuntime())
+
+	tables := map[string][]conf.TableField{
+		"users": {
+			{Name: "id", DataType: "int", ColumnType: "int(11)", Extra: "auto_increment"},
+			{Name: "uuid", DataType: "char", ColumnType: "char(36)"},
+			{Name: "name", DataType: "varchar", ColumnType: "varchar(50)"},
+			{Name: "version", DataType: "timestamp", ColumnType: "timestamp(6)"},
+			{Name: "created_at", DataType: "datetime", ColumnType: "datetime"},
+			{Name: "updated_at", DataType: "datetime", ColumnType: "datetime"},
+			{Name: "deleted_at", DataType: "timestamp", ColumnType: "timestamp", Nullable: true, Default: "NULL"},
+		},
+		"tags": {
+			{Name: "name", DataType: "varchar", ColumnType: "varchar(50)"},
+			{Name: "version", DataType: "bigint", ColumnType: "bigint(20)"},
+		},
+	}
+	nqs := []conf.NamedQuery{
+		ExtractNamedQuery("-- Returns: id name\n-- MapAs: users\nSELECT id, name FROM users WHERE name = ?", "ByName"),
+		ExtractNamedQuery("-- ResultMode: exec\nUPDATE users SET name = \"a\" WHERE id = ?", "Rename"),
+	}
+	for _, schema := range []struct {
+		name, api string
+		returning bool
+	}{{"app", conf.APIVariants, false}, {"billing", conf.APIContext, true}} {
+		conf.Args.DBName, conf.Args.API, conf.Args.InsertReturning = schema.name, schema.api, schema.returning
+		pkg := naming.Package(schema.name)
+		var tns []string
+		for table, tfs := range tables {
+			tns = append(tns, table)
+			var tnqs []conf.NamedQuery
+			if table == "users" {
+				tnqs = nqs[:1]
+			}
+			c, err := GetFileContentEntity(table, tfs, tnqs, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeGenerated(t, dir, pkg+"/"+naming.Package(table)+"/entity.go", c)
+		}
+		writeGenerated(t, dir, pkg+"/queries.go", GetFileContentQueries("gentest/"+pkg, tns, nqs[1:]))
+		writeGenerated(t, dir, pkg+"/Users/hooks.go", generatedHooks)
+		writeGenerated(t, dir, pkg+"/tx.go", strings.ReplaceAll(generatedTx, "PKG", pkg))
+	}
+
+	cmd := exec.Command("go", "vet", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generated code does not compile: %v\n%s", err, out)
+	}
+}
+
+// generatedHooks implements every hook, so that the names of the hook interfaces are checked as well.
+const generatedHooks = `package Users
+
+import (
+	"context"
+
+	"gentest/margort"
+)
+
+var (
+	_ margort.BeforeInserter = (*Entity)(nil)
+	_ margort.AfterInserter  = (*Entity)(nil)
+	_ margort.BeforeUpdater = (*Entity)(nil)
+	_ margort.AfterUpdater  = (*Entity)(nil)
+	_ margort.BeforeDeleter = (*Entity)(nil)
+	_ margort.AfterDeleter  = (*Entity)(nil)
+)
+
+func (x *Entity) BeforeInsert(context.Context) error { return nil }
+func (x *Entity) AfterInsert(context.Context) error  { return nil }
+func (x *Entity) BeforeUpdate(context.Context) error { return nil }
+func (x *Entity) AfterUpdate(context.Context) error  { return nil }
+func (x *Entity) BeforeDelete(context.Context) error { return nil }
+func (x *Entity) AfterDelete(context.Context) error  { return nil }
+`
+
+// generatedTx runs transactions on Repos of a *sql.Conn, so that they do not depend on the database set by SetDB.
+const generatedTx = `package PKG
+
+import (
+	"context"
+	"database/sql"
+
+	"gentest/PKG/Users"
+)
+
+func connTx(ctx context.Context, conn *sql.Conn) error {
+	return NewRepo(conn).WithTxRetries(0).WithTxContext(ctx, nil, func(ctx context.Context, _ *sql.Tx) error {
+		return Users.NewRepo(conn).WithTx(ctx, nil, func(*sql.Tx) error { return nil })
+	})
+}
+`
+
+func writeGenerated(t *testing.T, dir, name, content string) {
+	t.Helper()
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// requiredVersion returns the version of module in the go.mod of margo.
+func requiredVersion(t *testing.T, module string) string {
+	t.Helper()
+	data, err := os.ReadFile("../go.mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == module {
+			return fields[1]
+		}
+		if fields := strings.Fields(line); len(fields) >= 3 && fields[0] == "require" && fields[1] == module {
+			return fields[2]
+		}
+	}
+	t.Fatalf("%s is not required by margo", module)
+	return ""
+}
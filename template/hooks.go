@@ -0,0 +1,32 @@
+package template
+
+// hookInterfaces names the margort hook interfaces of each event after the Before or After prefix.
+var hookInterfaces = map[string]string{
+	"Insert": "Inserter",
+	"Update": "Updater",
+	"Delete": "Deleter",
+}
+
+// getHookedCore returns the core of op that runs core between the Before and After hooks of event the entity
+// implements, see margort.BeforeInserter, followed by the wrappers of op.
+func getHookedCore(op, core, event string) string {
+	hooked := core + "Hooked"
+	hooker := hookInterfaces[event]
+	t := "func (r *Repo) " + hooked + "(ctx context.Context, tx *sql.Tx, x *Entity, params *QueryParams) *QueryResult {\n"
+	t += "	hctx := margort.HookContext(ctx, r.primary(), tx)\n"
+	t += "	if h, ok := any(x).(margort.Before" + hooker + "); ok {\n"
+	t += "		if err := h.Before" + event + "(hctx); err != nil {\n"
+	t += "			return &QueryResult{Error: margort.WrapError(err, r.fqtn(ctx), \"" + op + "\")}\n"
+	t += "		}\n"
+	t += "	}\n"
+	t += "	res := r." + core + "(ctx, tx, x, params)\n"
+	t += "	if h, ok := any(x).(margort.After" + hooker + "); ok && res.Error == nil {\n"
+	t += "		if err := h.After" + event + "(hctx); err != nil {\n"
+	t += "			res.Error = margort.WrapError(err, r.fqtn(ctx), \"" + op + "\")\n"
+	t += "		}\n"
+	t += "	}\n"
+	t += "	return res\n"
+	t += "}\n\n"
+	t += getDBWrappers(op, hooked, true, true)
+	return t
+}
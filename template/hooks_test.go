@@ -0,0 +1,25 @@
+package template
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetHookedCore(t *testing.T) {
+	c := getHookedCore("DBUpdate", "update", "Update")
+	for _, expected := range []string{
+		"func (r *Repo) updateHooked(ctx context.Context, tx *sql.Tx, x *Entity, params *QueryParams) *QueryResult {",
+		"if h, ok := any(x).(margort.BeforeUpdater); ok {",
+		"if err := h.BeforeUpdate(hctx); err != nil {",
+		"if h, ok := any(x).(margort.AfterUpdater); ok && res.Error == nil {",
+		"func (r *Repo) DBUpdate(ctx context.Context, x *Entity, params *QueryParams) *QueryResult { return r.updateHooked(ctx, nil, x, params) }",
+	} {
+		if !strings.Contains(c, expected) {
+			t.Errorf("generated code lacks %q:\n%s", expected, c)
+		}
+	}
+	// a failing Before hook returns before the core runs
+	if before, core := strings.Index(c, "return &QueryResult{Error: margort.WrapError(err"), strings.Index(c, "res := r.update("); before < 0 || before > core {
+		t.Errorf("the Before hook does not cancel the operation:\n%s", c)
+	}
+}
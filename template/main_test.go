@@ -27,7 +27,7 @@ func TestMain(m *testing.M) {
 				return err
 			}
 
-			tableNames, err = db.GetDbTables(conn)
+			tableNames, err = db.GetDbTables(conn, conf.Args.DBName)
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,152 @@
+package template
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/rah-0/nabu"
+
+	"github.com/rah-0/margo/conf"
+)
+
+// ManagedFields are the columns of a table the generated operations fill or check on their own, nil when the table
+// has none.
+type ManagedFields struct {
+	AutoIncrement *conf.TableField // written back into the entity by DBInsert when empty
+	Version       *conf.TableField // compared and bumped by DBUpdate
+	SoftDelete    *conf.TableField // set by DBDelete and skipped by the reads
+	UUID          *conf.TableField // filled with margort.NewUUID by DBInsert when empty
+	Created       *conf.TableField // filled with margort.Now by DBInsert when empty
+	Updated       *conf.TableField // filled with margort.Now by DBInsert when empty and by every DBUpdate
+	Defaults      []string         // Go names of the columns with a database default, left out by DBInsert when empty
+}
+
+// ResolveManagedFields returns the managed columns of rawTableName, see ManagedFields.
+func ResolveManagedFields(rawTableName string, tfs []conf.TableField) (ManagedFields, error) {
+	var mf ManagedFields
+	var err error
+	if mf.Version, err = VersionField(rawTableName, tfs); err != nil {
+		return mf, nabu.FromError(err).WithArgs(rawTableName).Log()
+	}
+	if mf.SoftDelete, err = SoftDeleteField(rawTableName, tfs); err != nil {
+		return mf, nabu.FromError(err).WithArgs(rawTableName).Log()
+	}
+	if mf.UUID, err = configuredField(rawTableName, tfs, conf.Args.UUIDColumns, isUUID, "uuid column must be a uuid, char or varchar"); err != nil {
+		return mf, nabu.FromError(err).WithArgs(rawTableName).Log()
+	}
+	if mf.Created, err = configuredField(rawTableName, tfs, conf.Args.CreatedColumns, isTimestamp, "created column must be a timestamp or datetime"); err != nil {
+		return mf, nabu.FromError(err).WithArgs(rawTableName).Log()
+	}
+	if mf.Updated, err = configuredField(rawTableName, tfs, conf.Args.UpdatedColumns, isTimestamp, "updated column must be a timestamp or datetime"); err != nil {
+		return mf, nabu.FromError(err).WithArgs(rawTableName).Log()
+	}
+	for i, tf := range tfs {
+		if hasDatabaseDefault(tf) {
+			mf.Defaults = append(mf.Defaults, tf.GoName)
+		}
+		if mf.AutoIncrement == nil && isCounter(tf) && strings.Contains(strings.ToLower(tf.Extra), "auto_increment") {
+			mf.AutoIncrement = &tfs[i]
+		}
+	}
+	return mf, nil
+}
+
+// configuredField returns the column of a column or table.column list that applies to rawTableName. A bare column
+// only applies to the tables where accept holds for it, a table.column entry fails with reason otherwise.
+func configuredField(rawTableName string, tfs []conf.TableField, entries []string, accept func(conf.TableField) bool, reason string) (*conf.TableField, error) {
+	name, explicit := configuredColumn(rawTableName, entries)
+	if name == "" {
+		return nil, nil
+	}
+
+	for i, tf := range tfs {
+		if tf.Name != name {
+			continue
+		}
+		if accept(tf) {
+			return &tfs[i], nil
+		}
+		if explicit {
+			return nil, nabu.FromError(errors.New(reason)).WithArgs(rawTableName, name, tf.ColumnType).Log()
+		}
+		return nil, nil
+	}
+	if explicit {
+		return nil, nabu.FromError(errors.New("column does not exist")).WithArgs(rawTableName, name).Log()
+	}
+	return nil, nil
+}
+
+func isUUID(tf conf.TableField) bool {
+	switch strings.ToLower(tf.DataType) {
+	case "uuid", "char", "varchar":
+		return true
+	}
+	return false
+}
+
+// hasDatabaseDefault reports whether MariaDB fills tf when an INSERT leaves it out: it has a default, NULL included,
+// or is an auto_increment or generated column.
+func hasDatabaseDefault(tf conf.TableField) bool {
+	extra := strings.ToLower(tf.Extra)
+	return tf.Default != "" || strings.Contains(extra, "auto_increment") || strings.Contains(extra, "generated")
+}
+
+// autofills reports whether DBInsert fills a column in Go.
+func (mf ManagedFields) autofills() bool {
+	return mf.UUID != nil || mf.Created != nil || mf.Updated != nil
+}
+
+// GetInsertFunctions returns the helpers of the insert core: insertFields, which leaves out the empty columns with a
+// database default, and fillInsert, which fills the UUID and timestamp columns.
+func GetInsertFunctions(mf ManagedFields) string {
+	t := ""
+	if len(mf.Defaults) > 0 {
+		t += "// defaultFields are the columns with a database default, left out by DBInsert while they are empty.\n"
+		t += "var defaultFields = map[string]bool{\n"
+		for _, f := range mf.Defaults {
+			t += "	Field" + f + ": true,\n"
+		}
+		t += "}\n\n"
+
+		t += "func insertFields(x *Entity) []string {\n"
+		t += "	fields := make([]string, 0, len(Fields))\n"
+		t += "	for _, f := range Fields {\n"
+		t += "		if !defaultFields[f] || x.GetFieldValue(f) != \"\" {\n"
+		t += "			fields = append(fields, f)\n"
+		t += "		}\n"
+		t += "	}\n"
+		t += "	return fields\n"
+		t += "}\n\n"
+	}
+
+	if mf.autofills() {
+		t += "func fillInsert(x *Entity) {\n"
+		if mf.UUID != nil {
+			t += "	if x." + mf.UUID.GoName + " == \"\" {\n"
+			t += "		x." + mf.UUID.GoName + " = margort.NewUUID()\n"
+			t += "	}\n"
+		}
+		if mf.Created != nil || mf.Updated != nil {
+			t += "	now := margort.Now()\n"
+		}
+		for _, tf := range []*conf.TableField{mf.Created, mf.Updated} {
+			if tf != nil {
+				t += "	if x." + tf.GoName + " == \"\" {\n"
+				t += "		x." + tf.GoName + " = now.Format(\"" + timestampLayout(*tf) + "\")\n"
+				t += "	}\n"
+			}
+		}
+		t += "}\n\n"
+	}
+	return t
+}
+
+// getUpdatedAtFill returns the lines of the update cores that compute the new value of the updated column uf into
+// updatedAt and leave uf out of update, the fields assigned from the entity. The cores only set it on the entity once
+// the row was updated.
+func getUpdatedAtFill(uf *conf.TableField) string {
+	t := "	updatedAt := margort.Now().Format(\"" + timestampLayout(*uf) + "\")\n"
+	t += "	update = withoutField(update, Field" + uf.GoName + ")\n"
+	return t
+}
@@ -0,0 +1,62 @@
+package template
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rah-0/margo/conf"
+)
+
+func TestResolveManagedFields(t *testing.T) {
+	defer func(a conf.Arguments) { conf.Args = a }(conf.Args)
+	conf.Args.UUIDColumns = []string{"uuid", "orders.id"}
+	conf.Args.CreatedColumns = []string{"created_at"}
+	conf.Args.UpdatedColumns = []string{"name"}
+
+	tfs := []conf.TableField{
+		{Name: "id", GoName: "Id", DataType: "int", Extra: "auto_increment"},
+		{Name: "uuid", GoName: "Uuid", DataType: "char", Default: "'00000000-0000-0000-0000-000000000000'"},
+		{Name: "name", GoName: "Name", DataType: "varchar"},
+		{Name: "note", GoName: "Note", DataType: "text", Nullable: true, Default: "NULL"},
+		{Name: "created_at", GoName: "CreatedAt", DataType: "timestamp", Default: "current_timestamp(6)"},
+		{Name: "total", GoName: "Total", DataType: "int", Extra: "STORED GENERATED"},
+	}
+
+	mf, err := ResolveManagedFields("users", tfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mf.AutoIncrement == nil || mf.AutoIncrement.Name != "id" || mf.UUID == nil || mf.UUID.Name != "uuid" || mf.Created == nil || mf.Created.Name != "created_at" || mf.Updated != nil {
+		t.Errorf("unexpected managed fields: %+v", mf)
+	}
+	if !slices.Equal(mf.Defaults, []string{"Id", "Uuid", "Note", "CreatedAt", "Total"}) {
+		t.Errorf("unexpected defaults: %v", mf.Defaults)
+	}
+
+	if _, err := ResolveManagedFields("orders", tfs); err == nil {
+		t.Error("expected an error for an int uuid column")
+	}
+}
+
+func TestInsertReturningResult(t *testing.T) {
+	defer func(a conf.Arguments) { conf.Args = a }(conf.Args)
+	conf.Args.DBName = "app"
+	conf.Args.InsertReturning = true
+
+	id := conf.TableField{Name: "id", GoName: "Id", DataType: "int", ColumnType: "int(11)", Extra: "auto_increment"}
+	c := GetDBFunctions(ManagedFields{AutoIncrement: &id})
+	for _, expected := range []string{
+		"var res margort.RowResult",
+		"res.Rows = 1",
+		"res.ID, _ = strconv.ParseInt(x.Id, 10, 64)",
+		"return &QueryResult{Result: res, Entity: x}",
+	} {
+		if !strings.Contains(c, expected) {
+			t.Errorf("generated code lacks %q", expected)
+		}
+	}
+	if c := GetDBFunctions(ManagedFields{}); !strings.Contains(c, "var res margort.RowResult") || strings.Contains(c, "res.ID") {
+		t.Error("unexpected insert without an auto_increment column")
+	}
+}
@@ -6,5 +6,10 @@ import (
 )
 
 func PathCreateOutputDir() error {
-	return util.EnsureDir(conf.Args.OutputPath)
+	return util.EnsureDir(conf.Args.OutputPath, isPreview())
+}
+
+// isPreview reports whether files must be compared instead of written (-dryRun or -check).
+func isPreview() bool {
+	return conf.Args.DryRun || conf.Args.Check
 }
@@ -0,0 +1,68 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rah-0/nabu"
+
+	"github.com/rah-0/margo/conf"
+	"github.com/rah-0/margo/naming"
+	"github.com/rah-0/margo/util"
+)
+
+// PruneStaleFiles removes generated .go files below the database directory that were not written in this run,
+// e.g. the entity.go of a dropped table. Files without the GetCommentWarning header are never touched.
+func PruneStaleFiles() error {
+	root := filepath.Join(conf.Args.OutputPath, naming.Package(conf.Args.DBName))
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil
+	}
+
+	written := make(map[string]bool)
+	for _, fc := range util.FileChanges() {
+		written[filepath.Clean(fc.Path)] = true
+	}
+
+	var stale []string
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(p) != ".go" || written[filepath.Clean(p)] {
+			return nil
+		}
+		content, err := util.ReadFileAsString(p)
+		if err != nil {
+			return err
+		}
+		if IsGenerated(content) {
+			stale = append(stale, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nabu.FromError(err).WithArgs(root).Log()
+	}
+
+	for _, p := range stale {
+		if err = util.RemoveFile(p, isPreview()); err != nil {
+			return nabu.FromError(err).WithArgs(p).Log()
+		}
+	}
+
+	return util.RemoveEmptyParents(root, stale, isPreview())
+}
+
+// IsGenerated reports whether a Go source starts with the GetCommentWarning header right after its package clause.
+func IsGenerated(content string) bool {
+	if !strings.HasPrefix(content, "package ") {
+		return false
+	}
+	i := strings.IndexByte(content, '\n')
+	if i < 0 {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimLeft(content[i:], "\n"), GetCommentWarning())
+}
@@ -0,0 +1,80 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rah-0/margo/conf"
+	"github.com/rah-0/margo/naming"
+	"github.com/rah-0/margo/util"
+)
+
+func TestIsGenerated(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected bool
+	}{
+		{"package Alpha\n\n" + GetCommentWarning() + "import ()\n", true},
+		{"package Alpha\n\nimport ()\n", false},
+		{"package Alpha\n\n// The code in this file is autogenerated, do not modify manually!\n", false},
+		{GetCommentWarning(), false},
+	}
+
+	for i, tt := range tests {
+		if got := IsGenerated(tt.content); got != tt.expected {
+			t.Errorf("test %d: IsGenerated = %v; want %v", i, got, tt.expected)
+		}
+	}
+}
+
+func TestPruneStaleFiles(t *testing.T) {
+	outputPath := conf.Args.OutputPath
+	conf.Args.OutputPath = t.TempDir()
+	util.ResetFileChanges()
+	defer func() {
+		conf.Args.OutputPath = outputPath
+		util.ResetFileChanges()
+	}()
+
+	root := filepath.Join(conf.Args.OutputPath, naming.Package(conf.Args.DBName))
+	kept := filepath.Join(root, "Alpha", "entity.go")
+	dropped := filepath.Join(root, "Dropped", "entity.go")
+	manual := filepath.Join(root, "Manual", "helpers.go")
+	empty := filepath.Join(root, "Empty")
+
+	for _, p := range []string{kept, dropped, manual} {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(empty, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteGoFile(kept, "package Alpha\n\n"+GetCommentWarning(), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dropped, []byte("package Dropped\n\n"+GetCommentWarning()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(manual, []byte("package Manual\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PruneStaleFiles(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("expected %s to be kept: %v", kept, err)
+	}
+	if _, err := os.Stat(manual); err != nil {
+		t.Errorf("expected %s to be kept: %v", manual, err)
+	}
+	if _, err := os.Stat(empty); err != nil {
+		t.Errorf("expected %s, which held no deleted file, to be kept: %v", empty, err)
+	}
+	if _, err := os.Stat(filepath.Dir(dropped)); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", filepath.Dir(dropped))
+	}
+}
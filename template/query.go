@@ -3,96 +3,129 @@ package template
 import (
 	"encoding/base64"
 	"errors"
+	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/rah-0/nabu"
 
 	"github.com/rah-0/margo/conf"
-	"github.com/rah-0/margo/db"
+	"github.com/rah-0/margo/naming"
 	"github.com/rah-0/margo/util"
 )
 
-var selectStarRegex = regexp.MustCompile(`(?i)select\s*\*`)
+var (
+	selectStarRegex = regexp.MustCompile(`(?i)select\s*\*`)
+	readQueryRegex  = regexp.MustCompile(`(?i)^[\s(]*(select|with)\b`)
+	queryTableRegex = regexp.MustCompile("(?i)\\b(?:from|join)\\s+(?:`?\\w+`?\\.)?`?(\\w+)`?")
+)
 
-func CreateGoFileQueries(tns []string) ([]conf.NamedQuery, error) {
+func CreateGoFileQueries(tns []string, nqs []conf.NamedQuery) ([]conf.NamedQuery, error) {
 	pathModuleOutput, err := util.GetGoModuleImportPath(conf.Args.OutputPath)
 	if err != nil {
 		return []conf.NamedQuery{}, nabu.FromError(err).WithArgs(conf.Args.OutputPath).Log()
 	}
-	pathModuleOutput = filepath.Join(pathModuleOutput, db.NormalizeString(conf.Args.DBName))
+	pathModuleOutput = filepath.Join(pathModuleOutput, naming.Package(conf.Args.DBName))
 
 	nqsGeneral := []conf.NamedQuery{}
 	nqsTableSpecific := []conf.NamedQuery{}
-
-	// Only process queries if a queries path is provided
-	if conf.Args.QueriesPath != "" {
-		// Read all .sql files from directory
-		sqlFiles, err := util.GetSQLFilesInDir(conf.Args.QueriesPath)
-		if err != nil {
-			return []conf.NamedQuery{}, nabu.FromError(err).WithArgs(conf.Args.QueriesPath).Log()
+	for _, nq := range nqs {
+		if nq.MapAs == "" {
+			nqsGeneral = append(nqsGeneral, nq)
+		} else {
+			nqsTableSpecific = append(nqsTableSpecific, nq)
 		}
+	}
 
-		for _, sqlFile := range sqlFiles {
-			content, err := util.ReadFileAsString(sqlFile)
-			if err != nil {
-				return []conf.NamedQuery{}, nabu.FromError(err).WithArgs(sqlFile).Log()
-			}
-			if err = CheckNoSelectStar([]string{content}); err != nil {
-				return []conf.NamedQuery{}, nabu.FromError(err).WithArgs(sqlFile).Log()
-			}
+	// Always generate queries.go, even with no custom queries
+	p := filepath.Join(conf.Args.OutputPath, naming.Package(conf.Args.DBName), "queries.go")
+	c := GetFileContentQueries(pathModuleOutput, tns, nqsGeneral)
 
-			// Extract query name from filename (without .sql extension)
-			baseName := filepath.Base(sqlFile)
-			queryName := strings.TrimSuffix(baseName, filepath.Ext(baseName))
+	return nqsTableSpecific, util.WriteGoFile(p, c, isPreview())
+}
 
-			// Extract query with name from filename
-			nq := ExtractNamedQuery(content, queryName)
-			if nq.MapAs == "" {
-				nqsGeneral = append(nqsGeneral, nq)
-			} else {
-				nqsTableSpecific = append(nqsTableSpecific, nq)
-			}
-		}
+// LoadNamedQueries parses every .sql file in the queries path, it returns no queries when the path is not set.
+func LoadNamedQueries() ([]conf.NamedQuery, error) {
+	nqs := []conf.NamedQuery{}
+	if conf.Args.QueriesPath == "" {
+		return nqs, nil
 	}
 
-	// Always generate queries.go, even with no custom queries
-	p := filepath.Join(conf.Args.OutputPath, db.NormalizeString(conf.Args.DBName), "queries.go")
-	c := GetFileContentQueries(pathModuleOutput, tns, nqsGeneral)
+	// Read all .sql files from directory
+	sqlFiles, err := util.GetSQLFilesInDir(conf.Args.QueriesPath)
+	if err != nil {
+		return nqs, nabu.FromError(err).WithArgs(conf.Args.QueriesPath).Log()
+	}
+
+	for _, sqlFile := range sqlFiles {
+		content, err := util.ReadFileAsString(sqlFile)
+		if err != nil {
+			return nqs, nabu.FromError(err).WithArgs(sqlFile).Log()
+		}
+		if err = CheckNoSelectStar([]string{content}); err != nil {
+			return nqs, nabu.FromError(err).WithArgs(sqlFile).Log()
+		}
 
-	return nqsTableSpecific, util.WriteGoFile(p, c)
+		// Extract query name from filename (without .sql extension)
+		baseName := filepath.Base(sqlFile)
+		queryName := strings.TrimSuffix(baseName, filepath.Ext(baseName))
+
+		nqs = append(nqs, ExtractNamedQuery(content, queryName))
+	}
+
+	return nqs, nil
 }
 
 func GetFileContentQueries(pathModuleOutput string, tns []string, nqs []conf.NamedQuery) string {
 	hasCustomQueries := len(nqs) > 0
-	t := "package " + db.NormalizeString(conf.Args.DBName) + "\n\n"
+	t := "package " + naming.Package(conf.Args.DBName) + "\n\n"
 	t += GetCommentWarning()
-	t += GetImportsQueries(pathModuleOutput, tns, hasCustomQueries)
+	t += GetImportsQueries(pathModuleOutput, tns)
 	t += GetVarsQueries(nqs)
 	t += GetStructsQueries(hasCustomQueries)
-	t += GetGeneralFunctionsQueries(tns, hasCustomQueries)
+	t += GetGeneralFunctionsQueries(tns)
 	t += GetDBFunctionsQueries(nqs)
 	return t
 }
 
-func GetImportsQueries(pathModuleOutput string, tns []string, hasCustomQueries bool) string {
+func GetImportsQueries(pathModuleOutput string, tns []string) string {
 	imports := "import (\n"
 	imports += `"context"` + "\n"
 	imports += `"database/sql"` + "\n"
-	if hasCustomQueries {
-		imports += `"encoding/base64"` + "\n"
-	}
 	imports += `"errors"` + "\n"
 	imports += `"sync"` + "\n\n"
+	imports += `"` + path.Join(path.Dir(pathModuleOutput), RuntimePackage) + `"` + "\n"
 	for _, tn := range tns {
-		pathModuleTable := filepath.Join(pathModuleOutput, db.NormalizeString(tn))
+		pathModuleTable := filepath.Join(pathModuleOutput, naming.Package(tn))
 		imports += `"` + pathModuleTable + `"` + "\n"
 	}
 	imports += ")\n\n"
 	return imports
 }
 
+// queryTable returns the table a named query reads from, or an empty string when it reads from none or from several.
+func queryTable(query string) string {
+	table := ""
+	for _, m := range queryTableRegex.FindAllStringSubmatch(query, -1) {
+		if table != "" && !strings.EqualFold(m[1], table) {
+			return ""
+		}
+		table = m[1]
+	}
+	return table
+}
+
+// queryField returns the result field name of a column returned by a named query. A table.column entry of Returns, or
+// else the single table the query reads from, scopes the naming overrides like in the entity of that table.
+func queryField(table, f string) string {
+	if t, c, ok := strings.Cut(f, "."); ok {
+		return naming.Field(t, c)
+	}
+	return naming.Field(table, f)
+}
+
 func StripSQLComments(s string) string {
 	var out strings.Builder
 	inSingleQuote, inDoubleQuote := false, false
@@ -162,13 +195,11 @@ func StripSQLComments(s string) string {
 
 func GetVarsQueries(nqs []conf.NamedQuery) string {
 	t := "var (\n"
-	t += "db *sql.DB\n"
-	t += "stmtMu sync.RWMutex\n"
-	t += "stmtCache = make(map[string]*sql.Stmt)\n"
+	t += "defaultRepo = NewRepo(nil)\n"
 	if len(nqs) > 0 {
 		t += "queries = map[string]*NamedQuery{\n"
 		for _, q := range nqs {
-			t += `"` + q.Name + `": {QueryEncoded: "` + q.QueryEncoded + `"},` + "\n"
+			t += `"` + q.Name + `": {Query: ` + strconv.Quote(q.Query) + `, QueryEncoded: "` + q.QueryEncoded + `"},` + "\n"
 		}
 		t += "}\n"
 	}
@@ -176,84 +207,78 @@ func GetVarsQueries(nqs []conf.NamedQuery) string {
 	return t
 }
 
-func GetGeneralFunctionsQueries(tns []string, hasCustomQueries bool) string {
-	t := "func SetDB(x *sql.DB) error {\n"
-	t += "db = x\n\n"
-	if hasCustomQueries {
-		t += "for _, q := range queries {\n"
-		t += "b, err := base64.StdEncoding.DecodeString(q.QueryEncoded)\n"
-		t += "if err != nil {\n"
+func GetGeneralFunctionsQueries(tns []string) string {
+	t := GetRepo(false)
+
+	t += "// SetDB sets the primary database of every table and the replicas their reads go to.\n"
+	t += "func SetDB(x *sql.DB, replicas ...*sql.DB) error {\n"
+	t += "defaultRepo.setDB(x, replicas)\n\n"
+	for _, tn := range tns {
+		t += "if err := " + naming.Package(tn) + ".SetDB(x, replicas...); err != nil {\n"
 		t += "return err\n"
 		t += "}\n"
-		t += "q.Query = string(b)\n"
-		t += "}\n\n"
 	}
+	t += "\nreturn nil\n"
+	t += "}\n\n"
+
+	t += "// SetDBSchema is SetDB querying every table in schema.\n"
+	t += "func SetDBSchema(x *sql.DB, schema string, replicas ...*sql.DB) error {\n"
+	t += "defaultRepo.setDB(x, replicas)\n\n"
 	for _, tn := range tns {
-		t += "if err := " + db.NormalizeString(tn) + ".SetDB(x); err != nil {\n"
+		t += "if err := " + naming.Package(tn) + ".SetDBSchema(x, schema, replicas...); err != nil {\n"
 		t += "return err\n"
 		t += "}\n"
 	}
-	t += "\nreturn nil\n"
+	t += "return nil\n"
+	t += "}\n\n"
+
+	t += "// SetReplicaPicker chooses the replica of each read of every table with p instead of round-robin.\n"
+	t += "func SetReplicaPicker(p margort.Picker) {\n"
+	t += "defaultRepo.WithPicker(p)\n"
+	for _, tn := range tns {
+		t += naming.Package(tn) + ".SetReplicaPicker(p)\n"
+	}
+	t += "}\n\n"
+
+	t += "func WithSchema(ctx context.Context, schema string) context.Context {\n"
+	t += "return margort.WithSchema(ctx, " + strconv.Quote(conf.Args.DBName) + ", schema)\n"
+	t += "}\n\n"
+
+	t += "// WithTx runs fn in a transaction on the primary, see Repo.WithTx.\n"
+	t += "func WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *sql.Tx) error) error {\n"
+	t += "return defaultRepo.WithTx(ctx, opts, fn)\n"
+	t += "}\n\n"
+	t += "// WithTxContext is WithTx passing fn a context that carries the transaction, see Repo.WithTxContext.\n"
+	t += "func WithTxContext(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context, tx *sql.Tx) error) error {\n"
+	t += "return defaultRepo.WithTxContext(ctx, opts, fn)\n"
+	t += "}\n\n"
+	t += "// SetTxRetries sets how many times WithTx runs a transaction again after a deadlock or lock wait timeout,\n"
+	t += "// 3 by default and 0 to never retry. Like SetDB, it is meant to be called once at startup.\n"
+	t += "func SetTxRetries(n int) {\n"
+	t += "defaultRepo.WithTxRetries(n)\n"
 	t += "}\n\n"
 
 	t += "func NewTx() (*sql.Tx, error) {\n"
-	t += "if db == nil {\n"
-	t += `return nil, errors.New("db not initialized")` + "\n"
-	t += "}\n"
-	t += "return db.Begin()\n"
+	t += "return defaultRepo.BeginTx(context.Background(), nil)\n"
 	t += "}\n\n"
 	t += "func NewCtxTx(ctx context.Context) (*sql.Tx, error) {\n"
-	t += "if db == nil {\n"
-	t += `return nil, errors.New("db not initialized")` + "\n"
-	t += "}\n"
-	t += "return db.BeginTx(ctx, nil)\n"
+	t += "return defaultRepo.BeginTx(ctx, nil)\n"
 	t += "}\n\n"
 	t += "func NewTxOpts(opts *sql.TxOptions) (*sql.Tx, error) {\n"
-	t += "if db == nil {\n"
-	t += `return nil, errors.New("db not initialized")` + "\n"
-	t += "}\n"
-	t += "return db.BeginTx(context.Background(), opts)\n"
+	t += "return defaultRepo.BeginTx(context.Background(), opts)\n"
 	t += "}\n\n"
 	t += "func NewCtxTxOpts(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {\n"
-	t += "if db == nil {\n"
-	t += `return nil, errors.New("db not initialized")` + "\n"
-	t += "}\n"
-	t += "return db.BeginTx(ctx, opts)\n"
-	t += "}\n\n"
-
-	t += "func getPreparedStmt(query string) (*sql.Stmt, error) {\n"
-	t += "	stmtMu.RLock()\n"
-	t += "	if stmt, ok := stmtCache[query]; ok {\n"
-	t += "		stmtMu.RUnlock()\n"
-	t += "		return stmt, nil\n"
-	t += "	}\n"
-	t += "	stmtMu.RUnlock()\n\n"
-	t += "	stmtMu.Lock()\n"
-	t += "	defer stmtMu.Unlock()\n"
-	t += "	if stmt, ok := stmtCache[query]; ok {\n"
-	t += "		return stmt, nil\n"
-	t += "	}\n"
-	t += "	stmt, err := db.Prepare(query)\n"
-	t += "	if err != nil {\n"
-	t += "		return nil, err\n"
-	t += "	}\n"
-	t += "	stmtCache[query] = stmt\n"
-	t += "	return stmt, nil\n"
-	t += "}\n\n"
-
-	t += "func bindStmtCtxTx(base *sql.Stmt, ctx context.Context, tx *sql.Tx) (*sql.Stmt, bool) {\n"
-	t += "	if tx == nil {\n"
-	t += "		return base, false\n"
-	t += "	}\n"
-	t += "	if ctx != nil {\n"
-	t += "		return tx.StmtContext(ctx, base), true\n"
-	t += "	}\n"
-	t += "	return tx.Stmt(base), true\n"
+	t += "return defaultRepo.BeginTx(ctx, opts)\n"
 	t += "}\n\n"
 
 	return t
 }
 
+// IsReadQuery reports whether a named query only reads, so that it can run on a replica.
+func IsReadQuery(query string) bool {
+	return readQueryRegex.MatchString(query)
+}
+
 func CheckNoSelectStar(queries []string) error {
 	for i, q := range queries {
 		normalized := strings.Join(strings.Fields(q), " ")
@@ -267,13 +292,13 @@ func CheckNoSelectStar(queries []string) error {
 func GetDBFunctionsQueries(nqs []conf.NamedQuery) string {
 	t := ""
 
-	genResultStruct := func(typeName string, fields []string) string {
+	genResultStruct := func(typeName, table string, fields []string) string {
 		if len(fields) == 0 {
 			return ""
 		}
 		s := "type " + typeName + " struct {\n"
 		for _, f := range fields {
-			s += db.NormalizeString(f) + " string\n"
+			s += queryField(table, f) + " string\n"
 		}
 		s += "}\n\n"
 		return s
@@ -281,6 +306,7 @@ func GetDBFunctionsQueries(nqs []conf.NamedQuery) string {
 
 	genCore := func(nq conf.NamedQuery, mode string, fields []string, hasParams bool, innerType string) string {
 		coreName := "query" + nq.Name
+		table := queryTable(nq.Query)
 		resType := innerType
 		if resType == "" {
 			resType = "Query" + nq.Name + "ResultInner"
@@ -291,19 +317,28 @@ func GetDBFunctionsQueries(nqs []conf.NamedQuery) string {
 
 		// guard: enforce Returns for query modes
 		if (mode == conf.ResultModeMany || mode == conf.ResultModeOne) && len(fields) == 0 {
-			s := "func " + coreName + "(ctx context.Context, tx *sql.Tx, params *QueryParams) " + ret + " {\n"
+			s := "func (r *Repo) " + coreName + "(ctx context.Context, tx *sql.Tx, params *QueryParams) " + ret + " {\n"
 			s += `qr = &Query` + nq.Name + `Result{Error: fmt.Errorf("named query ` + nq.Name + ` requires -- Returns: for ResultMode=` + mode + `")}` + "\n"
 			s += "return\n"
 			s += "}\n\n"
 			return s
 		}
 
-		s := "func " + coreName + "(ctx context.Context, tx *sql.Tx, params *QueryParams) " + ret + " {\n"
+		op := "Query" + nq.Name
+		if mode == conf.ResultModeExec {
+			op = "Exec" + nq.Name
+		}
+		s := "func (r *Repo) " + coreName + "(ctx context.Context, tx *sql.Tx, params *QueryParams) " + ret + " {\n"
 		s += "qr = &Query" + nq.Name + "Result{}\n"
+		s += "defer func() { qr.Error = margort.WrapError(qr.Error, \"\", \"" + op + "\") }()\n"
 		s += "q := queries[\"" + nq.Name + "\"]\n"
-		s += "base, err := getPreparedStmt(q.Query)\n"
+		if mode != conf.ResultModeExec && IsReadQuery(nq.Query) {
+			s += "base, err := r.reader(ctx, tx).prepare(ctx, q.Query)\n"
+		} else {
+			s += "base, err := r.prepare(ctx, q.Query)\n"
+		}
 		s += "if err != nil { qr.Error = err; return }\n\n"
-		s += "stmt, needClose := bindStmtCtxTx(base, ctx, tx)\n"
+		s += "stmt, needClose := r.bind(ctx, tx, base)\n"
 		s += "if needClose { defer func(){ if cerr := stmt.Close(); err == nil && cerr != nil { qr.Error = cerr } }() }\n\n"
 
 		switch mode {
@@ -323,7 +358,7 @@ func GetDBFunctionsQueries(nqs []conf.NamedQuery) string {
 		case conf.ResultModeOne:
 			// use QueryRow(…): no rows.Close needed
 			for _, f := range fields {
-				s += "var ptr" + db.NormalizeString(f) + " *string\n"
+				s += "var ptr" + queryField(table, f) + " *string\n"
 			}
 			if hasParams {
 				s += "if ctx != nil { err = stmt.QueryRowContext(ctx, params.Params...).Scan("
@@ -334,7 +369,7 @@ func GetDBFunctionsQueries(nqs []conf.NamedQuery) string {
 				if i > 0 {
 					s += ", "
 				}
-				s += "&ptr" + db.NormalizeString(f)
+				s += "&ptr" + queryField(table, f)
 			}
 			s += ") } else { err = stmt.QueryRow("
 			if hasParams {
@@ -345,14 +380,14 @@ func GetDBFunctionsQueries(nqs []conf.NamedQuery) string {
 				if i > 0 {
 					s += ", "
 				}
-				s += "&ptr" + db.NormalizeString(f)
+				s += "&ptr" + queryField(table, f)
 			}
 			s += ") }\n"
 			s += "if errors.Is(err, sql.ErrNoRows) { return }\n"
 			s += "if err != nil { qr.Error = err; return }\n\n"
 			s += "x := &" + resType + "{}\n"
 			for _, f := range fields {
-				fn := db.NormalizeString(f)
+				fn := queryField(table, f)
 				s += "if ptr" + fn + " != nil { x." + fn + " = *ptr" + fn + " } else { x." + fn + " = \"\" }\n"
 			}
 			s += "qr.Entity = x\n"
@@ -373,19 +408,19 @@ func GetDBFunctionsQueries(nqs []conf.NamedQuery) string {
 			s += "defer rows.Close()\n\n"
 			s += "for rows.Next() {\n"
 			for _, f := range fields {
-				s += "var ptr" + db.NormalizeString(f) + " *string\n"
+				s += "var ptr" + queryField(table, f) + " *string\n"
 			}
 			s += "if err = rows.Scan("
 			for i, f := range fields {
 				if i > 0 {
 					s += ", "
 				}
-				s += "&ptr" + db.NormalizeString(f)
+				s += "&ptr" + queryField(table, f)
 			}
 			s += "); err != nil { qr.Error = err; return }\n"
 			s += "x := " + resType + "{}\n"
 			for _, f := range fields {
-				fn := db.NormalizeString(f)
+				fn := queryField(table, f)
 				s += "if ptr" + fn + " != nil { x." + fn + " = *ptr" + fn + " } else { x." + fn + " = \"\" }\n"
 			}
 			s += "qr.Entities = append(qr.Entities, &x)\n"
@@ -450,10 +485,16 @@ func GetDBFunctionsQueries(nqs []conf.NamedQuery) string {
 		}
 
 		s := ""
-		s += "func " + namePrefix + params(false, false) + " " + ret + " { return " + core + "(" + coreArgs(false, false) + ") }\n"
-		s += "func " + namePrefix + "Ctx" + params(true, false) + " " + ret + " { return " + core + "(" + coreArgs(true, false) + ") }\n"
-		s += "func " + namePrefix + "Tx" + params(false, true) + " " + ret + " { return " + core + "(" + coreArgs(false, true) + ") }\n"
-		s += "func " + namePrefix + "CtxTx" + params(true, true) + " " + ret + " { return " + core + "(" + coreArgs(true, true) + ") }\n\n"
+		if contextAPI() {
+			s += "func " + namePrefix + params(true, false) + " " + ret + " { return defaultRepo." + core + "(" + coreArgs(true, false) + ") }\n"
+			s += "func (r *Repo) " + namePrefix + params(true, false) + " " + ret + " { return r." + core + "(" + coreArgs(true, false) + ") }\n\n"
+			return s
+		}
+		s += "func " + namePrefix + params(false, false) + " " + ret + " { return defaultRepo." + core + "(" + coreArgs(false, false) + ") }\n"
+		s += "func " + namePrefix + "Ctx" + params(true, false) + " " + ret + " { return defaultRepo." + core + "(" + coreArgs(true, false) + ") }\n"
+		s += "func " + namePrefix + "Tx" + params(false, true) + " " + ret + " { return defaultRepo." + core + "(" + coreArgs(false, true) + ") }\n"
+		s += "func " + namePrefix + "CtxTx" + params(true, true) + " " + ret + " { return defaultRepo." + core + "(" + coreArgs(true, true) + ") }\n"
+		s += "func (r *Repo) " + namePrefix + params(true, false) + " " + ret + " { return r." + core + "(" + coreArgs(true, false) + ") }\n\n"
 		return s
 	}
 
@@ -469,7 +510,7 @@ func GetDBFunctionsQueries(nqs []conf.NamedQuery) string {
 		innerType := ""
 		if (mode == conf.ResultModeMany || mode == conf.ResultModeOne) && len(fields) > 0 {
 			innerType = "Query" + nq.Name + "ResultInner"
-			t += genResultStruct(innerType, fields)
+			t += genResultStruct(innerType, queryTable(nq.Query), fields)
 		}
 
 		// generate QueryResult wrapper struct
@@ -488,7 +529,7 @@ func GetDBFunctionsQueries(nqs []conf.NamedQuery) string {
 		}
 		t += "}\n\n"
 
-		// core + 4 wrappers
+		// core + 4 wrappers and the Repo method
 		t += genCore(nq, mode, fields, hasParams, innerType)
 		t += genWrappers(nq, mode, fields, hasParams)
 	}
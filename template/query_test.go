@@ -1,11 +1,19 @@
 package template
 
 import (
+	"strings"
 	"testing"
+
+	"github.com/rah-0/margo/conf"
+	"github.com/rah-0/margo/naming"
 )
 
 func TestCreateGoFileQueries(t *testing.T) {
-	if _, err := CreateGoFileQueries(tableNames); err != nil {
+	nqs, err := LoadNamedQueries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateGoFileQueries(tableNames, nqs); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -99,3 +107,59 @@ func TestCheckNoSelectStar_Disallowed(t *testing.T) {
 		}
 	}
 }
+
+func TestIsReadQuery(t *testing.T) {
+	tests := map[string]bool{
+		"SELECT id FROM users":                          true,
+		"select\nid from users":                         true,
+		"(SELECT id FROM a) UNION (SELECT id FROM b)":   true,
+		"WITH x AS (SELECT id FROM a) SELECT id FROM x": true,
+		"INSERT INTO logs (msg) VALUES (?)":             false,
+		"DELETE FROM users WHERE id = ? RETURNING id":   false,
+		"selection_reset()":                             false,
+	}
+
+	for q, expected := range tests {
+		if got := IsReadQuery(q); got != expected {
+			t.Errorf("IsReadQuery(%q) = %v, expected %v", q, got, expected)
+		}
+	}
+}
+
+func TestNamedQueryText(t *testing.T) {
+	nq := ExtractNamedQuery("-- ResultMode: exec\nUPDATE users\nSET name = \"a\\\\b\"\nWHERE id = ?\n", "Rename")
+	// the query text is there before SetDB, without decoding it at init
+	c := GetVars(nil, []conf.NamedQuery{nq})
+	if expected := `"Rename": {Query: "UPDATE users\nSET name = \"a\\\\b\"\nWHERE id = ?", QueryEncoded: "`; !strings.Contains(c, expected) {
+		t.Errorf("generated code lacks %q:\n%s", expected, c)
+	}
+	c = GetVarsQueries([]conf.NamedQuery{nq})
+	if expected := `"Rename": {Query: "UPDATE users\nSET name = \"a\\\\b\"\nWHERE id = ?", QueryEncoded: "`; !strings.Contains(c, expected) {
+		t.Errorf("generated code lacks %q:\n%s", expected, c)
+	}
+}
+
+func TestQueryResultFields(t *testing.T) {
+	naming.SetOverrides(naming.Overrides{Fields: map[string]string{"users.mail": "Email", "orders.mail": "OrderMail"}})
+	defer naming.SetOverrides(naming.Overrides{})
+
+	tests := []struct {
+		query, field, expected string
+	}{
+		{"SELECT mail FROM users WHERE id = ?", "mail", "Email"},
+		{"SELECT mail FROM `app`.`users` u JOIN users v ON u.id = v.id", "mail", "Email"},
+		{"SELECT u.mail FROM users u JOIN orders o ON o.user_id = u.id", "mail", "Mail"},
+		{"SELECT o.mail FROM users u JOIN orders o ON o.user_id = u.id", "orders.mail", "OrderMail"},
+		{"SELECT 1 AS mail", "mail", "Mail"},
+	}
+	for _, tt := range tests {
+		nq := conf.NamedQuery{Name: "Q", Query: tt.query, Returns: []string{tt.field}, Mode: conf.ResultModeOne}
+		c := GetDBFunctionsQueries([]conf.NamedQuery{nq})
+		if expected := tt.expected + " string\n"; !strings.Contains(c, expected) {
+			t.Errorf("%s: generated code lacks %q:\n%s", tt.query, expected, c)
+		}
+		if expected := "x." + tt.expected + " = *ptr" + tt.expected; !strings.Contains(c, expected) {
+			t.Errorf("%s: generated code lacks %q:\n%s", tt.query, expected, c)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package template
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/rah-0/nabu"
+
+	"github.com/rah-0/margo/conf"
+	"github.com/rah-0/margo/naming"
+	"github.com/rah-0/margo/util"
+)
+
+// Reference is a foreign key the generated entity follows with its DBRef methods.
+type Reference struct {
+	Method     string   // see naming.Reference
+	Fields     []string // Go names of the referencing columns
+	RefFields  []string // Go names of the referenced columns
+	RefTable   string   // `schema`.`table` of the referenced table, for notes
+	Alias      string   // import alias of the referenced table package, empty when a table references itself
+	ImportPath string
+}
+
+// ReferenceKey identifies a table across schemas in the map returned by ResolveReferences.
+func ReferenceKey(schema, table string) string {
+	return schema + "." + table
+}
+
+// ResolveReferences returns the foreign keys of every table keyed by ReferenceKey, including the ones pointing into
+// another schema of the same run. Foreign keys to tables that are not generated are left out, and so are the ones
+// that would make table packages import each other, which Go does not allow; each skipped key gets a note.
+func ResolveReferences(schemas []conf.Schema) (map[string][]Reference, []string, error) {
+	pathModuleOutput, err := util.GetGoModuleImportPath(conf.Args.OutputPath)
+	if err != nil {
+		return nil, nil, nabu.FromError(err).WithArgs(conf.Args.OutputPath).Log()
+	}
+
+	generated := map[string]conf.Table{}
+	for _, s := range schemas {
+		for _, t := range s.Tables {
+			generated[ReferenceKey(s.Name, t.Name)] = t
+		}
+	}
+
+	refs := map[string][]Reference{}
+	imports := map[string][]string{}
+	var notes []string
+	for _, s := range schemas {
+		for _, t := range s.Tables {
+			key := ReferenceKey(s.Name, t.Name)
+			seen := map[string]bool{}
+			for _, fk := range t.ForeignKeys {
+				refSchema := fk.RefSchema
+				if refSchema == "" {
+					refSchema = s.Name
+				}
+				refKey := ReferenceKey(refSchema, fk.RefTable)
+				fkName := "`" + s.Name + "`.`" + t.Name + "` foreign key " + fk.Name
+				if _, ok := generated[refKey]; !ok {
+					notes = append(notes, fmt.Sprintf("%s: `%s`.`%s` is not generated, no %s", fkName, refSchema, fk.RefTable, naming.Reference(t.Name, fk.Columns)))
+					continue
+				}
+
+				r := Reference{
+					Method:   naming.Reference(t.Name, fk.Columns),
+					RefTable: "`" + refSchema + "`.`" + fk.RefTable + "`",
+				}
+				if seen[r.Method] {
+					continue
+				}
+				for _, c := range fk.Columns {
+					r.Fields = append(r.Fields, naming.Field(t.Name, c))
+				}
+				for _, c := range fk.RefColumns {
+					r.RefFields = append(r.RefFields, naming.Field(fk.RefTable, c))
+				}
+
+				if refKey != key {
+					if reaches(imports, refKey, key) {
+						notes = append(notes, fmt.Sprintf("%s: %s already imports this package, no %s", fkName, r.RefTable, r.Method))
+						continue
+					}
+					imports[key] = append(imports[key], refKey)
+					r.Alias = "ref" + naming.Package(refSchema) + naming.Package(fk.RefTable)
+					r.ImportPath = path.Join(pathModuleOutput, naming.Package(refSchema), naming.Package(fk.RefTable))
+				}
+				seen[r.Method] = true
+				refs[key] = append(refs[key], r)
+			}
+		}
+	}
+
+	return refs, notes, nil
+}
+
+// reaches reports whether the package of table from already imports the one of table to, directly or not.
+func reaches(imports map[string][]string, from, to string) bool {
+	visited := map[string]bool{}
+	stack := []string{from}
+	for len(stack) > 0 {
+		k := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if k == to {
+			return true
+		}
+		if visited[k] {
+			continue
+		}
+		visited[k] = true
+		stack = append(stack, imports[k]...)
+	}
+	return false
+}
+
+func GetReferenceFunctions(refs []Reference) string {
+	t := ""
+
+	for _, r := range refs {
+		pkg := ""
+		if r.Alias != "" {
+			pkg = r.Alias + "."
+		}
+
+		var values, empty []string
+		for i, f := range r.Fields {
+			values = append(values, r.RefFields[i]+": x."+f)
+			empty = append(empty, "x."+f+" == \"\"")
+		}
+		var where []string
+		for _, f := range r.RefFields {
+			where = append(where, pkg+"Field"+f)
+		}
+
+		exists := "ref.DBExists(ctx, "
+		if contextAPI() {
+			t += "func (x *Entity) " + r.Method + "(ctx context.Context) *" + pkg + "QueryResult {\n"
+		} else {
+			exists = "ref.DBExistsCtxTx(ctx, tx, "
+			t += "func (x *Entity) " + r.Method + "() *" + pkg + "QueryResult { return x." + r.Method + "CtxTx(nil, nil) }\n"
+			t += "func (x *Entity) " + r.Method + "Ctx(ctx context.Context) *" + pkg + "QueryResult { return x." + r.Method + "CtxTx(ctx, nil) }\n"
+			t += "func (x *Entity) " + r.Method + "Tx(tx *sql.Tx) *" + pkg + "QueryResult { return x." + r.Method + "CtxTx(nil, tx) }\n"
+			t += "func (x *Entity) " + r.Method + "CtxTx(ctx context.Context, tx *sql.Tx) *" + pkg + "QueryResult {\n"
+		}
+		t += "	if " + strings.Join(empty, " || ") + " { return &" + pkg + "QueryResult{} }\n"
+		t += "	ref := &" + pkg + "Entity{" + strings.Join(values, ", ") + "}\n"
+		t += "	res := " + exists + pkg + "NewQueryParams().WithWhere(" + strings.Join(where, ", ") + "))\n"
+		t += "	if res.Exists { res.Entity = ref }\n"
+		t += "	return res\n"
+		t += "}\n\n"
+	}
+
+	return t
+}
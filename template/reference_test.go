@@ -0,0 +1,72 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rah-0/margo/conf"
+)
+
+func TestResolveReferences(t *testing.T) {
+	outputPath := conf.Args.OutputPath
+	conf.Args.OutputPath = t.TempDir()
+	defer func() { conf.Args.OutputPath = outputPath }()
+	if err := os.WriteFile(filepath.Join(conf.Args.OutputPath, "go.mod"), []byte("module example.com/gen\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	schemas := []conf.Schema{
+		{Name: "app", Tables: []conf.Table{{
+			Name: "users",
+			ForeignKeys: []conf.ForeignKey{
+				{Name: "fk_manager", Columns: []string{"manager_id"}, RefTable: "users", RefColumns: []string{"id"}},
+				{Name: "fk_invoice", Columns: []string{"last_invoice_id"}, RefSchema: "billing", RefTable: "invoices", RefColumns: []string{"id"}},
+				{Name: "fk_audit", Columns: []string{"id"}, RefSchema: "audit", RefTable: "log", RefColumns: []string{"id"}},
+			},
+		}}},
+		{Name: "billing", Tables: []conf.Table{{
+			Name: "invoices",
+			ForeignKeys: []conf.ForeignKey{
+				{Name: "fk_user", Columns: []string{"user_id"}, RefSchema: "app", RefTable: "users", RefColumns: []string{"id"}},
+			},
+		}}},
+	}
+
+	refs, notes, err := ResolveReferences(schemas)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users := refs[ReferenceKey("app", "users")]
+	if len(users) != 2 {
+		t.Fatalf("expected 2 references from users, got %+v", users)
+	}
+	if users[0].Method != "DBRefManagerId" || users[0].Alias != "" || users[0].RefFields[0] != "Id" {
+		t.Errorf("unexpected self reference: %+v", users[0])
+	}
+	if users[1].Method != "DBRefLastInvoiceId" || users[1].Alias != "refBillingInvoices" ||
+		users[1].ImportPath != "example.com/gen/Billing/Invoices" {
+		t.Errorf("unexpected cross-schema reference: %+v", users[1])
+	}
+
+	// billing.invoices -> app.users would close an import cycle, audit.log is not generated
+	if len(refs[ReferenceKey("billing", "invoices")]) != 0 {
+		t.Errorf("expected no references from invoices, got %+v", refs[ReferenceKey("billing", "invoices")])
+	}
+	if len(notes) != 2 || !strings.Contains(notes[0], "fk_audit") || !strings.Contains(notes[1], "fk_user") {
+		t.Errorf("unexpected notes: %q", notes)
+	}
+
+	c := GetReferenceFunctions(users[1:])
+	for _, expected := range []string{
+		"func (x *Entity) DBRefLastInvoiceIdCtxTx(ctx context.Context, tx *sql.Tx) *refBillingInvoices.QueryResult {",
+		"ref := &refBillingInvoices.Entity{Id: x.LastInvoiceId}",
+		"WithWhere(refBillingInvoices.FieldId)",
+	} {
+		if !strings.Contains(c, expected) {
+			t.Errorf("generated code lacks %q:\n%s", expected, c)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package template
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rah-0/nabu"
+
+	"github.com/rah-0/margo/conf"
+	"github.com/rah-0/margo/margort"
+	"github.com/rah-0/margo/util"
+)
+
+// RuntimePackage is generated once below the output path with the helpers every schema package shares.
+const RuntimePackage = "margort"
+
+func CreateGoFileRuntime() error {
+	dir := filepath.Join(conf.Args.OutputPath, RuntimePackage)
+	if err := util.EnsureDir(dir, isPreview()); err != nil {
+		return nabu.FromError(err).WithArgs(dir).Log()
+	}
+
+	return util.WriteGoFile(filepath.Join(dir, RuntimePackage+".go"), GetFileContentRuntime(), isPreview())
+}
+
+// GetRuntimeImportPath returns the import path of the runtime package.
+func GetRuntimeImportPath() (string, error) {
+	pathModuleOutput, err := util.GetGoModuleImportPath(conf.Args.OutputPath)
+	if err != nil {
+		return "", nabu.FromError(err).WithArgs(conf.Args.OutputPath).Log()
+	}
+	return path.Join(pathModuleOutput, RuntimePackage), nil
+}
+
+// GetFileContentRuntime returns the runtime package, which is the margort package of margo with the generated header.
+func GetFileContentRuntime() string {
+	return "package " + RuntimePackage + "\n\n" + GetCommentWarning() + strings.TrimPrefix(margort.Source, "package "+RuntimePackage+"\n\n")
+}
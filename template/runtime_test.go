@@ -0,0 +1,22 @@
+package template
+
+import (
+	"go/format"
+	"strings"
+	"testing"
+
+	"github.com/rah-0/margo/margort"
+)
+
+func TestGetFileContentRuntime(t *testing.T) {
+	c := GetFileContentRuntime()
+	if _, err := format.Source([]byte(c)); err != nil {
+		t.Fatalf("runtime package does not parse: %v\n%s", err, c)
+	}
+	if !IsGenerated(c) {
+		t.Error("runtime package lacks the generated header")
+	}
+	if !strings.HasSuffix(c, strings.TrimPrefix(margort.Source, "package margort")) {
+		t.Error("runtime package differs from margort")
+	}
+}
@@ -0,0 +1,92 @@
+package template
+
+import (
+	"strconv"
+
+	"github.com/rah-0/margo/conf"
+)
+
+// SoftDeleteField returns the column DBDelete sets instead of deleting the row, nil when the table has none. A bare
+// column only applies to the tables where it is a nullable timestamp or datetime.
+func SoftDeleteField(rawTableName string, tfs []conf.TableField) (*conf.TableField, error) {
+	return configuredField(rawTableName, tfs, conf.Args.SoftDeleteColumns, func(tf conf.TableField) bool {
+		return isTimestamp(tf) && tf.Nullable
+	}, "soft delete column must be a nullable timestamp or datetime")
+}
+
+// GetSoftDeleteFunctions returns the helper of the read and update cores for the soft delete column sf.
+func GetSoftDeleteFunctions(sf *conf.TableField) string {
+	f := "Field" + sf.GoName
+	t := "// filterDeleted appends the condition on " + f + " of params.Deleted to q, whose WHERE clause already has\n"
+	t += "// conditions when where is set.\n"
+	t += "func filterDeleted(tbl, q string, where bool, params *QueryParams) string {\n"
+	t += "	var deleted margort.Deleted\n"
+	t += "	if params != nil {\n"
+	t += "		deleted = params.Deleted\n"
+	t += "	}\n"
+	t += "	var cond string\n"
+	t += "	switch deleted {\n"
+	t += "	case margort.IncludeDeleted:\n"
+	t += "		return q\n"
+	t += "	case margort.OnlyDeleted:\n"
+	t += "		cond = qualifiedField(tbl, " + f + ") + \" IS NOT NULL\"\n"
+	t += "	default:\n"
+	t += "		cond = qualifiedField(tbl, " + f + ") + \" IS NULL\"\n"
+	t += "	}\n"
+	t += "	if where {\n"
+	t += "		return q + \" AND \" + cond\n"
+	t += "	}\n"
+	t += "	return q + \" WHERE \" + cond\n"
+	t += "}\n\n"
+	return t
+}
+
+// getSoftDeleteCores returns DBDelete, which sets the soft delete column sf of the rows not deleted yet, DBHardDelete,
+// which deletes them, and DBRestore, which clears sf again.
+func getSoftDeleteCores(sf *conf.TableField) string {
+	f := "Field" + sf.GoName
+	t := getSoftDeleteCore("delete", "DBDelete", f, " = "+softDeleteNow(sf), " IS NULL")
+	t += getHookedCore("DBDelete", "delete", "Delete")
+
+	t += getDeleteCore("hardDelete", "DBHardDelete")
+	t += getHookedCore("DBHardDelete", "hardDelete", "Delete")
+
+	t += getSoftDeleteCore("restore", "DBRestore", f, " = NULL", " IS NOT NULL")
+	t += getDBWrappers("DBRestore", "restore", true, true)
+	return t
+}
+
+// softDeleteNow returns the SQL time DBDelete sets the soft delete column sf to.
+func softDeleteNow(sf *conf.TableField) string {
+	now := "CURRENT_TIMESTAMP"
+	if p := timestampPrecision(*sf); p > 0 {
+		now += "(" + strconv.Itoa(p) + ")"
+	}
+	return now
+}
+
+func getSoftDeleteCore(core, op, f, set, cond string) string {
+	t := "func (r *Repo) " + core + "(ctx context.Context, tx *sql.Tx, x *Entity, params *QueryParams) *QueryResult {\n"
+	t += "	tbl := r.fqtn(ctx)\n"
+	t += getSoftDeleteReturningCheck(op)
+	t += "	whereFields := Fields\n"
+	t += "	if params != nil && len(params.Where) > 0 { whereFields = params.Where }\n"
+	t += "	whereFields = withoutField(whereFields, " + f + ")\n"
+	t += "	if len(whereFields) == 0 {\n"
+	t += "		return &QueryResult{Error: margort.WrapError(errors.New(\"params.Where must name a field other than \"+" + f + "), tbl, \"" + op + "\")}\n"
+	t += "	}\n"
+	t += "	q := \"UPDATE \" + tbl + \" SET \" + qualifiedField(tbl, " + f + ") + \"" + set + " WHERE \" + strings.Join(qualifiedFields(tbl, whereFields), \" = ? AND \") + \" = ? AND \" + qualifiedField(tbl, " + f + ") + \"" + cond + "\"\n"
+	t += "	res, err := r.execCore(ctx, tx, q, x.GetFieldsValues(whereFields)...)\n"
+	t += "	return &QueryResult{Result: res, Error: margort.WrapError(err, tbl, \"" + op + "\")}\n"
+	t += "}\n\n"
+	return t
+}
+
+// getSoftDeleteReturningCheck returns the check of the soft delete cores that fails on params.Returning, as
+// MariaDB has no UPDATE ... RETURNING.
+func getSoftDeleteReturningCheck(op string) string {
+	t := "	if params != nil && params.Returning {\n"
+	t += "		return &QueryResult{Error: margort.WrapError(errors.New(\"" + op + " cannot return rows\"), tbl, \"" + op + "\")}\n"
+	t += "	}\n"
+	return t
+}
@@ -0,0 +1,89 @@
+package template
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rah-0/margo/conf"
+)
+
+func TestSoftDeleteField(t *testing.T) {
+	defer func(v []string) { conf.Args.SoftDeleteColumns = v }(conf.Args.SoftDeleteColumns)
+
+	tfs := []conf.TableField{
+		{Name: "id", DataType: "int", ColumnType: "int(11)"},
+		{Name: "deleted_at", DataType: "timestamp", ColumnType: "timestamp(6)", Nullable: true},
+		{Name: "removed", DataType: "datetime", ColumnType: "datetime"},
+		{Name: "archived", DataType: "tinyint", ColumnType: "tinyint(1)", Nullable: true},
+	}
+
+	tests := []struct {
+		columns  []string
+		expected string
+		fails    bool
+	}{
+		{nil, "", false},
+		{[]string{"deleted_at"}, "deleted_at", false},
+		{[]string{"removed"}, "", false},
+		{[]string{"archived"}, "", false},
+		{[]string{"deleted_at", "orders.removed"}, "deleted_at", false},
+		{[]string{"users.removed"}, "", true},
+		{[]string{"users.gone"}, "", true},
+	}
+	for _, tt := range tests {
+		conf.Args.SoftDeleteColumns = tt.columns
+		sf, err := SoftDeleteField("users", tfs)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("%v: expected an error", tt.columns)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.columns, err)
+			continue
+		}
+		name := ""
+		if sf != nil {
+			name = sf.Name
+		}
+		if name != tt.expected {
+			t.Errorf("%v: got %q, expected %q", tt.columns, name, tt.expected)
+		}
+	}
+}
+
+func TestGetSoftDeleteCore(t *testing.T) {
+	c := getSoftDeleteCore("delete", "DBDelete", "FieldDeletedAt", " = CURRENT_TIMESTAMP", " IS NULL")
+	for _, expected := range []string{
+		"whereFields = withoutField(whereFields, FieldDeletedAt)",
+		"return &QueryResult{Error: margort.WrapError(errors.New(\"params.Where must name a field other than \"+FieldDeletedAt), tbl, \"DBDelete\")}",
+		"\" = CURRENT_TIMESTAMP WHERE \" + strings.Join(qualifiedFields(tbl, whereFields), \" = ? AND \") + \" = ? AND \" + qualifiedField(tbl, FieldDeletedAt) + \" IS NULL\"",
+	} {
+		if !strings.Contains(c, expected) {
+			t.Errorf("generated code lacks %q:\n%s", expected, c)
+		}
+	}
+	// a Where naming only the soft delete column fails before the statement runs
+	if check, exec := strings.Index(c, "if len(whereFields) == 0 {"), strings.Index(c, "r.execCore("); check < 0 || check > exec {
+		t.Errorf("the where fields are not checked first:\n%s", c)
+	}
+}
+
+func TestSoftDeleteUpdates(t *testing.T) {
+	sf := conf.TableField{Name: "deleted_at", GoName: "DeletedAt", DataType: "timestamp", Nullable: true}
+	vf := conf.TableField{Name: "version", GoName: "Version", DataType: "bigint"}
+	for _, mf := range []ManagedFields{{SoftDelete: &sf}, {SoftDelete: &sf, Version: &vf}} {
+		c := GetDBFunctions(mf)
+		for _, core := range []string{"update", "updateWhere"} {
+			start := strings.Index(c, "func (r *Repo) "+core+"(")
+			body := c[start : start+strings.Index(c[start:], "\n}\n")]
+			if !strings.Contains(body, "q = filterDeleted(tbl, q, true, params)") {
+				t.Errorf("%s does not skip the soft deleted rows:\n%s", core, body)
+			}
+		}
+	}
+	if c := GetSoftDeleteFunctions(&sf); !strings.Contains(c, "deleted = params.Deleted") || strings.Contains(c, "ctx") {
+		t.Errorf("the deleted rows are not selected by params:\n%s", c)
+	}
+}
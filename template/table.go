@@ -6,14 +6,14 @@ import (
 	"github.com/rah-0/nabu"
 
 	"github.com/rah-0/margo/conf"
-	"github.com/rah-0/margo/db"
+	"github.com/rah-0/margo/naming"
 	"github.com/rah-0/margo/util"
 )
 
 func PathCreateTableDirs(tableNames []string) error {
 	for _, tableName := range tableNames {
-		p := filepath.Join(conf.Args.OutputPath, db.NormalizeString(conf.Args.DBName), db.NormalizeString(tableName))
-		if err := util.EnsureDir(p); err != nil {
+		p := filepath.Join(conf.Args.OutputPath, naming.Package(conf.Args.DBName), naming.Package(tableName))
+		if err := util.EnsureDir(p, isPreview()); err != nil {
 			return nabu.FromError(err).WithArgs(p).Log()
 		}
 	}
@@ -2,39 +2,79 @@ package template
 
 import (
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/rah-0/nabu"
 
 	"github.com/rah-0/margo/conf"
-	"github.com/rah-0/margo/db"
+	"github.com/rah-0/margo/naming"
 	"github.com/rah-0/margo/util"
 )
 
-func CreateGoFileEntity(rawTableName string, tfs []conf.TableField, nqs []conf.NamedQuery) error {
-	p := filepath.Join(conf.Args.OutputPath, db.NormalizeString(conf.Args.DBName), db.NormalizeString(rawTableName), "entity.go")
-	c, err := GetFileContentEntity(rawTableName, tfs, nqs)
+func CreateGoFileEntity(rawTableName string, tfs []conf.TableField, nqs []conf.NamedQuery, refs []Reference) error {
+	p := filepath.Join(conf.Args.OutputPath, naming.Package(conf.Args.DBName), naming.Package(rawTableName), "entity.go")
+	c, err := GetFileContentEntity(rawTableName, tfs, nqs, refs)
 	if err != nil {
 		return nabu.FromError(err).WithArgs(rawTableName).Log()
 	}
 
-	return util.WriteGoFile(p, c)
+	return util.WriteGoFile(p, c, isPreview())
 }
 
-func GetFileContentEntity(rawTableName string, tfs []conf.TableField, nqs []conf.NamedQuery) (string, error) {
-	t := "package " + db.NormalizeString(rawTableName) + "\n\n"
+func GetFileContentEntity(rawTableName string, tfs []conf.TableField, nqs []conf.NamedQuery, refs []Reference) (string, error) {
+	tfs, err := naming.ResolveFields(rawTableName, tfs)
+	if err != nil {
+		return "", nabu.FromError(err).WithArgs(rawTableName).Log()
+	}
+
+	pathRuntime, err := GetRuntimeImportPath()
+	if err != nil {
+		return "", nabu.FromError(err).WithArgs(rawTableName).Log()
+	}
+
+	mf, err := ResolveManagedFields(rawTableName, tfs)
+	if err != nil {
+		return "", nabu.FromError(err).WithArgs(rawTableName).Log()
+	}
+
+	t := "package " + naming.Package(rawTableName) + "\n\n"
 	t += GetCommentWarning()
-	t += GetImports(nqs)
+	t += GetImports(pathRuntime, nqs, refs, mf)
 	t += GetConsts(rawTableName, tfs)
 	t += GetVars(tfs, nqs)
-	t += GetStruct(tfs)
-	t += GetGeneralFunctions(tfs, nqs)
-	t += GetDBFunctions()
+	t += GetStruct(rawTableName, tfs)
+	t += GetGeneralFunctions(tfs)
+	t += GetInsertFunctions(mf)
+	if mf.Version != nil {
+		t += GetVersionFunctions(mf.Version)
+	}
+	if mf.SoftDelete != nil {
+		t += GetSoftDeleteFunctions(mf.SoftDelete)
+	}
+	if mf.Version != nil || mf.SoftDelete != nil || mf.Updated != nil {
+		t += GetWithoutFieldFunction()
+	}
+	t += GetDBFunctions(mf)
 	t += GetNamedQueryFunctions(nqs)
+	t += GetReferenceFunctions(refs)
 
 	return t, nil
 }
 
+// defaultSchema is the schema the generated FQTN constants are qualified with, empty with -omitSchema.
+func defaultSchema() string {
+	if conf.Args.OmitSchema {
+		return ""
+	}
+	return conf.Args.DBName
+}
+
+// contextAPI reports whether every operation is generated once, taking a context that may carry the transaction.
+func contextAPI() bool {
+	return conf.Args.API == conf.APIContext
+}
+
 func GetCommentWarning() string {
 	return `// ---------------------------------------------------------------
 // The code in this file is autogenerated, do not modify manually!
@@ -43,25 +83,44 @@ func GetCommentWarning() string {
 `
 }
 
-func GetImports(nqs []conf.NamedQuery) string {
+func GetImports(pathRuntime string, nqs []conf.NamedQuery, refs []Reference, mf ManagedFields) string {
+	vf := mf.Version
 	imports := "import (\n"
 	imports += `"context"` + "\n"
 	imports += `"database/sql"` + "\n"
-	if len(nqs) > 0 {
-		imports += `"encoding/base64"` + "\n"
-	}
 	imports += `"errors"` + "\n"
+	if vf != nil && isCounter(*vf) || mf.AutoIncrement != nil {
+		imports += `"strconv"` + "\n"
+	}
 	imports += `"strings"` + "\n"
 	imports += `"sync"` + "\n"
+	if vf != nil && isTimestamp(*vf) {
+		imports += `"time"` + "\n"
+	}
+	imports += "\n"
+	imports += `"` + pathRuntime + `"` + "\n"
+	seen := map[string]bool{}
+	for _, r := range refs {
+		if r.Alias != "" && !seen[r.Alias] {
+			seen[r.Alias] = true
+			imports += r.Alias + ` "` + r.ImportPath + `"` + "\n"
+		}
+	}
 	imports += ")\n\n"
 	return imports
 }
 
 func GetConsts(rawTableName string, tfs []conf.TableField) string {
 	t := "const (\n"
-	t += `FQTN = "` + "`" + conf.Args.DBName + "`.`" + rawTableName + "`" + `"` + "\n"
+	t += `SchemaName = "` + conf.Args.DBName + `"` + "\n"
+	t += `TableName = "` + rawTableName + `"` + "\n"
+	if conf.Args.OmitSchema {
+		t += `FQTN = "` + "`" + rawTableName + "`" + `"` + "\n"
+	} else {
+		t += `FQTN = "` + "`" + conf.Args.DBName + "`.`" + rawTableName + "`" + `"` + "\n"
+	}
 	for _, tf := range tfs {
-		t += "Field" + db.NormalizeString(tf.Name) + " = " + `"` + tf.Name + `"` + "\n"
+		t += "Field" + tf.GoName + " = " + `"` + tf.Name + `"` + "\n"
 	}
 	t += ")\n\n"
 	return t
@@ -70,18 +129,16 @@ func GetConsts(rawTableName string, tfs []conf.TableField) string {
 func GetVars(tfs []conf.TableField, nqs []conf.NamedQuery) string {
 	var fieldList []string
 	for _, tf := range tfs {
-		fieldList = append(fieldList, "Field"+db.NormalizeString(tf.Name))
+		fieldList = append(fieldList, "Field"+tf.GoName)
 	}
 
 	t := "var (\n"
 	t += "Fields = []string{" + strings.Join(fieldList, ",") + "}\n"
-	t += "db *sql.DB\n"
-	t += "stmtMu sync.RWMutex\n"
-	t += "stmtCache = make(map[string]*sql.Stmt)\n"
+	t += "defaultRepo = NewRepo(nil)\n"
 	if len(nqs) > 0 {
 		t += "queries = map[string]*NamedQuery{\n"
 		for _, nq := range nqs {
-			t += `"` + nq.Name + `": {QueryEncoded: "` + nq.QueryEncoded + `"},` + "\n"
+			t += `"` + nq.Name + `": {Query: ` + strconv.Quote(nq.Query) + `, QueryEncoded: "` + nq.QueryEncoded + `"},` + "\n"
 		}
 		t += "}\n"
 	}
@@ -98,12 +155,17 @@ func GetVars(tfs []conf.TableField, nqs []conf.NamedQuery) string {
 	return t
 }
 
-func GetStruct(tfs []conf.TableField) string {
-	t := "type Entity struct {\n"
+func GetStruct(rawTableName string, tfs []conf.TableField) string {
+	entity := naming.Entity(rawTableName)
+	t := "type " + entity + " struct {\n"
 	for _, tf := range tfs {
-		t += db.NormalizeString(tf.Name) + " string `json:\",omitempty,omitzero\"`\n"
+		t += tf.GoName + " string `json:\",omitempty,omitzero\"`\n"
 	}
 	t += "}\n\n"
+	if entity != naming.DefaultEntityName {
+		// generated functions keep referring to Entity
+		t += "type Entity = " + entity + "\n\n"
+	}
 
 	// QueryParams struct with builder methods
 	t += "type QueryParams struct {\n"
@@ -112,6 +174,10 @@ func GetStruct(tfs []conf.TableField) string {
 	t += "	Insert []string\n"
 	t += "	Update []string\n"
 	t += "	Params []any\n"
+	t += "	Conds  []margort.Cond       // conditions of DBDeleteWhere and DBUpdateWhere, joined with AND\n"
+	t += "	Set    []margort.Assignment // values DBUpdateWhere assigns\n"
+	t += "	Returning bool              // DELETE returns the deleted rows in QueryResult.Entities\n"
+	t += "	Deleted margort.Deleted     // soft deleted rows DBSelect, DBExists, DBUpdate and DBUpdateWhere see\n"
 	t += "}\n\n"
 
 	t += "func NewQueryParams() *QueryParams {\n"
@@ -143,6 +209,26 @@ func GetStruct(tfs []conf.TableField) string {
 	t += "	return qp\n"
 	t += "}\n\n"
 
+	t += "func (qp *QueryParams) WithConds(conds ...margort.Cond) *QueryParams {\n"
+	t += "	qp.Conds = conds\n"
+	t += "	return qp\n"
+	t += "}\n\n"
+
+	t += "func (qp *QueryParams) WithSet(values ...margort.Assignment) *QueryParams {\n"
+	t += "	qp.Set = values\n"
+	t += "	return qp\n"
+	t += "}\n\n"
+
+	t += "func (qp *QueryParams) WithReturning() *QueryParams {\n"
+	t += "	qp.Returning = true\n"
+	t += "	return qp\n"
+	t += "}\n\n"
+
+	t += "func (qp *QueryParams) WithDeleted(d margort.Deleted) *QueryParams {\n"
+	t += "	qp.Deleted = d\n"
+	t += "	return qp\n"
+	t += "}\n\n"
+
 	// QueryResult struct
 	t += "type QueryResult struct {\n"
 	t += "	Entities []*Entity\n"
@@ -155,25 +241,256 @@ func GetStruct(tfs []conf.TableField) string {
 	return t
 }
 
-func GetGeneralFunctions(tfs []conf.TableField, nqs []conf.NamedQuery) string {
-	t := "func SetDB(x *sql.DB) error {\n"
-	t += "	db = x\n"
-	if len(nqs) > 0 {
-		t += "    for _, q := range queries {\n"
-		t += "        b, err := base64.StdEncoding.DecodeString(q.QueryEncoded)\n"
-		t += "        if err != nil {\n"
-		t += "            return err\n"
-		t += "        }\n"
-		t += "        q.Query = string(b)\n"
-		t += "    }\n"
+// GetRepo returns the Repo type with its constructors and prepared statement cache. Only the Repo of a table
+// package has a schema, the general queries are not qualified.
+func GetRepo(withSchema bool) string {
+	t := "// Repo runs queries on a DBTX, e.g. a *sql.DB, *sql.Conn or *sql.Tx, with its own prepared statement cache.\n"
+	t += "// Reads outside a transaction go to the replicas of the Repo, if any. The package-level functions use the Repo\n"
+	t += "// set by SetDB.\n"
+	t += "type Repo struct {\n"
+	t += "	db        margort.DBTX\n"
+	if withSchema {
+		t += "	schema    string\n"
+	}
+	t += "	mu        sync.RWMutex\n"
+	t += "	stmtCache map[string]*sql.Stmt\n"
+	t += "	replicas  []*Repo\n"
+	t += "	pick      margort.Picker\n"
+	t += "	txRetries int\n"
+	t += "}\n\n"
+
+	if withSchema {
+		t += "func NewRepo(db margort.DBTX) *Repo {\n"
+		t += "	return NewRepoSchema(db, " + strconv.Quote(defaultSchema()) + ")\n"
+		t += "}\n\n"
+
+		t += "// NewRepoSchema returns a Repo that queries the table in schema s, unqualified when s is empty.\n"
+		t += "func NewRepoSchema(db margort.DBTX, s string) *Repo {\n"
+		t += "	return &Repo{db: db, schema: s, stmtCache: make(map[string]*sql.Stmt), pick: margort.RoundRobin(), txRetries: 3}\n"
+		t += "}\n\n"
+	} else {
+		t += "func NewRepo(db margort.DBTX) *Repo {\n"
+		t += "	return &Repo{db: db, stmtCache: make(map[string]*sql.Stmt), pick: margort.RoundRobin(), txRetries: 3}\n"
+		t += "}\n\n"
 	}
-	t += "    return nil\n"
+
+	t += "// WithReplicas sends the reads of the Repo to replicas, unless they are part of a transaction or the context\n"
+	t += "// comes from margort.WithPrimary. It replaces the previous replicas and returns r.\n"
+	t += "func (r *Repo) WithReplicas(replicas ...margort.DBTX) *Repo {\n"
+	t += "	r.mu.Lock()\n"
+	t += "	defer r.mu.Unlock()\n"
+	t += "	r.replicas = nil\n"
+	t += "	for _, x := range replicas {\n"
+	t += "		r.replicas = append(r.replicas, NewRepo(x))\n"
+	t += "	}\n"
+	t += "	return r\n"
+	t += "}\n\n"
+
+	t += "// WithPicker chooses the replica of each read with p instead of round-robin. It returns r.\n"
+	t += "func (r *Repo) WithPicker(p margort.Picker) *Repo {\n"
+	t += "	if p == nil {\n"
+	t += "		p = margort.RoundRobin()\n"
+	t += "	}\n"
+	t += "	r.mu.Lock()\n"
+	t += "	defer r.mu.Unlock()\n"
+	t += "	r.pick = p\n"
+	t += "	return r\n"
+	t += "}\n\n"
+
+	t += "// setDB replaces the primary and the replicas of the Repo and drops the statements prepared on the previous ones.\n"
+	if withSchema {
+		t += "// The schema, if given, replaces the schema of the Repo as well.\n"
+		t += "func (r *Repo) setDB(x *sql.DB, replicas []*sql.DB, schema ...string) {\n"
+	} else {
+		t += "func (r *Repo) setDB(x *sql.DB, replicas []*sql.DB) {\n"
+	}
+	t += "	r.mu.Lock()\n"
+	t += "	defer r.mu.Unlock()\n"
+	if withSchema {
+		t += "	if len(schema) > 0 {\n"
+		t += "		r.schema = schema[0]\n"
+		t += "	}\n"
+	}
+	t += "	r.db = nil\n"
+	t += "	if x != nil {\n"
+	t += "		r.db = x\n"
+	t += "	}\n"
+	t += "	r.stmtCache = make(map[string]*sql.Stmt)\n"
+	t += "	r.replicas = nil\n"
+	t += "	for _, x := range replicas {\n"
+	t += "		if x != nil {\n"
+	t += "			r.replicas = append(r.replicas, NewRepo(x))\n"
+	t += "		}\n"
+	t += "	}\n"
+	t += "}\n\n"
+
+	t += "// WithTxRetries sets how many times WithTx runs a transaction again after a deadlock or lock wait timeout,\n"
+	t += "// 3 by default and 0 to never retry. It returns r.\n"
+	t += "func (r *Repo) WithTxRetries(n int) *Repo {\n"
+	t += "	r.mu.Lock()\n"
+	t += "	defer r.mu.Unlock()\n"
+	t += "	r.txRetries = n\n"
+	t += "	return r\n"
+	t += "}\n\n"
+
+	t += "// WithTx runs fn in a transaction on the primary of the Repo, committed when fn returns nil and rolled back when\n"
+	t += "// it returns an error or panics. Deadlocks and lock wait timeouts run fn again, see WithTxRetries, so fn must not\n"
+	t += "// have side effects outside tx. Inside a transaction ctx carries for the same database, fn runs in a savepoint\n"
+	t += "// instead, see WithTxContext.\n"
+	t += "func (r *Repo) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *sql.Tx) error) error {\n"
+	t += "	return r.WithTxContext(ctx, opts, func(_ context.Context, tx *sql.Tx) error { return fn(tx) })\n"
+	t += "}\n\n"
+
+	t += "// WithTxContext is WithTx passing fn a context that carries the transaction, so that the queries and the WithTx or\n"
+	t += "// WithTxContext of every Repo on the same database called with it run in the transaction, the latter in a\n"
+	t += "// savepoint: an error rolls back to the savepoint and leaves the outer transaction open.\n"
+	t += "func (r *Repo) WithTxContext(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context, tx *sql.Tx) error) error {\n"
+	t += "	db, err := r.beginner()\n"
+	t += "	if err != nil {\n"
+	t += "		return err\n"
+	t += "	}\n"
+	t += "	r.mu.RLock()\n"
+	t += "	retries := r.txRetries\n"
+	t += "	r.mu.RUnlock()\n"
+	t += "	return margort.RunTx(ctx, db, opts, retries, fn)\n"
+	t += "}\n\n"
+
+	t += "// BeginTx begins a transaction on the primary of the Repo.\n"
+	t += "func (r *Repo) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {\n"
+	t += "	db, err := r.beginner()\n"
+	t += "	if err != nil {\n"
+	t += "		return nil, err\n"
+	t += "	}\n"
+	t += "	if ctx == nil {\n"
+	t += "		ctx = context.Background()\n"
+	t += "	}\n"
+	t += "	return db.BeginTx(ctx, opts)\n"
+	t += "}\n\n"
+
+	t += "func (r *Repo) beginner() (margort.Beginner, error) {\n"
+	t += "	r.mu.RLock()\n"
+	t += "	defer r.mu.RUnlock()\n"
+	t += "	if r.db == nil {\n"
+	t += "		return nil, errors.New(\"db not initialized\")\n"
+	t += "	}\n"
+	t += "	db, ok := r.db.(margort.Beginner)\n"
+	t += "	if !ok {\n"
+	t += "		return nil, errors.New(\"db cannot begin a transaction\")\n"
+	t += "	}\n"
+	t += "	return db, nil\n"
+	t += "}\n\n"
+
+	t += "// primary returns the database the Repo writes to.\n"
+	t += "func (r *Repo) primary() margort.DBTX {\n"
+	t += "	r.mu.RLock()\n"
+	t += "	defer r.mu.RUnlock()\n"
+	t += "	return r.db\n"
+	t += "}\n\n"
+
+	t += "// txFor returns the transaction a query of the Repo runs in: tx, or else the one ctx carries for its primary.\n"
+	t += "func (r *Repo) txFor(ctx context.Context, tx *sql.Tx) *sql.Tx {\n"
+	t += "	if tx == nil {\n"
+	t += "		tx, _ = margort.TxFor(ctx, r.primary())\n"
+	t += "	}\n"
+	t += "	return tx\n"
+	t += "}\n\n"
+
+	t += "// bind binds base to the transaction the query runs in, see txFor. The bound statement must be closed.\n"
+	t += "func (r *Repo) bind(ctx context.Context, tx *sql.Tx, base *sql.Stmt) (*sql.Stmt, bool) {\n"
+	t += "	tx = r.txFor(ctx, tx)\n"
+	t += "	if tx == nil {\n"
+	t += "		return base, false\n"
+	t += "	}\n"
+	t += "	if ctx != nil {\n"
+	t += "		return tx.StmtContext(ctx, base), true\n"
+	t += "	}\n"
+	t += "	return tx.Stmt(base), true\n"
+	t += "}\n\n"
+
+	t += "// reader returns the Repo a read runs on.\n"
+	t += "func (r *Repo) reader(ctx context.Context, tx *sql.Tx) *Repo {\n"
+	t += "	if r.txFor(ctx, tx) != nil || margort.UsePrimary(ctx) {\n"
+	t += "		return r\n"
+	t += "	}\n"
+	t += "	r.mu.RLock()\n"
+	t += "	defer r.mu.RUnlock()\n"
+	t += "	n := len(r.replicas)\n"
+	t += "	if n == 0 {\n"
+	t += "		return r\n"
+	t += "	}\n"
+	t += "	i := r.pick(ctx, n)\n"
+	t += "	if i < 0 || i >= n {\n"
+	t += "		return r\n"
+	t += "	}\n"
+	t += "	return r.replicas[i]\n"
+	t += "}\n\n"
+
+	t += "func (r *Repo) prepare(ctx context.Context, query string) (*sql.Stmt, error) {\n"
+	t += "	r.mu.RLock()\n"
+	t += "	if stmt, ok := r.stmtCache[query]; ok {\n"
+	t += "		r.mu.RUnlock()\n"
+	t += "		return stmt, nil\n"
+	t += "	}\n"
+	t += "	r.mu.RUnlock()\n\n"
+	t += "	r.mu.Lock()\n"
+	t += "	defer r.mu.Unlock()\n"
+	t += "	if stmt, ok := r.stmtCache[query]; ok {\n"
+	t += "		return stmt, nil\n"
+	t += "	}\n"
+	t += "	if r.db == nil {\n"
+	t += "		return nil, errors.New(\"db not initialized\")\n"
+	t += "	}\n"
+	t += "	if ctx == nil {\n"
+	t += "		ctx = context.Background()\n"
+	t += "	}\n"
+	t += "	stmt, err := r.db.PrepareContext(ctx, query)\n"
+	t += "	if err != nil {\n"
+	t += "		return nil, err\n"
+	t += "	}\n"
+	t += "	r.stmtCache[query] = stmt\n"
+	t += "	return stmt, nil\n"
+	t += "}\n\n"
+
+	return t
+}
+
+func GetGeneralFunctions(tfs []conf.TableField) string {
+	t := GetRepo(true)
+
+	t += "// SetDB sets the primary database of the package-level functions and the replicas their reads go to.\n"
+	t += "func SetDB(x *sql.DB, replicas ...*sql.DB) error {\n"
+	t += "	defaultRepo.setDB(x, replicas)\n"
+	t += "	return nil\n"
+	t += "}\n\n"
+
+	t += "func SetDBSchema(x *sql.DB, s string, replicas ...*sql.DB) error {\n"
+	t += "	defaultRepo.setDB(x, replicas, s)\n"
+	t += "	return nil\n"
+	t += "}\n\n"
+
+	t += "// SetReplicaPicker chooses the replica of each read made through the package-level functions with p instead of\n"
+	t += "// round-robin.\n"
+	t += "func SetReplicaPicker(p margort.Picker) {\n"
+	t += "	defaultRepo.WithPicker(p)\n"
+	t += "}\n\n"
+
+	t += "func (r *Repo) fqtn(ctx context.Context) string {\n"
+	t += "	r.mu.RLock()\n"
+	t += "	s := r.schema\n"
+	t += "	r.mu.RUnlock()\n"
+	t += "	if v, ok := margort.Schema(ctx, SchemaName); ok {\n"
+	t += "		s = v\n"
+	t += "	}\n"
+	t += "	if s == " + strconv.Quote(defaultSchema()) + " {\n"
+	t += "		return FQTN\n"
+	t += "	}\n"
+	t += "	return margort.Qualify(s, TableName)\n"
 	t += "}\n\n"
 
 	t += "func (x *Entity) GetFieldValue(field string) any {\n"
 	t += "	switch field {\n"
 	for _, tf := range tfs {
-		tfn := db.NormalizeString(tf.Name)
+		tfn := tf.GoName
 		t += "	case Field" + tfn + ":\n"
 		t += "		return x." + tfn + "\n"
 	}
@@ -192,7 +509,7 @@ func GetGeneralFunctions(tfs []conf.TableField, nqs []conf.NamedQuery) string {
 	t += "func GetValuePlaceholder(field string) string {\n"
 	t += "	switch field {\n"
 	for _, tf := range tfs {
-		tfn := db.NormalizeString(tf.Name)
+		tfn := tf.GoName
 		t += "	case Field" + tfn + ":\n"
 		t += "		return \"?\"\n"
 	}
@@ -208,76 +525,61 @@ func GetGeneralFunctions(tfs []conf.TableField, nqs []conf.NamedQuery) string {
 	t += "	return placeholders\n"
 	t += "}\n\n"
 
-	t += "func GetQualifiedField(field string) string {\n"
+	t += "func GetQualifiedField(field string) string { return qualifiedField(defaultRepo.fqtn(nil), field) }\n"
+	t += "func GetQualifiedFields(fieldList []string) []string { return qualifiedFields(defaultRepo.fqtn(nil), fieldList) }\n"
+	t += "func GetQualifiedPlaceholder(field string) string { return qualifiedPlaceholder(defaultRepo.fqtn(nil), field) }\n"
+	t += "func GetQualifiedPlaceholders(fieldList []string) []string { return qualifiedPlaceholders(defaultRepo.fqtn(nil), fieldList) }\n\n"
+
+	t += "func qualifiedField(tbl, field string) string {\n"
 	t += "	switch field {\n"
 	for _, tf := range tfs {
-		tfn := db.NormalizeString(tf.Name)
+		tfn := tf.GoName
 		t += "	case Field" + tfn + ":\n"
-		t += "		return FQTN + \".`\" + Field" + tfn + " + \"`\"\n"
+		t += "		return tbl + \".`\" + Field" + tfn + " + \"`\"\n"
 	}
 	t += "	}\n"
 	t += "	return \"\"\n"
 	t += "}\n\n"
 
-	t += "func GetQualifiedFields(fieldList []string) []string {\n"
+	t += "func qualifiedFields(tbl string, fieldList []string) []string {\n"
 	t += "	fields := make([]string, 0, len(fieldList))\n"
 	t += "	for _, field := range fieldList {\n"
-	t += "		fields = append(fields, GetQualifiedField(field))\n"
+	t += "		fields = append(fields, qualifiedField(tbl, field))\n"
 	t += "	}\n"
 	t += "	return fields\n"
 	t += "}\n\n"
 
-	t += "func GetQualifiedPlaceholder(field string) string {\n"
+	t += "func qualifiedPlaceholder(tbl, field string) string {\n"
 	t += "	switch field {\n"
 	for _, tf := range tfs {
-		tfn := db.NormalizeString(tf.Name)
+		tfn := tf.GoName
 		t += "	case Field" + tfn + ":\n"
-		t += "		return FQTN + \".`\" + Field" + tfn + " + \"` = ?\"\n"
+		t += "		return tbl + \".`\" + Field" + tfn + " + \"` = ?\"\n"
 	}
 	t += "	}\n"
 	t += "	return \"\"\n"
 	t += "}\n\n"
 
-	t += "func GetQualifiedPlaceholders(fieldList []string) []string {\n"
+	t += "func qualifiedPlaceholders(tbl string, fieldList []string) []string {\n"
 	t += "	placeholders := make([]string, 0, len(fieldList))\n"
 	t += "	for _, field := range fieldList {\n"
-	t += "		placeholders = append(placeholders, GetQualifiedPlaceholder(field))\n"
+	t += "		placeholders = append(placeholders, qualifiedPlaceholder(tbl, field))\n"
 	t += "	}\n"
 	t += "	return placeholders\n"
 	t += "}\n\n"
 
-	t += "func getPreparedStmt(query string) (*sql.Stmt, error) {\n"
-	t += "	stmtMu.RLock()\n"
-	t += "	if stmt, ok := stmtCache[query]; ok {\n"
-	t += "		stmtMu.RUnlock()\n"
-	t += "		return stmt, nil\n"
-	t += "	}\n"
-	t += "	stmtMu.RUnlock()\n\n"
-	t += "	stmtMu.Lock()\n"
-	t += "	defer stmtMu.Unlock()\n"
-	t += "	if stmt, ok := stmtCache[query]; ok {\n"
-	t += "		return stmt, nil\n"
-	t += "	}\n"
-	t += "	stmt, err := db.Prepare(query)\n"
-	t += "	if err != nil {\n"
-	t += "		return nil, err\n"
-	t += "	}\n"
-	t += "	stmtCache[query] = stmt\n"
-	t += "	return stmt, nil\n"
-	t += "}\n\n"
-
 	t += "func scanRow(fields []string, rows *sql.Rows) (*Entity, error) {\n"
 	t += "	x := &Entity{}\n"
 	t += "	var (\n"
 	for _, tf := range tfs {
-		t += "		ptr" + db.NormalizeString(tf.Name) + " *string\n"
+		t += "		ptr" + tf.GoName + " *string\n"
 	}
 	t += "		scanTargets []any\n"
 	t += "	)\n\n"
 	t += "	for _, field := range fields {\n"
 	t += "		switch field {\n"
 	for _, tf := range tfs {
-		tfn := db.NormalizeString(tf.Name)
+		tfn := tf.GoName
 		t += "		case Field" + tfn + ":\n"
 		t += "			scanTargets = append(scanTargets, &ptr" + tfn + ")\n"
 	}
@@ -288,7 +590,7 @@ func GetGeneralFunctions(tfs []conf.TableField, nqs []conf.NamedQuery) string {
 	t += "		return nil, err\n"
 	t += "	}\n\n"
 	for _, tf := range tfs {
-		tfn := db.NormalizeString(tf.Name)
+		tfn := tf.GoName
 		t += "	if ptr" + tfn + " != nil {\n"
 		t += "		x." + tfn + " = *ptr" + tfn + "\n"
 		t += "	} else {\n"
@@ -310,29 +612,19 @@ func GetGeneralFunctions(tfs []conf.TableField, nqs []conf.NamedQuery) string {
 	t += "    return results, nil\n"
 	t += "}\n\n"
 
-	t += "func bindStmtCtxTx(base *sql.Stmt, ctx context.Context, tx *sql.Tx) (*sql.Stmt, bool) {\n"
-	t += "	if tx == nil {\n"
-	t += "		return base, false\n"
-	t += "	}\n"
-	t += "	if ctx != nil {\n"
-	t += "		return tx.StmtContext(ctx, base), true\n"
-	t += "	}\n"
-	t += "	return tx.Stmt(base), true\n"
-	t += "}\n\n"
-
-	t += "func execCore(ctx context.Context, tx *sql.Tx, query string, args ...any) (res sql.Result, err error) {\n"
-	t += "	stmt, err := getPreparedStmt(query)\n"
+	t += "func (r *Repo) execCore(ctx context.Context, tx *sql.Tx, query string, args ...any) (res sql.Result, err error) {\n"
+	t += "	stmt, err := r.prepare(ctx, query)\n"
 	t += "	if err != nil { return nil, err }\n"
-	t += "	s, needClose := bindStmtCtxTx(stmt, ctx, tx)\n"
+	t += "	s, needClose := r.bind(ctx, tx, stmt)\n"
 	t += "	if needClose { defer func(){ if cerr := s.Close(); err == nil && cerr != nil { err = cerr } }() }\n"
 	t += "	if ctx != nil { return s.ExecContext(ctx, args...) }\n"
 	t += "	return s.Exec(args...)\n"
 	t += "}\n\n"
 
-	t += "func queryCore(ctx context.Context, tx *sql.Tx, fields []string, query string, args ...any) (out []*Entity, err error) {\n"
-	t += "    stmt, err := getPreparedStmt(query)\n"
+	t += "func (r *Repo) queryCore(ctx context.Context, tx *sql.Tx, fields []string, query string, args ...any) (out []*Entity, err error) {\n"
+	t += "    stmt, err := r.prepare(ctx, query)\n"
 	t += "    if err != nil { return nil, err }\n"
-	t += "    s, needClose := bindStmtCtxTx(stmt, ctx, tx)\n"
+	t += "    s, needClose := r.bind(ctx, tx, stmt)\n"
 	t += "    if needClose { defer func(){ if cerr := s.Close(); err == nil && cerr != nil { err = cerr } }() }\n"
 	t += "    var rows *sql.Rows\n"
 	t += "    if ctx != nil { rows, err = s.QueryContext(ctx, args...) } else { rows, err = s.Query(args...) }\n"
@@ -340,10 +632,10 @@ func GetGeneralFunctions(tfs []conf.TableField, nqs []conf.NamedQuery) string {
 	t += "    return readRows(fields, rows)\n"
 	t += "}\n\n"
 
-	t += "func queryOneCore(ctx context.Context, tx *sql.Tx, fields []string, query string, args ...any) (*Entity, error) {\n"
-	t += "    stmt, err := getPreparedStmt(query)\n"
+	t += "func (r *Repo) queryOneCore(ctx context.Context, tx *sql.Tx, fields []string, query string, args ...any) (*Entity, error) {\n"
+	t += "    stmt, err := r.prepare(ctx, query)\n"
 	t += "    if err != nil { return nil, err }\n"
-	t += "    s, needClose := bindStmtCtxTx(stmt, ctx, tx)\n"
+	t += "    s, needClose := r.bind(ctx, tx, stmt)\n"
 	t += "    if needClose { defer s.Close() }\n"
 	t += "    var rows *sql.Rows\n"
 	t += "    if ctx != nil { rows, err = s.QueryContext(ctx, args...) } else { rows, err = s.Query(args...) }\n"
@@ -353,10 +645,10 @@ func GetGeneralFunctions(tfs []conf.TableField, nqs []conf.NamedQuery) string {
 	t += "    return scanRow(fields, rows)\n"
 	t += "}\n\n"
 
-	t += "func scalarCore(ctx context.Context, tx *sql.Tx, query string, args ...any) (int, error) {\n"
-	t += "	stmt, err := getPreparedStmt(query)\n"
+	t += "func (r *Repo) scalarCore(ctx context.Context, tx *sql.Tx, query string, args ...any) (int, error) {\n"
+	t += "	stmt, err := r.prepare(ctx, query)\n"
 	t += "	if err != nil { return 0, err }\n"
-	t += "	s, needClose := bindStmtCtxTx(stmt, ctx, tx)\n"
+	t += "	s, needClose := r.bind(ctx, tx, stmt)\n"
 	t += "	if needClose { defer s.Close() }\n"
 	t += "	var v int\n"
 	t += "	if ctx != nil { err = s.QueryRowContext(ctx, args...).Scan(&v) } else { err = s.QueryRow(args...).Scan(&v) }\n"
@@ -366,249 +658,222 @@ func GetGeneralFunctions(tfs []conf.TableField, nqs []conf.NamedQuery) string {
 	return t
 }
 
-func GetDBFunctions() string {
+// GetDBFunctions implements every operation once as a Repo method taking a context and an optional transaction. The
+// package-level functions, the Entity methods and the exported Repo methods only call it, see getDBWrappers. The
+// managed columns mf change what the operations write and read, see ManagedFields.
+func GetDBFunctions(mf ManagedFields) string {
+	vf, sf := mf.Version, mf.SoftDelete
 	t := ""
 
-	t += "func DBTruncate() *QueryResult { res, err := execCore(nil, nil, \"TRUNCATE TABLE \"+FQTN); return &QueryResult{Result: res, Error: err} }\n"
-	t += "func DBTruncateCtx(ctx context.Context) *QueryResult { res, err := execCore(ctx, nil, \"TRUNCATE TABLE \"+FQTN); return &QueryResult{Result: res, Error: err} }\n"
-	t += "func DBTruncateTx(tx *sql.Tx) *QueryResult { res, err := execCore(nil, tx, \"TRUNCATE TABLE \"+FQTN); return &QueryResult{Result: res, Error: err} }\n"
-	t += "func DBTruncateCtxTx(ctx context.Context, tx *sql.Tx) *QueryResult { res, err := execCore(ctx, tx, \"TRUNCATE TABLE \"+FQTN); return &QueryResult{Result: res, Error: err} }\n\n"
-
-	t += "func (x *Entity) DBInsert(params *QueryParams) *QueryResult {\n"
-	t += "	fieldsToInsert := Fields\n"
-	t += "	if params != nil && len(params.Insert) > 0 { fieldsToInsert = params.Insert }\n"
-	t += "	q := \"INSERT INTO \" + FQTN + \" (\" + strings.Join(GetQualifiedFields(fieldsToInsert), \", \") + \") VALUES (\" + strings.Join(GetValuesPlaceholders(fieldsToInsert), \", \") + \")\"\n"
-	t += "	res, err := execCore(nil, nil, q, x.GetFieldsValues(fieldsToInsert)...)\n"
-	t += "	return &QueryResult{Result: res, Error: err}\n"
-	t += "}\n\n"
-	t += "func (x *Entity) DBInsertCtx(ctx context.Context, params *QueryParams) *QueryResult {\n"
-	t += "	fieldsToInsert := Fields\n"
-	t += "	if params != nil && len(params.Insert) > 0 { fieldsToInsert = params.Insert }\n"
-	t += "	q := \"INSERT INTO \" + FQTN + \" (\" + strings.Join(GetQualifiedFields(fieldsToInsert), \", \") + \") VALUES (\" + strings.Join(GetValuesPlaceholders(fieldsToInsert), \", \") + \")\"\n"
-	t += "	res, err := execCore(ctx, nil, q, x.GetFieldsValues(fieldsToInsert)...)\n"
-	t += "	return &QueryResult{Result: res, Error: err}\n"
-	t += "}\n\n"
-	t += "func (x *Entity) DBInsertTx(tx *sql.Tx, params *QueryParams) *QueryResult {\n"
-	t += "	fieldsToInsert := Fields\n"
-	t += "	if params != nil && len(params.Insert) > 0 { fieldsToInsert = params.Insert }\n"
-	t += "	q := \"INSERT INTO \" + FQTN + \" (\" + strings.Join(GetQualifiedFields(fieldsToInsert), \", \") + \") VALUES (\" + strings.Join(GetValuesPlaceholders(fieldsToInsert), \", \") + \")\"\n"
-	t += "	res, err := execCore(nil, tx, q, x.GetFieldsValues(fieldsToInsert)...)\n"
-	t += "	return &QueryResult{Result: res, Error: err}\n"
-	t += "}\n\n"
-	t += "func (x *Entity) DBInsertCtxTx(ctx context.Context, tx *sql.Tx, params *QueryParams) *QueryResult {\n"
-	t += "	fieldsToInsert := Fields\n"
-	t += "	if params != nil && len(params.Insert) > 0 { fieldsToInsert = params.Insert }\n"
-	t += "	q := \"INSERT INTO \" + FQTN + \" (\" + strings.Join(GetQualifiedFields(fieldsToInsert), \", \") + \") VALUES (\" + strings.Join(GetValuesPlaceholders(fieldsToInsert), \", \") + \")\"\n"
-	t += "	res, err := execCore(ctx, tx, q, x.GetFieldsValues(fieldsToInsert)...)\n"
-	t += "	return &QueryResult{Result: res, Error: err}\n"
+	t += "func (r *Repo) truncate(ctx context.Context, tx *sql.Tx) *QueryResult {\n"
+	t += "	tbl := r.fqtn(ctx)\n"
+	t += "	res, err := r.execCore(ctx, tx, \"TRUNCATE TABLE \"+tbl)\n"
+	t += "	return &QueryResult{Result: res, Error: margort.WrapError(err, tbl, \"DBTruncate\")}\n"
 	t += "}\n\n"
+	t += getDBWrappers("DBTruncate", "truncate", false, false)
 
-	// DELETE with WHERE (AND conditions)
-	t += "func (x *Entity) DBDelete(params *QueryParams) *QueryResult {\n"
-	t += "	whereFields := Fields\n"
-	t += "	if params != nil && len(params.Where) > 0 { whereFields = params.Where }\n"
-	t += "	q := \"DELETE FROM \" + FQTN + \" WHERE \" + strings.Join(GetQualifiedFields(whereFields), \" = ? AND \") + \" = ?\"\n"
-	t += "	res, err := execCore(nil, nil, q, x.GetFieldsValues(whereFields)...)\n"
-	t += "	return &QueryResult{Result: res, Error: err}\n"
-	t += "}\n\n"
-	t += "func (x *Entity) DBDeleteCtx(ctx context.Context, params *QueryParams) *QueryResult {\n"
-	t += "	whereFields := Fields\n"
-	t += "	if params != nil && len(params.Where) > 0 { whereFields = params.Where }\n"
-	t += "	q := \"DELETE FROM \" + FQTN + \" WHERE \" + strings.Join(GetQualifiedFields(whereFields), \" = ? AND \") + \" = ?\"\n"
-	t += "	res, err := execCore(ctx, nil, q, x.GetFieldsValues(whereFields)...)\n"
-	t += "	return &QueryResult{Result: res, Error: err}\n"
-	t += "}\n\n"
-	t += "func (x *Entity) DBDeleteTx(tx *sql.Tx, params *QueryParams) *QueryResult {\n"
-	t += "	whereFields := Fields\n"
-	t += "	if params != nil && len(params.Where) > 0 { whereFields = params.Where }\n"
-	t += "	q := \"DELETE FROM \" + FQTN + \" WHERE \" + strings.Join(GetQualifiedFields(whereFields), \" = ? AND \") + \" = ?\"\n"
-	t += "	res, err := execCore(nil, tx, q, x.GetFieldsValues(whereFields)...)\n"
-	t += "	return &QueryResult{Result: res, Error: err}\n"
-	t += "}\n\n"
-	t += "func (x *Entity) DBDeleteCtxTx(ctx context.Context, tx *sql.Tx, params *QueryParams) *QueryResult {\n"
-	t += "	whereFields := Fields\n"
-	t += "	if params != nil && len(params.Where) > 0 { whereFields = params.Where }\n"
-	t += "	q := \"DELETE FROM \" + FQTN + \" WHERE \" + strings.Join(GetQualifiedFields(whereFields), \" = ? AND \") + \" = ?\"\n"
-	t += "	res, err := execCore(ctx, tx, q, x.GetFieldsValues(whereFields)...)\n"
-	t += "	return &QueryResult{Result: res, Error: err}\n"
+	t += "func (r *Repo) insert(ctx context.Context, tx *sql.Tx, x *Entity, params *QueryParams) *QueryResult {\n"
+	if mf.autofills() {
+		t += "	fillInsert(x)\n"
+	}
+	t += "	tbl := r.fqtn(ctx)\n"
+	if len(mf.Defaults) > 0 {
+		t += "	var fieldsToInsert []string\n"
+		t += "	if params != nil && len(params.Insert) > 0 { fieldsToInsert = params.Insert } else { fieldsToInsert = insertFields(x) }\n"
+	} else {
+		t += "	fieldsToInsert := Fields\n"
+		t += "	if params != nil && len(params.Insert) > 0 { fieldsToInsert = params.Insert }\n"
+	}
+	t += "	q := \"INSERT INTO \" + tbl + \" (\" + strings.Join(qualifiedFields(tbl, fieldsToInsert), \", \") + \") VALUES (\" + strings.Join(GetValuesPlaceholders(fieldsToInsert), \", \") + \")\"\n"
+	switch {
+	case conf.Args.InsertReturning:
+		// the row as stored, with its defaulted and generated columns
+		t += "	q += \" RETURNING \" + strings.Join(qualifiedFields(tbl, Fields), \", \")\n"
+		t += "	entity, err := r.queryOneCore(ctx, tx, Fields, q, x.GetFieldsValues(fieldsToInsert)...)\n"
+		t += "	if err != nil { return &QueryResult{Error: margort.WrapError(err, tbl, \"DBInsert\")} }\n"
+		t += "	var res margort.RowResult\n"
+		t += "	if entity != nil {\n"
+		t += "		*x = *entity\n"
+		t += "		res.Rows = 1\n"
+		if mf.AutoIncrement != nil {
+			t += "		res.ID, _ = strconv.ParseInt(x." + mf.AutoIncrement.GoName + ", 10, 64)\n"
+		}
+		t += "	}\n"
+		t += "	return &QueryResult{Result: res, Entity: x}\n"
+	case mf.AutoIncrement != nil:
+		ai := mf.AutoIncrement.GoName
+		t += "	res, err := r.execCore(ctx, tx, q, x.GetFieldsValues(fieldsToInsert)...)\n"
+		t += "	if err != nil { return &QueryResult{Result: res, Error: margort.WrapError(err, tbl, \"DBInsert\")} }\n"
+		t += "	if x." + ai + " == \"\" {\n"
+		t += "		if id, err := res.LastInsertId(); err == nil && id != 0 { x." + ai + " = strconv.FormatInt(id, 10) }\n"
+		t += "	}\n"
+		t += "	return &QueryResult{Result: res}\n"
+	default:
+		t += "	res, err := r.execCore(ctx, tx, q, x.GetFieldsValues(fieldsToInsert)...)\n"
+		t += "	return &QueryResult{Result: res, Error: margort.WrapError(err, tbl, \"DBInsert\")}\n"
+	}
 	t += "}\n\n"
+	t += getHookedCore("DBInsert", "insert", "Insert")
+
+	// DELETE with WHERE (AND conditions)
+	if sf != nil {
+		t += getSoftDeleteCores(sf)
+	} else {
+		t += getDeleteCore("delete", "DBDelete")
+		t += getHookedCore("DBDelete", "delete", "Delete")
+	}
 
 	// UPDATE with SET and WHERE (AND conditions)
-	t += "func (x *Entity) DBUpdate(params *QueryParams) *QueryResult {\n"
-	t += "	if params == nil || len(params.Update) == 0 || len(params.Where) == 0 {\n"
-	t += "		return &QueryResult{Error: errors.New(\"DBUpdate requires both params.Update and params.Where to be specified\")}\n"
-	t += "	}\n"
-	t += "	q := \"UPDATE \" + FQTN + \" SET \" + strings.Join(GetQualifiedPlaceholders(params.Update), \", \") + \" WHERE \" + strings.Join(GetQualifiedFields(params.Where), \" = ? AND \") + \" = ?\"\n"
-	t += "	vals := append(x.GetFieldsValues(params.Update), x.GetFieldsValues(params.Where)...)\n"
-	t += "	res, err := execCore(nil, nil, q, vals...)\n"
-	t += "	return &QueryResult{Result: res, Error: err}\n"
-	t += "}\n\n"
-	t += "func (x *Entity) DBUpdateCtx(ctx context.Context, params *QueryParams) *QueryResult {\n"
-	t += "	if params == nil || len(params.Update) == 0 || len(params.Where) == 0 {\n"
-	t += "		return &QueryResult{Error: errors.New(\"DBUpdate requires both params.Update and params.Where to be specified\")}\n"
-	t += "	}\n"
-	t += "	q := \"UPDATE \" + FQTN + \" SET \" + strings.Join(GetQualifiedPlaceholders(params.Update), \", \") + \" WHERE \" + strings.Join(GetQualifiedFields(params.Where), \" = ? AND \") + \" = ?\"\n"
-	t += "	vals := append(x.GetFieldsValues(params.Update), x.GetFieldsValues(params.Where)...)\n"
-	t += "	res, err := execCore(ctx, nil, q, vals...)\n"
-	t += "	return &QueryResult{Result: res, Error: err}\n"
-	t += "}\n\n"
-	t += "func (x *Entity) DBUpdateTx(tx *sql.Tx, params *QueryParams) *QueryResult {\n"
-	t += "	if params == nil || len(params.Update) == 0 || len(params.Where) == 0 {\n"
-	t += "		return &QueryResult{Error: errors.New(\"DBUpdate requires both params.Update and params.Where to be specified\")}\n"
-	t += "	}\n"
-	t += "	q := \"UPDATE \" + FQTN + \" SET \" + strings.Join(GetQualifiedPlaceholders(params.Update), \", \") + \" WHERE \" + strings.Join(GetQualifiedFields(params.Where), \" = ? AND \") + \" = ?\"\n"
-	t += "	vals := append(x.GetFieldsValues(params.Update), x.GetFieldsValues(params.Where)...)\n"
-	t += "	res, err := execCore(nil, tx, q, vals...)\n"
-	t += "	return &QueryResult{Result: res, Error: err}\n"
-	t += "}\n\n"
-	t += "func (x *Entity) DBUpdateCtxTx(ctx context.Context, tx *sql.Tx, params *QueryParams) *QueryResult {\n"
-	t += "	if params == nil || len(params.Update) == 0 || len(params.Where) == 0 {\n"
-	t += "		return &QueryResult{Error: errors.New(\"DBUpdate requires both params.Update and params.Where to be specified\")}\n"
-	t += "	}\n"
-	t += "	q := \"UPDATE \" + FQTN + \" SET \" + strings.Join(GetQualifiedPlaceholders(params.Update), \", \") + \" WHERE \" + strings.Join(GetQualifiedFields(params.Where), \" = ? AND \") + \" = ?\"\n"
-	t += "	vals := append(x.GetFieldsValues(params.Update), x.GetFieldsValues(params.Where)...)\n"
-	t += "	res, err := execCore(ctx, tx, q, vals...)\n"
-	t += "	return &QueryResult{Result: res, Error: err}\n"
-	t += "}\n\n"
+	if vf != nil {
+		t += getVersionUpdateCore(vf, mf.Updated, sf)
+	} else {
+		t += "func (r *Repo) update(ctx context.Context, tx *sql.Tx, x *Entity, params *QueryParams) *QueryResult {\n"
+		t += "	if params == nil || len(params.Update) == 0 || len(params.Where) == 0 {\n"
+		t += "		return &QueryResult{Error: errors.New(\"DBUpdate requires both params.Update and params.Where to be specified\")}\n"
+		t += "	}\n"
+		t += "	tbl := r.fqtn(ctx)\n"
+		t += "	update := params.Update\n"
+		if uf := mf.Updated; uf != nil {
+			t += getUpdatedAtFill(uf)
+			t += "	set := append(qualifiedPlaceholders(tbl, update), qualifiedPlaceholder(tbl, Field" + uf.GoName + "))\n"
+			t += "	vals := append(x.GetFieldsValues(update), updatedAt)\n"
+		} else {
+			t += "	set := qualifiedPlaceholders(tbl, update)\n"
+			t += "	vals := x.GetFieldsValues(update)\n"
+		}
+		t += "	q := \"UPDATE \" + tbl + \" SET \" + strings.Join(set, \", \") + \" WHERE \" + strings.Join(qualifiedFields(tbl, params.Where), \" = ? AND \") + \" = ?\"\n"
+		if sf != nil {
+			t += "	q = filterDeleted(tbl, q, true, params)\n"
+		}
+		t += "	res, err := r.execCore(ctx, tx, q, append(vals, x.GetFieldsValues(params.Where)...)...)\n"
+		if uf := mf.Updated; uf != nil {
+			t += "	if err == nil {\n"
+			t += "		x." + uf.GoName + " = updatedAt\n"
+			t += "	}\n"
+		}
+		t += "	return &QueryResult{Result: res, Error: margort.WrapError(err, tbl, \"DBUpdate\")}\n"
+		t += "}\n\n"
+	}
+	t += getHookedCore("DBUpdate", "update", "Update")
 
 	// SELECT with optional WHERE and custom fields
-	t += "func (x *Entity) DBSelect(params *QueryParams) *QueryResult {\n"
-	t += "	fieldsToSelect := Fields\n"
-	t += "	if params != nil && len(params.Select) > 0 { fieldsToSelect = params.Select }\n"
-	t += "	q := \"SELECT \" + strings.Join(GetQualifiedFields(fieldsToSelect), \", \") + \" FROM \" + FQTN\n"
-	t += "	var args []any\n"
-	t += "	if params != nil && len(params.Where) > 0 {\n"
-	t += "		q += \" WHERE \" + strings.Join(GetQualifiedFields(params.Where), \" = ? AND \") + \" = ?\"\n"
-	t += "		args = x.GetFieldsValues(params.Where)\n"
-	t += "	}\n"
-	t += "	entities, err := queryCore(nil, nil, fieldsToSelect, q, args...)\n"
-	t += "	return &QueryResult{Entities: entities, Error: err}\n"
-	t += "}\n\n"
-	t += "func (x *Entity) DBSelectCtx(ctx context.Context, params *QueryParams) *QueryResult {\n"
-	t += "	fieldsToSelect := Fields\n"
-	t += "	if params != nil && len(params.Select) > 0 { fieldsToSelect = params.Select }\n"
-	t += "	q := \"SELECT \" + strings.Join(GetQualifiedFields(fieldsToSelect), \", \") + \" FROM \" + FQTN\n"
-	t += "	var args []any\n"
-	t += "	if params != nil && len(params.Where) > 0 {\n"
-	t += "		q += \" WHERE \" + strings.Join(GetQualifiedFields(params.Where), \" = ? AND \") + \" = ?\"\n"
-	t += "		args = x.GetFieldsValues(params.Where)\n"
-	t += "	}\n"
-	t += "	entities, err := queryCore(ctx, nil, fieldsToSelect, q, args...)\n"
-	t += "	return &QueryResult{Entities: entities, Error: err}\n"
-	t += "}\n\n"
-	t += "func (x *Entity) DBSelectTx(tx *sql.Tx, params *QueryParams) *QueryResult {\n"
-	t += "	fieldsToSelect := Fields\n"
-	t += "	if params != nil && len(params.Select) > 0 { fieldsToSelect = params.Select }\n"
-	t += "	q := \"SELECT \" + strings.Join(GetQualifiedFields(fieldsToSelect), \", \") + \" FROM \" + FQTN\n"
-	t += "	var args []any\n"
-	t += "	if params != nil && len(params.Where) > 0 {\n"
-	t += "		q += \" WHERE \" + strings.Join(GetQualifiedFields(params.Where), \" = ? AND \") + \" = ?\"\n"
-	t += "		args = x.GetFieldsValues(params.Where)\n"
-	t += "	}\n"
-	t += "	entities, err := queryCore(nil, tx, fieldsToSelect, q, args...)\n"
-	t += "	return &QueryResult{Entities: entities, Error: err}\n"
-	t += "}\n\n"
-	t += "func (x *Entity) DBSelectCtxTx(ctx context.Context, tx *sql.Tx, params *QueryParams) *QueryResult {\n"
+	t += "func (r *Repo) selectEntities(ctx context.Context, tx *sql.Tx, x *Entity, params *QueryParams) *QueryResult {\n"
+	t += "	tbl := r.fqtn(ctx)\n"
 	t += "	fieldsToSelect := Fields\n"
 	t += "	if params != nil && len(params.Select) > 0 { fieldsToSelect = params.Select }\n"
-	t += "	q := \"SELECT \" + strings.Join(GetQualifiedFields(fieldsToSelect), \", \") + \" FROM \" + FQTN\n"
+	t += "	q := \"SELECT \" + strings.Join(qualifiedFields(tbl, fieldsToSelect), \", \") + \" FROM \" + tbl\n"
 	t += "	var args []any\n"
 	t += "	if params != nil && len(params.Where) > 0 {\n"
-	t += "		q += \" WHERE \" + strings.Join(GetQualifiedFields(params.Where), \" = ? AND \") + \" = ?\"\n"
+	t += "		q += \" WHERE \" + strings.Join(qualifiedFields(tbl, params.Where), \" = ? AND \") + \" = ?\"\n"
 	t += "		args = x.GetFieldsValues(params.Where)\n"
 	t += "	}\n"
-	t += "	entities, err := queryCore(ctx, tx, fieldsToSelect, q, args...)\n"
-	t += "	return &QueryResult{Entities: entities, Error: err}\n"
+	if sf != nil {
+		t += "	q = filterDeleted(tbl, q, len(args) > 0, params)\n"
+	}
+	t += "	entities, err := r.reader(ctx, tx).queryCore(ctx, tx, fieldsToSelect, q, args...)\n"
+	t += "	return &QueryResult{Entities: entities, Error: margort.WrapError(err, tbl, \"DBSelect\")}\n"
 	t += "}\n\n"
+	t += getDBWrappers("DBSelect", "selectEntities", true, true)
 
 	// SelectAll - convenience function for selecting all rows with all fields
-	t += "func DBSelectAll() *QueryResult {\n"
-	t += "	q := \"SELECT \" + strings.Join(GetQualifiedFields(Fields), \", \") + \" FROM \" + FQTN\n"
-	t += "	entities, err := queryCore(nil, nil, Fields, q)\n"
-	t += "	return &QueryResult{Entities: entities, Error: err}\n"
-	t += "}\n\n"
-	t += "func DBSelectAllCtx(ctx context.Context) *QueryResult {\n"
-	t += "	q := \"SELECT \" + strings.Join(GetQualifiedFields(Fields), \", \") + \" FROM \" + FQTN\n"
-	t += "	entities, err := queryCore(ctx, nil, Fields, q)\n"
-	t += "	return &QueryResult{Entities: entities, Error: err}\n"
-	t += "}\n\n"
-	t += "func DBSelectAllTx(tx *sql.Tx) *QueryResult {\n"
-	t += "	q := \"SELECT \" + strings.Join(GetQualifiedFields(Fields), \", \") + \" FROM \" + FQTN\n"
-	t += "	entities, err := queryCore(nil, tx, Fields, q)\n"
-	t += "	return &QueryResult{Entities: entities, Error: err}\n"
-	t += "}\n\n"
-	t += "func DBSelectAllCtxTx(ctx context.Context, tx *sql.Tx) *QueryResult {\n"
-	t += "	q := \"SELECT \" + strings.Join(GetQualifiedFields(Fields), \", \") + \" FROM \" + FQTN\n"
-	t += "	entities, err := queryCore(ctx, tx, Fields, q)\n"
-	t += "	return &QueryResult{Entities: entities, Error: err}\n"
+	t += "func (r *Repo) selectAll(ctx context.Context, tx *sql.Tx) *QueryResult {\n"
+	t += "	tbl := r.fqtn(ctx)\n"
+	t += "	q := \"SELECT \" + strings.Join(qualifiedFields(tbl, Fields), \", \") + \" FROM \" + tbl\n"
+	if sf != nil {
+		t += "	q = filterDeleted(tbl, q, false, nil)\n"
+	}
+	t += "	entities, err := r.reader(ctx, tx).queryCore(ctx, tx, Fields, q)\n"
+	t += "	return &QueryResult{Entities: entities, Error: margort.WrapError(err, tbl, \"DBSelectAll\")}\n"
 	t += "}\n\n"
-
-
+	t += getDBWrappers("DBSelectAll", "selectAll", false, false)
 
 	//Exists - flexible: Select controls returned fields, Where controls filter
-	t += "func (x *Entity) DBExists(params *QueryParams) *QueryResult {\n"
-	t += "	if params == nil {\n"
-	t += "		return &QueryResult{Error: errors.New(\"DBExists requires params to be specified\"), Exists: false}\n"
-	t += "	}\n"
-	t += "	fieldsToSelect := params.Select\n"
-	t += "	if len(fieldsToSelect) == 0 { fieldsToSelect = Fields }\n"
-	t += "	whereFields := params.Where\n"
-	t += "	if len(whereFields) == 0 { whereFields = Fields }\n"
-	t += "	q := \"SELECT \" + strings.Join(GetQualifiedFields(fieldsToSelect), \", \") + \" FROM \" + FQTN + \" WHERE \" + strings.Join(GetQualifiedFields(whereFields), \" = ? AND \") + \" = ? LIMIT 1\"\n"
-	t += "	entities, err := queryCore(nil, nil, fieldsToSelect, q, x.GetFieldsValues(whereFields)...)\n"
-	t += "	if err != nil { return &QueryResult{Error: err, Exists: false} }\n"
-	t += "	if len(entities) == 0 { return &QueryResult{Exists: false} }\n"
-	t += "	*x = *entities[0]\n"
-	t += "	return &QueryResult{Exists: true}\n"
-	t += "}\n\n"
-	t += "func (x *Entity) DBExistsCtx(ctx context.Context, params *QueryParams) *QueryResult {\n"
+	t += "func (r *Repo) exists(ctx context.Context, tx *sql.Tx, x *Entity, params *QueryParams) *QueryResult {\n"
 	t += "	if params == nil {\n"
 	t += "		return &QueryResult{Error: errors.New(\"DBExists requires params to be specified\"), Exists: false}\n"
 	t += "	}\n"
+	t += "	tbl := r.fqtn(ctx)\n"
 	t += "	fieldsToSelect := params.Select\n"
 	t += "	if len(fieldsToSelect) == 0 { fieldsToSelect = Fields }\n"
 	t += "	whereFields := params.Where\n"
 	t += "	if len(whereFields) == 0 { whereFields = Fields }\n"
-	t += "	q := \"SELECT \" + strings.Join(GetQualifiedFields(fieldsToSelect), \", \") + \" FROM \" + FQTN + \" WHERE \" + strings.Join(GetQualifiedFields(whereFields), \" = ? AND \") + \" = ? LIMIT 1\"\n"
-	t += "	entities, err := queryCore(ctx, nil, fieldsToSelect, q, x.GetFieldsValues(whereFields)...)\n"
-	t += "	if err != nil { return &QueryResult{Error: err, Exists: false} }\n"
+	if sf != nil {
+		t += "	q := \"SELECT \" + strings.Join(qualifiedFields(tbl, fieldsToSelect), \", \") + \" FROM \" + tbl + \" WHERE \" + strings.Join(qualifiedFields(tbl, whereFields), \" = ? AND \") + \" = ?\"\n"
+		t += "	q = filterDeleted(tbl, q, true, params) + \" LIMIT 1\"\n"
+	} else {
+		t += "	q := \"SELECT \" + strings.Join(qualifiedFields(tbl, fieldsToSelect), \", \") + \" FROM \" + tbl + \" WHERE \" + strings.Join(qualifiedFields(tbl, whereFields), \" = ? AND \") + \" = ? LIMIT 1\"\n"
+	}
+	t += "	entities, err := r.reader(ctx, tx).queryCore(ctx, tx, fieldsToSelect, q, x.GetFieldsValues(whereFields)...)\n"
+	t += "	if err != nil { return &QueryResult{Error: margort.WrapError(err, tbl, \"DBExists\"), Exists: false} }\n"
 	t += "	if len(entities) == 0 { return &QueryResult{Exists: false} }\n"
 	t += "	*x = *entities[0]\n"
 	t += "	return &QueryResult{Exists: true}\n"
 	t += "}\n\n"
-	t += "func (x *Entity) DBExistsTx(tx *sql.Tx, params *QueryParams) *QueryResult {\n"
-	t += "	if params == nil {\n"
-	t += "		return &QueryResult{Error: errors.New(\"DBExists requires params to be specified\"), Exists: false}\n"
-	t += "	}\n"
-	t += "	fieldsToSelect := params.Select\n"
-	t += "	if len(fieldsToSelect) == 0 { fieldsToSelect = Fields }\n"
-	t += "	whereFields := params.Where\n"
-	t += "	if len(whereFields) == 0 { whereFields = Fields }\n"
-	t += "	q := \"SELECT \" + strings.Join(GetQualifiedFields(fieldsToSelect), \", \") + \" FROM \" + FQTN + \" WHERE \" + strings.Join(GetQualifiedFields(whereFields), \" = ? AND \") + \" = ? LIMIT 1\"\n"
-	t += "	entities, err := queryCore(nil, tx, fieldsToSelect, q, x.GetFieldsValues(whereFields)...)\n"
-	t += "	if err != nil { return &QueryResult{Error: err, Exists: false} }\n"
-	t += "	if len(entities) == 0 { return &QueryResult{Exists: false} }\n"
-	t += "	*x = *entities[0]\n"
-	t += "	return &QueryResult{Exists: true}\n"
+	t += getDBWrappers("DBExists", "exists", true, true)
+
+	t += getDeleteWhereCore(mf)
+	t += getDBWrappers("DBDeleteWhere", "deleteWhere", false, true)
+	t += getUpdateWhereCore(mf)
+	t += getDBWrappers("DBUpdateWhere", "updateWhere", false, true)
+
+	return t
+}
+
+// getDeleteCore returns the core that deletes the rows matching params.Where, or every field of x by default.
+func getDeleteCore(core, op string) string {
+	t := "func (r *Repo) " + core + "(ctx context.Context, tx *sql.Tx, x *Entity, params *QueryParams) *QueryResult {\n"
+	t += "	tbl := r.fqtn(ctx)\n"
+	t += "	whereFields := Fields\n"
+	t += "	if params != nil && len(params.Where) > 0 { whereFields = params.Where }\n"
+	t += "	q := \"DELETE FROM \" + tbl + \" WHERE \" + strings.Join(qualifiedFields(tbl, whereFields), \" = ? AND \") + \" = ?\"\n"
+	t += getDeleteExec(op, "x.GetFieldsValues(whereFields)...")
 	t += "}\n\n"
-	t += "func (x *Entity) DBExistsCtxTx(ctx context.Context, tx *sql.Tx, params *QueryParams) *QueryResult {\n"
-	t += "	if params == nil {\n"
-	t += "		return &QueryResult{Error: errors.New(\"DBExists requires params to be specified\"), Exists: false}\n"
+	return t
+}
+
+// getDeleteExec returns the end of a delete core running q with args, which reads the deleted rows back with
+// RETURNING when params.Returning is set.
+func getDeleteExec(op, args string) string {
+	t := "	if params != nil && params.Returning {\n"
+	t += "		q += \" RETURNING \" + strings.Join(qualifiedFields(tbl, Fields), \", \")\n"
+	t += "		entities, err := r.queryCore(ctx, tx, Fields, q, " + args + ")\n"
+	t += "		return &QueryResult{Entities: entities, Error: margort.WrapError(err, tbl, \"" + op + "\")}\n"
 	t += "	}\n"
-	t += "	fieldsToSelect := params.Select\n"
-	t += "	if len(fieldsToSelect) == 0 { fieldsToSelect = Fields }\n"
-	t += "	whereFields := params.Where\n"
-	t += "	if len(whereFields) == 0 { whereFields = Fields }\n"
-	t += "	q := \"SELECT \" + strings.Join(GetQualifiedFields(fieldsToSelect), \", \") + \" FROM \" + FQTN + \" WHERE \" + strings.Join(GetQualifiedFields(whereFields), \" = ? AND \") + \" = ? LIMIT 1\"\n"
-	t += "	entities, err := queryCore(ctx, tx, fieldsToSelect, q, x.GetFieldsValues(whereFields)...)\n"
-	t += "	if err != nil { return &QueryResult{Error: err, Exists: false} }\n"
-	t += "	if len(entities) == 0 { return &QueryResult{Exists: false} }\n"
-	t += "	*x = *entities[0]\n"
-	t += "	return &QueryResult{Exists: true}\n"
-	t += "}\n\n"
+	t += "	res, err := r.execCore(ctx, tx, q, " + args + ")\n"
+	t += "	return &QueryResult{Result: res, Error: margort.WrapError(err, tbl, \"" + op + "\")}\n"
+	return t
+}
 
+// getDBWrappers returns the Plain, Ctx, Tx and CtxTx variants of an operation, or the single one of the context API,
+// methods on Entity when entity is set, which run on the default Repo, and the Repo method, which runs on its own DBTX.
+func getDBWrappers(name, core string, entity, params bool) string {
+	recv, repoParams, args := "", "", ""
+	if entity {
+		recv = "(x *Entity) "
+		repoParams += ", x *Entity"
+		args += ", x"
+	}
+	ps := []string{}
+	if params {
+		ps = append(ps, "params *QueryParams")
+		repoParams += ", params *QueryParams"
+		args += ", params"
+	}
+	sig := func(extra ...string) string {
+		return "(" + strings.Join(append(extra, ps...), ", ") + ")"
+	}
 
+	if contextAPI() {
+		t := "func " + recv + name + sig("ctx context.Context") + " *QueryResult { return defaultRepo." + core + "(ctx, nil" + args + ") }\n"
+		t += "func (r *Repo) " + name + "(ctx context.Context" + repoParams + ") *QueryResult { return r." + core + "(ctx, nil" + args + ") }\n\n"
+		return t
+	}
 
+	t := "func " + recv + name + sig() + " *QueryResult { return defaultRepo." + core + "(nil, nil" + args + ") }\n"
+	t += "func " + recv + name + "Ctx" + sig("ctx context.Context") + " *QueryResult { return defaultRepo." + core + "(ctx, nil" + args + ") }\n"
+	t += "func " + recv + name + "Tx" + sig("tx *sql.Tx") + " *QueryResult { return defaultRepo." + core + "(nil, tx" + args + ") }\n"
+	t += "func " + recv + name + "CtxTx" + sig("ctx context.Context", "tx *sql.Tx") + " *QueryResult { return defaultRepo." + core + "(ctx, tx" + args + ") }\n"
+	t += "func (r *Repo) " + name + "(ctx context.Context" + repoParams + ") *QueryResult { return r." + core + "(ctx, nil" + args + ") }\n\n"
 	return t
 }
 
@@ -626,108 +891,75 @@ func GetNamedQueryFunctions(nqs []conf.NamedQuery) string {
 		if mode != "exec" {
 			fs := make([]string, 0, len(nq.Returns))
 			for _, r := range nq.Returns {
-				fs = append(fs, "Field"+db.NormalizeString(r))
+				fs = append(fs, "Field"+naming.Field(nq.MapAs, r))
 			}
 			fieldsLit = "[]string{" + strings.Join(fs, ",") + "}"
 		}
 
-		// Helper to build function parameters
-		buildParams := func(withCtx, withTx bool) string {
-			var ps []string
-			if withCtx {
-				ps = append(ps, "ctx context.Context")
-			}
-			if withTx {
-				ps = append(ps, "tx *sql.Tx")
-			}
-			if hasParams {
-				ps = append(ps, "params *QueryParams")
-			}
-			if len(ps) == 0 {
-				return "()"
-			}
-			return "(" + strings.Join(ps, ", ") + ")"
+		args := ""
+		if hasParams {
+			args = ", params.Params..."
 		}
 
+		name := "Query" + nq.Name
+		if mode == "exec" {
+			name = "Exec" + nq.Name
+		}
+		wrap := "margort.WrapError(err, r.fqtn(ctx), \"" + name + "\")"
+
+		// core on Repo, like the generated operations
+		core := "named" + nq.Name
+		repo := "r"
+		if IsReadQuery(nq.Query) {
+			repo = "r.reader(ctx, tx)"
+		}
+		t += "func (r *Repo) " + core + "(ctx context.Context, tx *sql.Tx, params *QueryParams) *QueryResult {\n"
+		t += "	q := queries[\"" + nq.Name + "\"]\n"
 		switch mode {
 		case "exec":
-			// core via execCore
-			t += "func Exec" + nq.Name + buildParams(false, false) + " *QueryResult { q := queries[\"" + nq.Name + "\"]; res, err := execCore(nil, nil, q.Query"
-			if hasParams {
-				t += ", params.Params..."
-			}
-			t += "); return &QueryResult{Result: res, Error: err} }\n"
-
-			t += "func Exec" + nq.Name + "Ctx" + buildParams(true, false) + " *QueryResult { q := queries[\"" + nq.Name + "\"]; res, err := execCore(ctx, nil, q.Query"
-			if hasParams {
-				t += ", params.Params..."
-			}
-			t += "); return &QueryResult{Result: res, Error: err} }\n"
-
-			t += "func Exec" + nq.Name + "Tx" + buildParams(false, true) + " *QueryResult { q := queries[\"" + nq.Name + "\"]; res, err := execCore(nil, tx, q.Query"
-			if hasParams {
-				t += ", params.Params..."
-			}
-			t += "); return &QueryResult{Result: res, Error: err} }\n"
-
-			t += "func Exec" + nq.Name + "CtxTx" + buildParams(true, true) + " *QueryResult { q := queries[\"" + nq.Name + "\"]; res, err := execCore(ctx, tx, q.Query"
-			if hasParams {
-				t += ", params.Params..."
-			}
-			t += "); return &QueryResult{Result: res, Error: err} }\n\n"
-
+			t += "	res, err := r.execCore(ctx, tx, q.Query" + args + ")\n"
+			t += "	return &QueryResult{Result: res, Error: " + wrap + "}\n"
 		case "one":
-			// core uses queryOneCore for efficiency
-			t += "func Query" + nq.Name + buildParams(false, false) + " *QueryResult { q := queries[\"" + nq.Name + "\"]; entity, err := queryOneCore(nil, nil, " + fieldsLit + ", q.Query"
-			if hasParams {
-				t += ", params.Params..."
-			}
-			t += "); return &QueryResult{Entity: entity, Error: err, Exists: entity != nil} }\n"
-
-			t += "func Query" + nq.Name + "Ctx" + buildParams(true, false) + " *QueryResult { q := queries[\"" + nq.Name + "\"]; entity, err := queryOneCore(ctx, nil, " + fieldsLit + ", q.Query"
-			if hasParams {
-				t += ", params.Params..."
-			}
-			t += "); return &QueryResult{Entity: entity, Error: err, Exists: entity != nil} }\n"
-
-			t += "func Query" + nq.Name + "Tx" + buildParams(false, true) + " *QueryResult { q := queries[\"" + nq.Name + "\"]; entity, err := queryOneCore(nil, tx, " + fieldsLit + ", q.Query"
-			if hasParams {
-				t += ", params.Params..."
-			}
-			t += "); return &QueryResult{Entity: entity, Error: err, Exists: entity != nil} }\n"
-
-			t += "func Query" + nq.Name + "CtxTx" + buildParams(true, true) + " *QueryResult { q := queries[\"" + nq.Name + "\"]; entity, err := queryOneCore(ctx, tx, " + fieldsLit + ", q.Query"
-			if hasParams {
-				t += ", params.Params..."
-			}
-			t += "); return &QueryResult{Entity: entity, Error: err, Exists: entity != nil} }\n\n"
-
+			// queryOneCore stops after the first row
+			t += "	entity, err := " + repo + ".queryOneCore(ctx, tx, " + fieldsLit + ", q.Query" + args + ")\n"
+			t += "	return &QueryResult{Entity: entity, Error: " + wrap + ", Exists: entity != nil}\n"
 		default: // many
-			t += "func Query" + nq.Name + buildParams(false, false) + " *QueryResult { q := queries[\"" + nq.Name + "\"]; entities, err := queryCore(nil, nil, " + fieldsLit + ", q.Query"
-			if hasParams {
-				t += ", params.Params..."
-			}
-			t += "); return &QueryResult{Entities: entities, Error: err} }\n"
+			t += "	entities, err := " + repo + ".queryCore(ctx, tx, " + fieldsLit + ", q.Query" + args + ")\n"
+			t += "	return &QueryResult{Entities: entities, Error: " + wrap + "}\n"
+		}
+		t += "}\n\n"
 
-			t += "func Query" + nq.Name + "Ctx" + buildParams(true, false) + " *QueryResult { q := queries[\"" + nq.Name + "\"]; entities, err := queryCore(ctx, nil, " + fieldsLit + ", q.Query"
-			if hasParams {
-				t += ", params.Params..."
-			}
-			t += "); return &QueryResult{Entities: entities, Error: err} }\n"
+		t += getNamedQueryWrappers(name, core, hasParams)
+	}
 
-			t += "func Query" + nq.Name + "Tx" + buildParams(false, true) + " *QueryResult { q := queries[\"" + nq.Name + "\"]; entities, err := queryCore(nil, tx, " + fieldsLit + ", q.Query"
-			if hasParams {
-				t += ", params.Params..."
-			}
-			t += "); return &QueryResult{Entities: entities, Error: err} }\n"
+	return t
+}
 
-			t += "func Query" + nq.Name + "CtxTx" + buildParams(true, true) + " *QueryResult { q := queries[\"" + nq.Name + "\"]; entities, err := queryCore(ctx, tx, " + fieldsLit + ", q.Query"
-			if hasParams {
-				t += ", params.Params..."
-			}
-			t += "); return &QueryResult{Entities: entities, Error: err} }\n\n"
+// getNamedQueryWrappers returns the four variants of a named query, or the single one of the context API, which run
+// on the default Repo, and the Repo method.
+func getNamedQueryWrappers(name, core string, hasParams bool) string {
+	// Helper to build function parameters
+	buildParams := func(extra ...string) string {
+		if hasParams {
+			extra = append(extra, "params *QueryParams")
 		}
+		return "(" + strings.Join(extra, ", ") + ")"
+	}
+	params := ", nil"
+	if hasParams {
+		params = ", params"
+	}
+
+	if contextAPI() {
+		t := "func " + name + buildParams("ctx context.Context") + " *QueryResult { return defaultRepo." + core + "(ctx, nil" + params + ") }\n"
+		t += "func (r *Repo) " + name + buildParams("ctx context.Context") + " *QueryResult { return r." + core + "(ctx, nil" + params + ") }\n\n"
+		return t
 	}
 
+	t := "func " + name + buildParams() + " *QueryResult { return defaultRepo." + core + "(nil, nil" + params + ") }\n"
+	t += "func " + name + "Ctx" + buildParams("ctx context.Context") + " *QueryResult { return defaultRepo." + core + "(ctx, nil" + params + ") }\n"
+	t += "func " + name + "Tx" + buildParams("tx *sql.Tx") + " *QueryResult { return defaultRepo." + core + "(nil, tx" + params + ") }\n"
+	t += "func " + name + "CtxTx" + buildParams("ctx context.Context", "tx *sql.Tx") + " *QueryResult { return defaultRepo." + core + "(ctx, tx" + params + ") }\n"
+	t += "func (r *Repo) " + name + buildParams("ctx context.Context") + " *QueryResult { return r." + core + "(ctx, nil" + params + ") }\n\n"
 	return t
 }
@@ -1,6 +1,7 @@
 package template
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/rah-0/margo/conf"
@@ -9,12 +10,44 @@ import (
 
 func TestCreateGoFileEntity(t *testing.T) {
 	for _, tn := range tableNames {
-		tfs, err := db.GetDbTableFields(conn, tn)
+		tfs, err := db.GetDbTableFields(conn, conf.Args.DBName, tn)
 		if err != nil {
 			t.Fatal(err)
 		}
-		if err := CreateGoFileEntity(tn, tfs, []conf.NamedQuery{}); err != nil {
+		if err := CreateGoFileEntity(tn, tfs, []conf.NamedQuery{}, nil); err != nil {
 			t.Fatal(err)
 		}
 	}
 }
+
+func TestGetDBWrappers(t *testing.T) {
+	c := getDBWrappers("DBInsert", "insert", true, true)
+	for _, expected := range []string{
+		"func (x *Entity) DBInsert(params *QueryParams) *QueryResult { return defaultRepo.insert(nil, nil, x, params) }",
+		"func (x *Entity) DBInsertCtxTx(ctx context.Context, tx *sql.Tx, params *QueryParams) *QueryResult { return defaultRepo.insert(ctx, tx, x, params) }",
+		"func (r *Repo) DBInsert(ctx context.Context, x *Entity, params *QueryParams) *QueryResult { return r.insert(ctx, nil, x, params) }",
+	} {
+		if !strings.Contains(c, expected) {
+			t.Errorf("generated code lacks %q:\n%s", expected, c)
+		}
+	}
+
+	c = getDBWrappers("DBSelectAll", "selectAll", false, false)
+	if !strings.Contains(c, "func DBSelectAllTx(tx *sql.Tx) *QueryResult { return defaultRepo.selectAll(nil, tx) }") ||
+		!strings.Contains(c, "func (r *Repo) DBSelectAll(ctx context.Context) *QueryResult { return r.selectAll(ctx, nil) }") {
+		t.Errorf("unexpected wrappers:\n%s", c)
+	}
+
+	api := conf.Args.API
+	conf.Args.API = conf.APIContext
+	defer func() { conf.Args.API = api }()
+	c = getDBWrappers("DBInsert", "insert", true, true)
+	if strings.Contains(c, "DBInsertCtx") ||
+		!strings.Contains(c, "func (x *Entity) DBInsert(ctx context.Context, params *QueryParams) *QueryResult { return defaultRepo.insert(ctx, nil, x, params) }") {
+		t.Errorf("unexpected wrappers for the context API:\n%s", c)
+	}
+	c = getNamedQueryWrappers("QueryActive", "namedActive", false)
+	if strings.Count(c, "\n") != 3 || !strings.Contains(c, "func QueryActive(ctx context.Context) *QueryResult { return defaultRepo.namedActive(ctx, nil, nil) }") {
+		t.Errorf("unexpected named query wrappers for the context API:\n%s", c)
+	}
+}
@@ -0,0 +1,188 @@
+package template
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/rah-0/nabu"
+
+	"github.com/rah-0/margo/conf"
+)
+
+// VersionField returns the column DBUpdate compares and bumps for optimistic locking, nil when the table has none.
+func VersionField(rawTableName string, tfs []conf.TableField) (*conf.TableField, error) {
+	name, explicit := configuredColumn(rawTableName, conf.Args.VersionColumns)
+	if name == "" {
+		return nil, nil
+	}
+
+	for i, tf := range tfs {
+		if tf.Name != name {
+			continue
+		}
+		if !isCounter(tf) && !isTimestamp(tf) {
+			return nil, nabu.FromError(errors.New("version column must be an integer, timestamp or datetime")).WithArgs(rawTableName, name, tf.ColumnType).Log()
+		}
+		return &tfs[i], nil
+	}
+	if explicit {
+		return nil, nabu.FromError(errors.New("version column does not exist")).WithArgs(rawTableName, name).Log()
+	}
+	return nil, nil
+}
+
+// configuredColumn returns the column of a column or table.column list that applies to rawTableName. A table.column
+// entry wins over a bare column, which only applies to the tables that have it, and is reported as explicit.
+func configuredColumn(rawTableName string, entries []string) (name string, explicit bool) {
+	for _, c := range entries {
+		if table, column, ok := strings.Cut(c, "."); ok {
+			if table == rawTableName {
+				name, explicit = column, true
+			}
+		} else if !explicit {
+			name = c
+		}
+	}
+	return name, explicit
+}
+
+func isCounter(tf conf.TableField) bool {
+	switch strings.ToLower(tf.DataType) {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		return true
+	}
+	return false
+}
+
+func isTimestamp(tf conf.TableField) bool {
+	switch strings.ToLower(tf.DataType) {
+	case "timestamp", "datetime":
+		return true
+	}
+	return false
+}
+
+// timestampPrecision returns the fractional second digits of a timestamp or datetime column.
+func timestampPrecision(tf conf.TableField) int {
+	_, p, ok := strings.Cut(tf.ColumnType, "(")
+	if !ok {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(p, ")"))
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return min(n, 6)
+}
+
+// timestampLayout returns the Go layout of a timestamp or datetime column with the precision of its type, so that
+// the value written is the value read back.
+func timestampLayout(tf conf.TableField) string {
+	layout := "2006-01-02 15:04:05"
+	if p := timestampPrecision(tf); p > 0 {
+		layout += "." + strings.Repeat("0", p)
+	}
+	return layout
+}
+
+// timestampUnit returns the Go duration of the smallest step of a timestamp or datetime column.
+func timestampUnit(tf conf.TableField) string {
+	switch p := timestampPrecision(tf); p {
+	case 0:
+		return "time.Second"
+	case 3:
+		return "time.Millisecond"
+	case 6:
+		return "time.Microsecond"
+	default:
+		return "time.Duration(1" + strings.Repeat("0", 9-p) + ")"
+	}
+}
+
+// GetVersionFunctions returns the helpers of the update core for the version column vf.
+func GetVersionFunctions(vf *conf.TableField) string {
+	t := "// nextVersion returns the value DBUpdate sets Field" + vf.GoName + " to.\n"
+	t += "func nextVersion(v string) string {\n"
+	if isCounter(*vf) {
+		t += "	n, _ := strconv.ParseInt(v, 10, 64)\n"
+		t += "	return strconv.FormatInt(n+1, 10)\n"
+	} else {
+		t += "	return margort.NextTimestamp(v, \"" + timestampLayout(*vf) + "\", " + timestampUnit(*vf) + ")\n"
+	}
+	t += "}\n\n"
+
+	return t
+}
+
+// GetWithoutFieldFunction returns the helper that drops the columns DBUpdate and DBDelete manage from the params.
+func GetWithoutFieldFunction() string {
+	t := "func withoutField(fieldList []string, field string) []string {\n"
+	t += "	out := make([]string, 0, len(fieldList))\n"
+	t += "	for _, f := range fieldList {\n"
+	t += "		if f != field {\n"
+	t += "			out = append(out, f)\n"
+	t += "		}\n"
+	t += "	}\n"
+	t += "	return out\n"
+	t += "}\n\n"
+
+	return t
+}
+
+// getVersionUpdateCore returns the update core of a table with the version column vf: the row is only updated while
+// the column still holds the value read into the entity, <=> also matches NULL. The updated column uf, if any, is set
+// as well, and the soft delete column sf, if any, filters the rows like params.Deleted says. The new values are only
+// written back to the entity once exactly one row was updated, so that a stale entity is left as it was read.
+func getVersionUpdateCore(vf, uf, sf *conf.TableField) string {
+	f := "Field" + vf.GoName
+	t := "func (r *Repo) update(ctx context.Context, tx *sql.Tx, x *Entity, params *QueryParams) *QueryResult {\n"
+	t += "	if params == nil || len(params.Update) == 0 || len(params.Where) == 0 {\n"
+	t += "		return &QueryResult{Error: errors.New(\"DBUpdate requires both params.Update and params.Where to be specified\")}\n"
+	t += "	}\n"
+	t += "	tbl := r.fqtn(ctx)\n"
+	t += "	update := withoutField(params.Update, " + f + ")\n"
+	if uf != nil {
+		t += getUpdatedAtFill(uf)
+	}
+	t += "	where := withoutField(params.Where, " + f + ")\n"
+	t += "	next := nextVersion(x." + vf.GoName + ")\n"
+	t += "	var current any = x." + vf.GoName + "\n"
+	t += "	if x." + vf.GoName + " == \"\" {\n"
+	t += "		current = nil\n"
+	t += "	}\n"
+	t += "	set := qualifiedPlaceholders(tbl, update)\n"
+	t += "	vals := x.GetFieldsValues(update)\n"
+	if uf != nil {
+		t += "	set = append(set, qualifiedPlaceholder(tbl, Field" + uf.GoName + "))\n"
+		t += "	vals = append(vals, updatedAt)\n"
+	}
+	t += "	set = append(set, qualifiedPlaceholder(tbl, " + f + "))\n"
+	t += "	vals = append(vals, next)\n"
+	t += "	conds := append(qualifiedPlaceholders(tbl, where), qualifiedField(tbl, " + f + ")+\" <=> ?\")\n"
+	t += "	q := \"UPDATE \" + tbl + \" SET \" + strings.Join(set, \", \") + \" WHERE \" + strings.Join(conds, \" AND \")\n"
+	if sf != nil {
+		t += "	q = filterDeleted(tbl, q, true, params)\n"
+	}
+	t += "	vals = append(vals, x.GetFieldsValues(where)...)\n"
+	t += "	res, err := r.execCore(ctx, tx, q, append(vals, current)...)\n"
+	t += "	if err != nil {\n"
+	t += "		return &QueryResult{Result: res, Error: margort.WrapError(err, tbl, \"DBUpdate\")}\n"
+	t += "	}\n"
+	t += "	n, err := res.RowsAffected()\n"
+	t += "	if err != nil {\n"
+	t += "		return &QueryResult{Result: res, Error: margort.WrapError(err, tbl, \"DBUpdate\")}\n"
+	t += "	}\n"
+	t += "	if n == 0 {\n"
+	t += "		return &QueryResult{Result: res, Error: margort.WrapError(margort.ErrStaleEntity, tbl, \"DBUpdate\")}\n"
+	t += "	}\n"
+	t += "	if n == 1 {\n"
+	t += "		x." + vf.GoName + " = next\n"
+	if uf != nil {
+		t += "		x." + uf.GoName + " = updatedAt\n"
+	}
+	t += "	}\n"
+	t += "	return &QueryResult{Result: res}\n"
+	t += "}\n\n"
+	return t
+}
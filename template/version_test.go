@@ -0,0 +1,100 @@
+package template
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rah-0/margo/conf"
+)
+
+func TestVersionField(t *testing.T) {
+	defer func(v []string) { conf.Args.VersionColumns = v }(conf.Args.VersionColumns)
+
+	tfs := []conf.TableField{
+		{Name: "id", DataType: "int", ColumnType: "int(11)"},
+		{Name: "version", DataType: "bigint", ColumnType: "bigint(20)"},
+		{Name: "last_update", DataType: "timestamp", ColumnType: "timestamp(6)"},
+		{Name: "name", DataType: "varchar", ColumnType: "varchar(50)"},
+	}
+
+	tests := []struct {
+		columns  []string
+		expected string
+		fails    bool
+	}{
+		{nil, "", false},
+		{[]string{"version"}, "version", false},
+		{[]string{"rev"}, "", false},
+		{[]string{"version", "users.last_update"}, "last_update", false},
+		{[]string{"users.last_update", "version"}, "last_update", false},
+		{[]string{"orders.last_update"}, "", false},
+		{[]string{"users.rev"}, "", true},
+		{[]string{"name"}, "", true},
+	}
+	for _, tt := range tests {
+		conf.Args.VersionColumns = tt.columns
+		vf, err := VersionField("users", tfs)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("%v: expected an error", tt.columns)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.columns, err)
+			continue
+		}
+		name := ""
+		if vf != nil {
+			name = vf.Name
+		}
+		if name != tt.expected {
+			t.Errorf("%v: got %q, expected %q", tt.columns, name, tt.expected)
+		}
+	}
+}
+
+func TestTimestampLayout(t *testing.T) {
+	tests := map[string]string{
+		"timestamp":    "2006-01-02 15:04:05",
+		"datetime(0)":  "2006-01-02 15:04:05",
+		"datetime(3)":  "2006-01-02 15:04:05.000",
+		"timestamp(6)": "2006-01-02 15:04:05.000000",
+	}
+	for columnType, expected := range tests {
+		if got := timestampLayout(conf.TableField{ColumnType: columnType}); got != expected {
+			t.Errorf("%s: got %q, expected %q", columnType, got, expected)
+		}
+	}
+}
+
+func TestGetVersionFunctions(t *testing.T) {
+	tests := map[string]string{
+		"timestamp":    `return margort.NextTimestamp(v, "2006-01-02 15:04:05", time.Second)`,
+		"datetime(6)":  `return margort.NextTimestamp(v, "2006-01-02 15:04:05.000000", time.Microsecond)`,
+		"timestamp(2)": `return margort.NextTimestamp(v, "2006-01-02 15:04:05.00", time.Duration(10000000))`,
+		"bigint(20)":   "return strconv.FormatInt(n+1, 10)",
+	}
+	for columnType, expected := range tests {
+		vf := conf.TableField{Name: "version", GoName: "Version", DataType: strings.Split(columnType, "(")[0], ColumnType: columnType}
+		if c := GetVersionFunctions(&vf); !strings.Contains(c, expected) {
+			t.Errorf("%s: generated code lacks %q:\n%s", columnType, expected, c)
+		}
+	}
+}
+
+func TestGetVersionUpdateCore(t *testing.T) {
+	vf := conf.TableField{Name: "version", GoName: "Version", DataType: "bigint", ColumnType: "bigint(20)"}
+	uf := conf.TableField{Name: "updated_at", GoName: "UpdatedAt", DataType: "timestamp", ColumnType: "timestamp"}
+	c := getVersionUpdateCore(&vf, &uf, nil)
+
+	stale := strings.Index(c, "margort.ErrStaleEntity")
+	for _, assign := range []string{"x.Version = next", "x.UpdatedAt = updatedAt"} {
+		if i := strings.Index(c, assign); i < stale {
+			t.Errorf("%q is not set after the stale check:\n%s", assign, c)
+		}
+	}
+	if strings.Count(c, "x.UpdatedAt =") != 1 {
+		t.Errorf("the updated column is set before the update:\n%s", c)
+	}
+}
@@ -0,0 +1,98 @@
+package template
+
+import "strings"
+
+// getWhereConds returns whereConds, which renders params.Conds of DBDeleteWhere and DBUpdateWhere, see margort.Where.
+func getWhereConds() string {
+	t := "func whereConds(tbl string, conds []margort.Cond) (string, []any, error) {\n"
+	t += "	return margort.Where(conds, func(field string) string { return qualifiedField(tbl, field) })\n"
+	t += "}\n\n"
+	return t
+}
+
+// getDeleteWhereCore returns the core of DBDeleteWhere, which deletes the rows matching params.Conds, or soft deletes
+// them on a table with a soft delete column.
+func getDeleteWhereCore(mf ManagedFields) string {
+	t := getWhereConds()
+	t += "func (r *Repo) deleteWhere(ctx context.Context, tx *sql.Tx, params *QueryParams) *QueryResult {\n"
+	t += "	tbl := r.fqtn(ctx)\n"
+	if mf.SoftDelete != nil {
+		t += getSoftDeleteReturningCheck("DBDeleteWhere")
+	}
+	t += "	if params == nil {\n"
+	t += "		params = &QueryParams{}\n"
+	t += "	}\n"
+	t += "	where, args, err := whereConds(tbl, params.Conds)\n"
+	t += "	if err != nil {\n"
+	t += "		return &QueryResult{Error: margort.WrapError(err, tbl, \"DBDeleteWhere\")}\n"
+	t += "	}\n"
+	if sf := mf.SoftDelete; sf != nil {
+		f := "Field" + sf.GoName
+		t += "	q := \"UPDATE \" + tbl + \" SET \" + qualifiedField(tbl, " + f + ") + \" = " + softDeleteNow(sf) + " WHERE \" + where + \" AND \" + qualifiedField(tbl, " + f + ") + \" IS NULL\"\n"
+		t += "	res, err := r.execCore(ctx, tx, q, args...)\n"
+		t += "	return &QueryResult{Result: res, Error: margort.WrapError(err, tbl, \"DBDeleteWhere\")}\n"
+	} else {
+		t += "	q := \"DELETE FROM \" + tbl + \" WHERE \" + where\n"
+		t += getDeleteExec("DBDeleteWhere", "args...")
+	}
+	t += "}\n\n"
+	return t
+}
+
+// getUpdateWhereCore returns the core of DBUpdateWhere, which assigns params.Set to the rows matching params.Conds.
+// The version column is bumped and the updated column set, as in DBUpdate, whatever params.Set says about them, and
+// soft deleted rows are left alone unless params.Deleted says otherwise.
+func getUpdateWhereCore(mf ManagedFields) string {
+	t := "func (r *Repo) updateWhere(ctx context.Context, tx *sql.Tx, params *QueryParams) *QueryResult {\n"
+	t += "	tbl := r.fqtn(ctx)\n"
+	t += "	if params == nil || len(params.Set) == 0 {\n"
+	t += "		return &QueryResult{Error: margort.WrapError(errors.New(\"params.Set must not be empty\"), tbl, \"DBUpdateWhere\")}\n"
+	t += "	}\n"
+	t += "	where, args, err := whereConds(tbl, params.Conds)\n"
+	t += "	if err != nil {\n"
+	t += "		return &QueryResult{Error: margort.WrapError(err, tbl, \"DBUpdateWhere\")}\n"
+	t += "	}\n"
+	t += "	set := make([]string, 0, len(params.Set)+2)\n"
+	t += "	vals := make([]any, 0, len(params.Set)+2+len(args))\n"
+	t += "	for _, a := range params.Set {\n"
+	var managed []string
+	if mf.Version != nil {
+		managed = append(managed, "a.Field == Field"+mf.Version.GoName)
+	}
+	if mf.Updated != nil {
+		managed = append(managed, "a.Field == Field"+mf.Updated.GoName)
+	}
+	if len(managed) > 0 {
+		t += "		if " + strings.Join(managed, " || ") + " {\n"
+		t += "			continue\n"
+		t += "		}\n"
+	}
+	t += "		column := qualifiedField(tbl, a.Field)\n"
+	t += "		if column == \"\" {\n"
+	t += "			return &QueryResult{Error: margort.WrapError(errors.New(\"unknown field \"+a.Field), tbl, \"DBUpdateWhere\")}\n"
+	t += "		}\n"
+	t += "		set = append(set, column+\" = ?\")\n"
+	t += "		vals = append(vals, a.Value)\n"
+	t += "	}\n"
+	if vf := mf.Version; vf != nil {
+		f := "Field" + vf.GoName
+		if isCounter(*vf) {
+			t += "	set = append(set, qualifiedField(tbl, " + f + ")+\" = \"+qualifiedField(tbl, " + f + ")+\" + 1\")\n"
+		} else {
+			t += "	set = append(set, qualifiedPlaceholder(tbl, " + f + "))\n"
+			t += "	vals = append(vals, nextVersion(\"\"))\n"
+		}
+	}
+	if uf := mf.Updated; uf != nil {
+		t += "	set = append(set, qualifiedPlaceholder(tbl, Field" + uf.GoName + "))\n"
+		t += "	vals = append(vals, margort.Now().Format(\"" + timestampLayout(*uf) + "\"))\n"
+	}
+	t += "	q := \"UPDATE \" + tbl + \" SET \" + strings.Join(set, \", \") + \" WHERE \" + where\n"
+	if mf.SoftDelete != nil {
+		t += "	q = filterDeleted(tbl, q, true, params)\n"
+	}
+	t += "	res, err := r.execCore(ctx, tx, q, append(vals, args...)...)\n"
+	t += "	return &QueryResult{Result: res, Error: margort.WrapError(err, tbl, \"DBUpdateWhere\")}\n"
+	t += "}\n\n"
+	return t
+}
@@ -0,0 +1,23 @@
+package template
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetDeleteWhereCore(t *testing.T) {
+	c := getDeleteWhereCore(ManagedFields{})
+	for _, expected := range []string{
+		"return margort.Where(conds, func(field string) string { return qualifiedField(tbl, field) })",
+		"where, args, err := whereConds(tbl, params.Conds)",
+		"q := \"DELETE FROM \" + tbl + \" WHERE \" + where",
+	} {
+		if !strings.Contains(c, expected) {
+			t.Errorf("generated code lacks %q:\n%s", expected, c)
+		}
+	}
+	// an invalid condition fails before the statement is built
+	if fail, q := strings.Index(c, "return &QueryResult{Error: margort.WrapError(err, tbl, \"DBDeleteWhere\")}"), strings.Index(c, "q := "); fail < 0 || fail > q {
+		t.Errorf("the conditions are not checked first:\n%s", c)
+	}
+}
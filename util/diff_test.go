@@ -0,0 +1,38 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	t.Run("equal content", func(t *testing.T) {
+		if d := UnifiedDiff("a", "b", "x\ny\n", "x\ny\n"); d != "" {
+			t.Errorf("expected empty diff, got:\n%s", d)
+		}
+	})
+
+	t.Run("new file", func(t *testing.T) {
+		expected := "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n"
+		if d := UnifiedDiff("a", "b", "", "x\ny\n"); d != expected {
+			t.Errorf("expected:\n%q\ngot:\n%q", expected, d)
+		}
+	})
+
+	t.Run("single change with context", func(t *testing.T) {
+		a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+		b := "1\n2\n3\n4\nfive\n6\n7\n8\n9\n"
+		expected := "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n"
+		if d := UnifiedDiff("a", "b", a, b); d != expected {
+			t.Errorf("expected:\n%q\ngot:\n%q", expected, d)
+		}
+	})
+
+	t.Run("separate hunks", func(t *testing.T) {
+		a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+		b := "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n"
+		expected := "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n"
+		if d := UnifiedDiff("a", "b", a, b); d != expected {
+			t.Errorf("expected:\n%q\ngot:\n%q", expected, d)
+		}
+	})
+}
@@ -11,7 +11,47 @@ import (
 	"github.com/rah-0/nabu"
 )
 
-func EnsureDir(path string) error {
+const (
+	FileCreated   = "created"
+	FileModified  = "modified"
+	FileUnchanged = "unchanged"
+	FileDeleted   = "deleted"
+)
+
+// FileChange records what WriteGoFile did (or would do in -dryRun/-check mode) to a file.
+type FileChange struct {
+	Path   string
+	Status string
+	Diff   string // unified diff against the existing file, only set in -dryRun/-check mode
+}
+
+var fileChanges []FileChange
+
+// FileChanges returns every file passed to WriteGoFile since the last ResetFileChanges.
+func FileChanges() []FileChange {
+	return fileChanges
+}
+
+// StaleFiles returns the files whose content differs from the generated output.
+func StaleFiles() []FileChange {
+	var stale []FileChange
+	for _, fc := range fileChanges {
+		if fc.Status != FileUnchanged {
+			stale = append(stale, fc)
+		}
+	}
+	return stale
+}
+
+func ResetFileChanges() {
+	fileChanges = nil
+}
+
+// EnsureDir creates the directory path, unless preview is set (-dryRun or -check).
+func EnsureDir(path string, preview bool) error {
+	if preview {
+		return nil
+	}
 	// MkdirAll does nothing if the path already exists as a dir
 	if err := os.MkdirAll(path, 0755); err != nil {
 		return nabu.FromError(err).WithArgs(path).Log()
@@ -19,11 +59,34 @@ func EnsureDir(path string) error {
 	return nil
 }
 
-func WriteGoFile(path string, content string) error {
+// WriteGoFile writes content formatted to path and records the change, see FileChanges. With preview set
+// (-dryRun or -check) the file is only compared to content.
+func WriteGoFile(path string, content string, preview bool) error {
 	formatted, err := format.Source([]byte(content))
 	if err != nil {
 		return nabu.FromError(err).WithArgs(path).Log()
 	}
+
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nabu.FromError(err).WithArgs(path).Log()
+	}
+
+	fc := FileChange{Path: path, Status: FileModified}
+	if err != nil {
+		fc.Status = FileCreated
+	} else if string(existing) == string(formatted) {
+		fc.Status = FileUnchanged
+	}
+	if preview {
+		fc.Diff = UnifiedDiff("a/"+filepath.ToSlash(path), "b/"+filepath.ToSlash(path), string(existing), string(formatted))
+	}
+	fileChanges = append(fileChanges, fc)
+
+	if preview || fc.Status == FileUnchanged {
+		return nil
+	}
+
 	err = os.WriteFile(path, formatted, 0644)
 	if err != nil {
 		return nabu.FromError(err).Log()
@@ -31,6 +94,57 @@ func WriteGoFile(path string, content string) error {
 	return nil
 }
 
+// RemoveFile deletes a previously generated file, recording the change like WriteGoFile does.
+func RemoveFile(path string, preview bool) error {
+	existing, err := os.ReadFile(path)
+	if err != nil {
+		return nabu.FromError(err).WithArgs(path).Log()
+	}
+
+	fc := FileChange{Path: path, Status: FileDeleted}
+	if preview {
+		fc.Diff = UnifiedDiff("a/"+filepath.ToSlash(path), "b/"+filepath.ToSlash(path), string(existing), "")
+	}
+	fileChanges = append(fileChanges, fc)
+
+	if preview {
+		return nil
+	}
+	if err = os.Remove(path); err != nil {
+		return nabu.FromError(err).WithArgs(path).Log()
+	}
+	return nil
+}
+
+// RemoveEmptyParents removes the directories of files that are empty once the files were removed, and then their
+// parents that are empty in turn, up to root, which is kept.
+func RemoveEmptyParents(root string, files []string, preview bool) error {
+	if preview {
+		return nil
+	}
+
+	root = filepath.Clean(root)
+	for _, f := range files {
+		for dir := filepath.Dir(filepath.Clean(f)); strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+			entries, err := os.ReadDir(dir)
+			if os.IsNotExist(err) {
+				// removed with an earlier file
+				continue
+			}
+			if err != nil {
+				return nabu.FromError(err).WithArgs(dir).Log()
+			}
+			if len(entries) > 0 {
+				break
+			}
+			if err = os.Remove(dir); err != nil {
+				return nabu.FromError(err).WithArgs(dir).Log()
+			}
+		}
+	}
+	return nil
+}
+
 func ReadFileAsString(path string) (string, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
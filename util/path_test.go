@@ -13,7 +13,7 @@ func TestEnsureDir(t *testing.T) {
 		base := t.TempDir()
 		target := filepath.Join(base, "nested", "structure", "final")
 
-		if err := EnsureDir(target); err != nil {
+		if err := EnsureDir(target, false); err != nil {
 			t.Fatalf("failed to create nested directory: %v", err)
 		}
 
@@ -29,7 +29,7 @@ func TestEnsureDir(t *testing.T) {
 	t.Run("ensure existing directory", func(t *testing.T) {
 		existing := t.TempDir()
 
-		if err := EnsureDir(existing); err != nil {
+		if err := EnsureDir(existing, false); err != nil {
 			t.Fatalf("EnsureDir failed on existing dir: %v", err)
 		}
 	})
@@ -46,7 +46,7 @@ func TestWriteGoFile(t *testing.T) {
 		input := "package main\n\nfunc main(){println(\"hello\")}"
 		expected, _ := format.Source([]byte(input))
 
-		err = WriteGoFile(tmpFile.Name(), input)
+		err = WriteGoFile(tmpFile.Name(), input, false)
 		if err != nil {
 			t.Fatalf("WriteGoFile failed: %v", err)
 		}
@@ -67,12 +67,12 @@ func TestWriteGoFile(t *testing.T) {
 		first := "package main\n\nfunc main(){println(\"first\")}"
 		second := "package main\n\nfunc main(){println(\"second\")}"
 
-		err := WriteGoFile(path, first)
+		err := WriteGoFile(path, first, false)
 		if err != nil {
 			t.Fatalf("First write failed: %v", err)
 		}
 
-		err = WriteGoFile(path, second)
+		err = WriteGoFile(path, second, false)
 		if err != nil {
 			t.Fatalf("Second write failed: %v", err)
 		}
@@ -89,6 +89,59 @@ func TestWriteGoFile(t *testing.T) {
 	})
 }
 
+func TestWriteGoFilePreview(t *testing.T) {
+	defer ResetFileChanges()
+
+	t.Run("NewFileIsNotCreated", func(t *testing.T) {
+		ResetFileChanges()
+		path := filepath.Join(t.TempDir(), "new.go")
+
+		if err := WriteGoFile(path, "package main", true); err != nil {
+			t.Fatalf("WriteGoFile failed: %v", err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to exist in dry-run mode", path)
+		}
+
+		changes := FileChanges()
+		if len(changes) != 1 || changes[0].Status != FileCreated {
+			t.Fatalf("expected one created file, got %+v", changes)
+		}
+		if !strings.Contains(changes[0].Diff, "+package main") {
+			t.Errorf("expected diff to add the package clause, got:\n%s", changes[0].Diff)
+		}
+	})
+
+	t.Run("ExistingFileIsCompared", func(t *testing.T) {
+		ResetFileChanges()
+		path := filepath.Join(t.TempDir(), "existing.go")
+		current, _ := format.Source([]byte("package main\n\nfunc main(){println(\"first\")}"))
+		if err := os.WriteFile(path, current, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := WriteGoFile(path, string(current), true); err != nil {
+			t.Fatalf("WriteGoFile failed: %v", err)
+		}
+		if err := WriteGoFile(path, "package main\n\nfunc main(){println(\"second\")}", true); err != nil {
+			t.Fatalf("WriteGoFile failed: %v", err)
+		}
+
+		changes := FileChanges()
+		if len(changes) != 2 || changes[0].Status != FileUnchanged || changes[1].Status != FileModified {
+			t.Fatalf("expected unchanged then modified, got %+v", changes)
+		}
+		if len(StaleFiles()) != 1 {
+			t.Errorf("expected 1 stale file, got %d", len(StaleFiles()))
+		}
+
+		data, _ := os.ReadFile(path)
+		if string(data) != string(current) {
+			t.Errorf("file was modified in dry-run mode")
+		}
+	})
+}
+
 func TestGetGoModuleImportPath(t *testing.T) {
 	tmpRoot, err := os.MkdirTemp("", "gomodtest")
 	if err != nil {
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"runtime/debug"
+	"strings"
+)
+
+// version can be set at build time with -ldflags "-X main.version=v1.2.3", otherwise the module version is used.
+var version = ""
+
+func versionInfo() string {
+	v := version
+	info, ok := debug.ReadBuildInfo()
+	if v == "" && ok {
+		v = info.Main.Version
+	}
+	if v == "" {
+		v = "(devel)"
+	}
+
+	t := "margo " + v + "\n"
+	if !ok {
+		return t
+	}
+
+	t += "go: " + info.GoVersion + "\n"
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision", "vcs.time", "vcs.modified":
+			t += strings.TrimPrefix(s.Key, "vcs.") + ": " + s.Value + "\n"
+		}
+	}
+
+	return t
+}